@@ -0,0 +1,621 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mysql-backup/config"
+	"mysql-backup/handlers"
+	"mysql-backup/models"
+	"mysql-backup/services"
+	"mysql-backup/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// 命令行退出码
+const (
+	exitOK           = 0
+	exitFailure      = 1 // 操作失败
+	exitUsage        = 2 // 参数错误
+	exitVerifyFailed = 3 // 备份文件校验未通过
+)
+
+// 输出格式
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands 按帮助信息中的顺序排列，serve 在 main 中单独处理
+var commands = []command{
+	{"serve", "启动 Web 服务和定时任务（默认）", nil},
+	{"backup", "立即备份数据库", runBackup},
+	{"restore", "从备份恢复数据库", runRestore},
+	{"list", "列出备份记录", runList},
+	{"settings", "列出数据库配置", runSettings},
+	{"schedules", "列出定时任务", runSchedules},
+	{"verify", "校验备份文件的大小和校验和", runVerify},
+	{"prune", "按保留策略清理旧备份", runPrune},
+}
+
+// runCommand 执行子命令并返回退出码
+func runCommand(name string, args []string) int {
+	if name == "help" {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name == name && cmd.run != nil {
+			return cmd.run(args)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", name)
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "用法: %s [命令] [选项]\n", progName())
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "命令:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "使用 %s <命令> -h 查看命令的选项\n", progName())
+}
+
+// progName 帮助信息中显示的程序名
+func progName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// cliFlags 子命令的参数集合，包含配置相关参数和 -output
+type cliFlags struct {
+	fs     *flag.FlagSet
+	config *config.Flags
+	output *string
+}
+
+func newCLIFlags(name, usage string) *cliFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	f := &cliFlags{fs: fs, config: config.AddFlags(fs)}
+	f.output = fs.String("output", outputTable, "输出格式：table 或 json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: %s %s\n\n选项:\n", progName(), usage)
+		fs.PrintDefaults()
+	}
+	return f
+}
+
+// parse 解析参数，返回值不小于 0 时命令应直接以该退出码结束（如 -h 或参数错误）
+func (f *cliFlags) parse(args []string) int {
+	if err := f.fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if f.fs.NArg() > 0 {
+		return usageError("多余的参数: %s", strings.Join(f.fs.Args(), " "))
+	}
+	if *f.output != outputTable && *f.output != outputJSON {
+		return usageError("无效的输出格式 %q，可选 table 或 json", *f.output)
+	}
+	if f.config.PrintConfig {
+		cfg, err := f.config.Load()
+		if err != nil {
+			return failure(fmt.Errorf("配置无效: %v", err))
+		}
+		if err := cfg.Print(os.Stdout); err != nil {
+			return failure(err)
+		}
+		return exitOK
+	}
+	return -1
+}
+
+// cliEnv 子命令使用的配置、存储和服务
+type cliEnv struct {
+	cfg      *config.Config
+	store    *storage.BoltStore
+	backup   *services.BackupService
+	audit    *services.AuditService
+	webhooks *services.WebhookService
+	email    *services.EmailService // 未配置 SMTP 时为 nil
+	output   string
+}
+
+// open 加载配置并打开存储。Web 服务运行时数据库文件被锁定，命令会在超时后报错
+func (f *cliFlags) open() (*cliEnv, error) {
+	cfg, err := f.config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("配置无效: %v", err)
+	}
+	setupLogging(cfg)
+	time.Local = cfg.Location()
+
+	if _, err := os.Stat(cfg.DBPath()); err != nil {
+		return nil, fmt.Errorf("找不到数据文件 %s，请通过 -data-dir 或配置文件指定数据目录: %v", cfg.DBPath(), err)
+	}
+	store, _, _, err := openStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%v（Web 服务正在运行时数据文件被锁定，请先停止服务）", err)
+	}
+	backup := services.NewBackupService(cfg)
+	env := &cliEnv{
+		cfg:      cfg,
+		store:    store,
+		backup:   backup,
+		audit:    services.NewAuditService(store),
+		webhooks: services.NewWebhookService(store, backup.Events()),
+		output:   *f.output,
+	}
+	if cfg.SMTP.Enabled() {
+		if env.email, err = services.NewEmailService(&cfg.SMTP, store, backup.Events()); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// cliNotifyTimeout 命令退出前等待 Webhook 通知的时长，超时后放弃重试，避免脚本长时间挂起
+const cliNotifyTimeout = 15 * time.Second
+
+// Close 等待 Webhook 通知（最多 cliNotifyTimeout）和告警邮件发送完成后关闭存储
+func (e *cliEnv) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), cliNotifyTimeout)
+	defer cancel()
+	e.webhooks.Shutdown(ctx)
+	if e.email != nil {
+		e.email.Wait()
+	}
+	e.store.Close()
+}
+
+// findSetting 按 ID 或名称查找数据库配置
+func (e *cliEnv) findSetting(ref string) (*models.DBSettings, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		setting, err := e.store.GetSettingByID(id)
+		if err != nil {
+			return nil, fmt.Errorf("数据库配置 %d 不存在", id)
+		}
+		return setting, nil
+	}
+
+	settings, err := e.store.GetAllSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取配置失败: %v", err)
+	}
+	for i := range settings {
+		if settings[i].Name == ref {
+			return &settings[i], nil
+		}
+	}
+	return nil, fmt.Errorf("数据库配置 %q 不存在", ref)
+}
+
+// print 按输出格式打印结果，表格格式时输出表头并由 rows 写入各行
+func (e *cliEnv) print(v interface{}, header string, rows func(w io.Writer)) {
+	if e.output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(v)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	rows(w)
+	w.Flush()
+}
+
+func usageError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	return exitUsage
+}
+
+func failure(err error) int {
+	fmt.Fprintln(os.Stderr, "错误:", err)
+	return exitFailure
+}
+
+// BackupResult backup 命令中单个数据库的备份结果
+type BackupResult struct {
+	Database string               `json:"database"`
+	Record   *models.BackupRecord `json:"record,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+func runBackup(args []string) int {
+	f := newCLIFlags("backup", "backup -setting <ID或名称> (-database <库名,...> | -all)")
+	settingRef := f.fs.String("setting", "", "数据库配置的 ID 或名称")
+	databases := f.fs.String("database", "", "要备份的数据库，多个用逗号分隔")
+	all := f.fs.Bool("all", false, "备份该配置下的所有数据库（不含系统库）")
+	if code := f.parse(args); code >= 0 {
+		return code
+	}
+	if *settingRef == "" {
+		return usageError("请通过 -setting 指定数据库配置")
+	}
+	if (*databases == "") == !*all {
+		return usageError("请通过 -database 或 -all 指定要备份的数据库")
+	}
+
+	env, err := f.open()
+	if err != nil {
+		return failure(err)
+	}
+	defer env.Close()
+
+	setting, err := env.findSetting(*settingRef)
+	if err != nil {
+		return failure(err)
+	}
+
+	var names []string
+	if *all {
+		if names, err = env.backup.GetDatabasesWithConfig(setting); err != nil {
+			return failure(err)
+		}
+	} else {
+		for _, name := range strings.Split(*databases, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+
+	code := exitOK
+	results := make([]BackupResult, 0, len(names))
+	for _, name := range names {
+		record, err := env.backup.BackupDatabaseWithConfig(setting, name, env.store)
+		env.audit.Record(services.AuditActorCLI, "", "backup.create", "database:"+name, setting.ID, nil, err)
+		result := BackupResult{Database: name, Record: record}
+		if err != nil {
+			result.Error = err.Error()
+			code = exitFailure
+		}
+		results = append(results, result)
+	}
+
+	env.print(results, "DATABASE\tRECORD\tSTATUS\tSIZE\tFILE\tERROR", func(w io.Writer) {
+		for _, result := range results {
+			id, status, size, file := "-", models.StatusFailed, "-", "-"
+			if result.Record != nil {
+				id, status = strconv.Itoa(result.Record.ID), result.Record.Status
+				size, file = strconv.FormatInt(result.Record.Size, 10), result.Record.FileName
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", result.Database, id, status, size, file, result.Error)
+		}
+	})
+	return code
+}
+
+func runRestore(args []string) int {
+	f := newCLIFlags("restore", "restore -record <备份ID> [-target <库名>] [-drop-existing] -yes")
+	recordID := f.fs.Int("record", 0, "要恢复的备份记录 ID")
+	target := f.fs.String("target", "", "恢复到的数据库，默认恢复到原数据库")
+	dropExisting := f.fs.Bool("drop-existing", false, "恢复前删除同名表")
+	yes := f.fs.Bool("yes", false, "确认恢复，恢复会覆盖目标数据库中的数据")
+	if code := f.parse(args); code >= 0 {
+		return code
+	}
+	if *recordID <= 0 {
+		return usageError("请通过 -record 指定备份记录")
+	}
+	if !*yes {
+		return usageError("恢复会覆盖目标数据库中的数据，确认后请加上 -yes")
+	}
+
+	env, err := f.open()
+	if err != nil {
+		return failure(err)
+	}
+	defer env.Close()
+
+	record, err := env.store.GetBackupRecordByID(*recordID)
+	if err != nil {
+		return failure(fmt.Errorf("备份记录 %d 不存在", *recordID))
+	}
+	setting, err := env.store.GetSettingByID(record.SettingID)
+	if err != nil {
+		return failure(fmt.Errorf("获取配置失败: %v", err))
+	}
+
+	req := models.RestoreRequest{TargetDatabase: *target, DropExisting: *dropExisting}
+	err = env.backup.RestoreBackup(setting, record, req.TargetDatabase, req.DropExisting)
+	env.audit.Record(services.AuditActorCLI, "", "backup.restore", fmt.Sprintf("backup:%d", record.ID), setting.ID, services.DiffChanges(nil, req), err)
+	if err != nil {
+		return failure(err)
+	}
+
+	if req.TargetDatabase == "" {
+		req.TargetDatabase = record.DBName
+	}
+	result := RestoreResult{RecordID: record.ID, Database: req.TargetDatabase, Message: "恢复完成"}
+	env.print(result, "RECORD\tDATABASE\tRESULT", func(w io.Writer) {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", result.RecordID, result.Database, result.Message)
+	})
+	return exitOK
+}
+
+// RestoreResult restore 命令的输出
+type RestoreResult struct {
+	RecordID int    `json:"recordId"`
+	Database string `json:"database"`
+	Message  string `json:"message"`
+}
+
+func runList(args []string) int {
+	f := newCLIFlags("list", "list [-setting <ID或名称>] [-database <库名>] [-status <状态>] [-limit N]")
+	settingRef := f.fs.String("setting", "", "只列出该配置的备份")
+	database := f.fs.String("database", "", "只列出该数据库的备份")
+	status := f.fs.String("status", "", "只列出该状态的备份：completed、failed、in_progress 或 pruned")
+	limit := f.fs.Int("limit", 0, "最多列出的记录数，0 表示不限制")
+	if code := f.parse(args); code >= 0 {
+		return code
+	}
+
+	env, err := f.open()
+	if err != nil {
+		return failure(err)
+	}
+	defer env.Close()
+
+	settingID := 0
+	if *settingRef != "" {
+		setting, err := env.findSetting(*settingRef)
+		if err != nil {
+			return failure(err)
+		}
+		settingID = setting.ID
+	}
+
+	records, err := env.store.GetBackupRecords()
+	if err != nil {
+		return failure(fmt.Errorf("获取备份记录失败: %v", err))
+	}
+	filtered := make([]*models.BackupRecord, 0, len(records))
+	for _, record := range records {
+		if (settingID != 0 && record.SettingID != settingID) ||
+			(*database != "" && record.DBName != *database) ||
+			(*status != "" && record.Status != *status) {
+			continue
+		}
+		filtered = append(filtered, record)
+		if *limit > 0 && len(filtered) == *limit {
+			break
+		}
+	}
+
+	env.print(filtered, "ID\tSETTING\tDATABASE\tCREATED\tSTATUS\tSIZE\tPINNED\tFILE", func(w io.Writer) {
+		for _, record := range filtered {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%d\t%t\t%s\n", record.ID, record.SettingID, record.DBName,
+				record.CreatedAt, record.Status, record.Size, record.Pinned, record.FileName)
+		}
+	})
+	return exitOK
+}
+
+func runSettings(args []string) int {
+	f := newCLIFlags("settings", "settings")
+	if code := f.parse(args); code >= 0 {
+		return code
+	}
+
+	env, err := f.open()
+	if err != nil {
+		return failure(err)
+	}
+	defer env.Close()
+
+	settings, err := env.store.GetAllSettings()
+	if err != nil {
+		return failure(fmt.Errorf("获取配置失败: %v", err))
+	}
+	// 与 Web 接口一样不输出密码和私钥
+	responses := make([]handlers.SettingsResponse, 0, len(settings))
+	for i := range settings {
+		responses = append(responses, handlers.ToSettingsResponse(&settings[i]))
+	}
+
+	env.print(responses, "ID\tNAME\tADDRESS\tUSER\tBACKUP_DIR", func(w io.Writer) {
+		for _, setting := range responses {
+			address := setting.Socket
+			if address == "" {
+				address = fmt.Sprintf("%s:%d", setting.Host, setting.Port)
+			}
+			if setting.SSH != nil {
+				address += fmt.Sprintf(" (via %s)", setting.SSH.Host)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", setting.ID, setting.Name, address, setting.User, setting.BackupDir)
+		}
+	})
+	return exitOK
+}
+
+// ScheduleInfo schedules 命令输出的定时任务
+type ScheduleInfo struct {
+	models.ScheduledTask
+	SettingName string `json:"settingName"`
+	NextRun     string `json:"nextRun,omitempty"`
+}
+
+func runSchedules(args []string) int {
+	f := newCLIFlags("schedules", "schedules")
+	if code := f.parse(args); code >= 0 {
+		return code
+	}
+
+	env, err := f.open()
+	if err != nil {
+		return failure(err)
+	}
+	defer env.Close()
+
+	// 直接读取存储中的任务，不启动定时器，避免在命令执行期间触发备份
+	tasks, err := env.store.GetAllSchedules()
+	if err != nil {
+		return failure(fmt.Errorf("获取定时任务失败: %v", err))
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+
+	infos := make([]ScheduleInfo, 0, len(tasks))
+	for _, task := range tasks {
+		info := ScheduleInfo{ScheduledTask: *task}
+		if setting, err := env.store.GetSettingByID(task.SettingID); err == nil {
+			info.SettingName = setting.Name
+		}
+		if schedule, err := cron.ParseStandard(task.Schedule); err == nil {
+			info.NextRun = schedule.Next(time.Now()).Format("2006-01-02 15:04:05")
+		}
+		infos = append(infos, info)
+	}
+
+	env.print(infos, "ID\tSETTING\tDATABASE\tSCHEDULE\tNEXT_RUN", func(w io.Writer) {
+		for _, info := range infos {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", info.ID, info.SettingName, info.Database, info.Schedule, info.NextRun)
+		}
+	})
+	return exitOK
+}
+
+func runVerify(args []string) int {
+	f := newCLIFlags("verify", "verify [-record <备份ID>] [-setting <ID或名称>]")
+	recordID := f.fs.Int("record", 0, "只校验该备份记录，默认校验所有已完成的备份")
+	settingRef := f.fs.String("setting", "", "只校验该配置的备份")
+	if code := f.parse(args); code >= 0 {
+		return code
+	}
+
+	env, err := f.open()
+	if err != nil {
+		return failure(err)
+	}
+	defer env.Close()
+
+	var records []*models.BackupRecord
+	if *recordID > 0 {
+		record, err := env.store.GetBackupRecordByID(*recordID)
+		if err != nil {
+			return failure(fmt.Errorf("备份记录 %d 不存在", *recordID))
+		}
+		if record.Status != models.StatusCompleted {
+			return failure(fmt.Errorf("备份记录 %d 的状态为 %s，只能校验已完成的备份", record.ID, record.Status))
+		}
+		records = append(records, record)
+	} else {
+		settingID := 0
+		if *settingRef != "" {
+			setting, err := env.findSetting(*settingRef)
+			if err != nil {
+				return failure(err)
+			}
+			settingID = setting.ID
+		}
+		all, err := env.store.GetBackupRecords()
+		if err != nil {
+			return failure(fmt.Errorf("获取备份记录失败: %v", err))
+		}
+		for _, record := range all {
+			if record.Status == models.StatusCompleted && (settingID == 0 || record.SettingID == settingID) {
+				records = append(records, record)
+			}
+		}
+	}
+
+	code := exitOK
+	settings := make(map[int]*models.DBSettings)
+	results := make([]*services.VerifyResult, 0, len(records))
+	for _, record := range records {
+		setting, ok := settings[record.SettingID]
+		if !ok {
+			if setting, err = env.store.GetSettingByID(record.SettingID); err != nil {
+				return failure(fmt.Errorf("获取配置 %d 失败: %v", record.SettingID, err))
+			}
+			settings[record.SettingID] = setting
+		}
+		result := env.backup.VerifyBackup(setting, record)
+		if !result.OK() {
+			code = exitVerifyFailed
+		}
+		results = append(results, result)
+	}
+
+	env.print(results, "RECORD\tDATABASE\tFILE\tSTATUS\tDETAIL", func(w io.Writer) {
+		for _, result := range results {
+			detail := result.Error
+			if result.Status == services.VerifySizeMismatch || result.Status == services.VerifyChecksumMismatch {
+				detail = fmt.Sprintf("expected %s, got %s", result.Expected, result.Actual)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", result.RecordID, result.DBName, result.FileName, result.Status, detail)
+		}
+	})
+	return code
+}
+
+func runPrune(args []string) int {
+	f := newCLIFlags("prune", "prune -setting <ID或名称> [-database <库名>] [-dry-run]")
+	settingRef := f.fs.String("setting", "", "数据库配置的 ID 或名称")
+	database := f.fs.String("database", "", "只清理该数据库的备份，默认处理该配置下的所有数据库")
+	dryRun := f.fs.Bool("dry-run", false, "只列出将被删除的备份，不实际删除")
+	if code := f.parse(args); code >= 0 {
+		return code
+	}
+	if *settingRef == "" {
+		return usageError("请通过 -setting 指定数据库配置")
+	}
+
+	env, err := f.open()
+	if err != nil {
+		return failure(err)
+	}
+	defer env.Close()
+
+	setting, err := env.findSetting(*settingRef)
+	if err != nil {
+		return failure(err)
+	}
+
+	removed, err := env.backup.ApplyRetention(setting, *database, env.store, *dryRun)
+	if !*dryRun {
+		target := fmt.Sprintf("setting:%d", setting.ID)
+		if *database != "" {
+			target = "database:" + *database
+		}
+		env.audit.Record(services.AuditActorCLI, "", "backup.prune", target, setting.ID, nil, err)
+	}
+	if removed == nil {
+		removed = []*services.RetentionDecision{}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Record.ID < removed[j].Record.ID })
+
+	env.print(removed, "RECORD\tDATABASE\tCREATED\tSIZE\tREASONS", func(w io.Writer) {
+		for _, decision := range removed {
+			record := decision.Record
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", record.ID, record.DBName, record.CreatedAt, record.Size, strings.Join(decision.Reasons, "; "))
+		}
+	})
+	if err != nil {
+		return failure(err)
+	}
+	return exitOK
+}
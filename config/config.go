@@ -1,20 +1,569 @@
 package config
 
-type DatabaseConfig struct {
-    Host     string
-    Port     int
-    User     string
-    Password string
-    Databases []string
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile 未指定配置文件时读取的文件，不存在时使用默认配置
+const DefaultConfigFile = "config.yaml"
+
+// 日志级别
+const (
+	LogLevelDebug = "debug" // Gin 调试模式，输出路由和请求日志
+	LogLevelInfo  = "info"  // 输出请求日志
+	LogLevelWarn  = "warn"  // 不输出请求日志
+	LogLevelError = "error"
+)
+
+// 日志格式
+const (
+	LogFormatText = "text" // key=value 文本，默认
+	LogFormatJSON = "json" // 每行一个 JSON 对象，便于日志系统采集
+)
+
+// Config 服务配置，按 默认值 < 配置文件 < 环境变量 < 命令行参数 的顺序覆盖
+type Config struct {
+	Server    ServerConfig `json:"server" yaml:"server" toml:"server"`
+	DataDir   string       `json:"dataDir" yaml:"dataDir" toml:"dataDir"`       // data.db 和 data.key 所在目录
+	LogLevel  string       `json:"logLevel" yaml:"logLevel" toml:"logLevel"`    // debug、info、warn、error
+	LogFormat string       `json:"logFormat" yaml:"logFormat" toml:"logFormat"` // text 或 json
+	Timezone  string       `json:"timezone" yaml:"timezone" toml:"timezone"`    // 如 Asia/Shanghai，为空使用系统时区
+	Backup    BackupConfig `json:"backup" yaml:"backup" toml:"backup"`
+	OIDC      OIDCConfig   `json:"oidc" yaml:"oidc" toml:"oidc"`
+	SMTP      SMTPConfig   `json:"smtp" yaml:"smtp" toml:"smtp"`
+
+	// Provisioning 声明式配置文件，启动和收到 SIGHUP 时按文件创建、更新和删除数据库配置与定时任务
+	Provisioning string `json:"provisioning" yaml:"provisioning" toml:"provisioning"`
+
+	location *time.Location
+}
+
+// ServerConfig Web 服务配置
+type ServerConfig struct {
+	Listen   string    `json:"listen" yaml:"listen" toml:"listen"`       // 监听地址，如 :6680、127.0.0.1:6680
+	BasePath string    `json:"basePath" yaml:"basePath" toml:"basePath"` // 部署在反向代理子路径下时的路径前缀，如 /datasafe
+	TLS      TLSConfig `json:"tls" yaml:"tls" toml:"tls"`
+	// ShutdownTimeout 停止服务时等待正在执行的备份和恢复任务的秒数，超时后取消，0 表示立即取消
+	ShutdownTimeout int `json:"shutdownTimeout" yaml:"shutdownTimeout" toml:"shutdownTimeout"`
 }
 
+// TLSConfig Web 控制台的 HTTPS 配置
+type TLSConfig struct {
+	CertFile   string `json:"certFile" yaml:"certFile" toml:"certFile"`       // 证书文件，文件变化后自动重新加载
+	KeyFile    string `json:"keyFile" yaml:"keyFile" toml:"keyFile"`          // 私钥文件
+	SelfSigned bool   `json:"selfSigned" yaml:"selfSigned" toml:"selfSigned"` // 证书文件不存在时生成自签名证书，未指定路径时保存在数据目录的 tls 下
+	// RedirectHTTP 额外监听的 HTTP 地址（如 :80），请求跳转到 HTTPS，为空表示不监听
+	RedirectHTTP string `json:"redirectHttp" yaml:"redirectHttp" toml:"redirectHttp"`
+	HSTSMaxAge   int    `json:"hstsMaxAge" yaml:"hstsMaxAge" toml:"hstsMaxAge"` // HSTS 有效期（秒），0 表示不发送
+}
+
+// ShutdownTimeoutDuration 停止服务时等待任务的时长
+func (c *ServerConfig) ShutdownTimeoutDuration() time.Duration {
+	return time.Duration(c.ShutdownTimeout) * time.Second
+}
+
+// Enabled 是否启用 HTTPS
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.SelfSigned
+}
+
+// BackupConfig 备份配置
 type BackupConfig struct {
-    BackupDir    string
-    Compression  bool
-    MaxBackups   int
+	Root    string `json:"root" yaml:"root" toml:"root"`          // 数据库配置未指定备份目录时使用的根目录
+	Workers int    `json:"workers" yaml:"workers" toml:"workers"` // 同时执行的备份和恢复任务数量
 }
 
-type Config struct {
-    Database DatabaseConfig
-    Backup   BackupConfig
-} 
\ No newline at end of file
+// OIDCGroupMapping 身份提供方用户组到角色和数据库配置授权的映射
+type OIDCGroupMapping struct {
+	Role       string `json:"role" yaml:"role" toml:"role"`
+	SettingIDs []int  `json:"settingIds" yaml:"settingIds" toml:"settingIds"`
+}
+
+// OIDCConfig OpenID Connect 单点登录配置
+type OIDCConfig struct {
+	IssuerURL    string                      `json:"issuerUrl" yaml:"issuerUrl" toml:"issuerUrl"`
+	ClientID     string                      `json:"clientId" yaml:"clientId" toml:"clientId"`
+	ClientSecret string                      `json:"clientSecret" yaml:"clientSecret" toml:"clientSecret"`
+	RedirectURL  string                      `json:"redirectUrl" yaml:"redirectUrl" toml:"redirectUrl"` // 例如 https://datasafe.example.com/auth/oidc/callback
+	Scopes       []string                    `json:"scopes" yaml:"scopes" toml:"scopes"`
+	GroupClaim   string                      `json:"groupClaim" yaml:"groupClaim" toml:"groupClaim"` // 用户组声明名称，默认 groups
+	GroupMapping map[string]OIDCGroupMapping `json:"groupMapping" yaml:"groupMapping" toml:"groupMapping"`
+	DefaultRole  string                      `json:"defaultRole" yaml:"defaultRole" toml:"defaultRole"` // 不属于任何映射组时的角色，为空表示拒绝登录
+}
+
+// Enabled 是否配置了单点登录
+func (c *OIDCConfig) Enabled() bool {
+	return c != nil && c.IssuerURL != "" && c.ClientID != ""
+}
+
+// SMTP 连接加密方式
+const (
+	SMTPSecurityStartTLS = "starttls" // 明文连接后通过 STARTTLS 升级，通常使用 587 端口
+	SMTPSecurityTLS      = "tls"      // 连接时即使用 TLS（SMTPS），通常使用 465 端口
+	SMTPSecurityNone     = "none"     // 不加密，只适合本机或内网的邮件服务器
+)
+
+// 汇总邮件的统计周期
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// SMTPConfig 邮件通知配置，备份失败时立即发送告警，并按计划发送备份汇总
+type SMTPConfig struct {
+	Host     string   `json:"host" yaml:"host" toml:"host"`
+	Port     int      `json:"port" yaml:"port" toml:"port"`             // 默认 starttls 使用 587，tls 使用 465，none 使用 25
+	Security string   `json:"security" yaml:"security" toml:"security"` // starttls（默认）、tls 或 none
+	Username string   `json:"username" yaml:"username" toml:"username"` // 为空表示不认证
+	Password string   `json:"password" yaml:"password" toml:"password"`
+	From     string   `json:"from" yaml:"from" toml:"from"`
+	To       []string `json:"to" yaml:"to" toml:"to"`
+	// Alerts 立即发送告警邮件的事件类型，默认 backup.failed、verify.failed 和 rpo.breached，设为空列表表示不发送
+	Alerts []string `json:"alerts" yaml:"alerts" toml:"alerts"`
+	// Digest 发送汇总邮件的 Cron 表达式（5 字段），如 "0 8 * * *"，为空表示不发送
+	Digest       string `json:"digest" yaml:"digest" toml:"digest"`
+	DigestPeriod string `json:"digestPeriod" yaml:"digestPeriod" toml:"digestPeriod"` // 汇总的时间范围：daily（默认，最近 24 小时）或 weekly（最近 7 天）
+}
+
+// Enabled 是否配置了邮件通知
+func (c *SMTPConfig) Enabled() bool {
+	return c != nil && c.Host != "" && len(c.To) > 0
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Listen: ":6680",
+			TLS: TLSConfig{
+				HSTSMaxAge: 31536000,
+			},
+			ShutdownTimeout: 30,
+		},
+		DataDir:   ".",
+		LogLevel:  LogLevelInfo,
+		LogFormat: LogFormatText,
+		Backup: BackupConfig{
+			Root:    "./backups",
+			Workers: 2,
+		},
+		SMTP: SMTPConfig{
+			Security:     SMTPSecurityStartTLS,
+			Alerts:       []string{"backup.failed", "verify.failed", "rpo.breached"},
+			DigestPeriod: DigestDaily,
+		},
+	}
+}
+
+// option 可以通过环境变量和命令行参数设置的配置项
+type option struct {
+	flag   string
+	env    string
+	usage  string
+	set    func(c *Config, value string) error
+	isBool bool // 命令行中可以只写参数名，如 -tls-self-signed
+}
+
+var options = []option{
+	{"listen", "DATASAFE_LISTEN", "监听地址，默认 :6680", func(c *Config, v string) error {
+		c.Server.Listen = v
+		return nil
+	}, false},
+	{"base-path", "DATASAFE_BASE_PATH", "部署在反向代理子路径下时的路径前缀，如 /datasafe", func(c *Config, v string) error {
+		c.Server.BasePath = v
+		return nil
+	}, false},
+	{"shutdown-timeout", "DATASAFE_SHUTDOWN_TIMEOUT", "停止服务时等待正在执行的任务的秒数，超时后取消", func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		c.Server.ShutdownTimeout = n
+		return nil
+	}, false},
+	{"data-dir", "DATASAFE_DATA_DIR", "data.db 和 data.key 所在目录，默认当前目录", func(c *Config, v string) error {
+		c.DataDir = v
+		return nil
+	}, false},
+	{"log-level", "DATASAFE_LOG_LEVEL", "日志级别：debug、info、warn、error", func(c *Config, v string) error {
+		c.LogLevel = v
+		return nil
+	}, false},
+	{"log-format", "DATASAFE_LOG_FORMAT", "日志格式：text 或 json", func(c *Config, v string) error {
+		c.LogFormat = v
+		return nil
+	}, false},
+	{"timezone", "DATASAFE_TIMEZONE", "时区，如 Asia/Shanghai，默认使用系统时区", func(c *Config, v string) error {
+		c.Timezone = v
+		return nil
+	}, false},
+	{"backup-root", "DATASAFE_BACKUP_ROOT", "未指定备份目录的数据库配置使用的备份根目录", func(c *Config, v string) error {
+		c.Backup.Root = v
+		return nil
+	}, false},
+	{"workers", "DATASAFE_WORKERS", "同时执行的备份和恢复任务数量", func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		c.Backup.Workers = n
+		return nil
+	}, false},
+	{"tls-cert", "DATASAFE_TLS_CERT", "HTTPS 证书文件", func(c *Config, v string) error {
+		c.Server.TLS.CertFile = v
+		return nil
+	}, false},
+	{"tls-key", "DATASAFE_TLS_KEY", "HTTPS 私钥文件", func(c *Config, v string) error {
+		c.Server.TLS.KeyFile = v
+		return nil
+	}, false},
+	{"tls-self-signed", "DATASAFE_TLS_SELF_SIGNED", "证书不存在时生成自签名证书", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", v)
+		}
+		c.Server.TLS.SelfSigned = b
+		return nil
+	}, true},
+	{"http-redirect", "DATASAFE_HTTP_REDIRECT", "启用 HTTPS 时额外监听的 HTTP 地址，请求跳转到 HTTPS", func(c *Config, v string) error {
+		c.Server.TLS.RedirectHTTP = v
+		return nil
+	}, false},
+	{"provisioning", "DATASAFE_PROVISIONING", "声明式配置文件，按文件维护数据库配置和定时任务", func(c *Config, v string) error {
+		c.Provisioning = v
+		return nil
+	}, false},
+}
+
+// oidcEnv 单点登录配置对应的环境变量
+var oidcEnv = []option{
+	{env: "DATASAFE_OIDC_ISSUER", set: func(c *Config, v string) error { c.OIDC.IssuerURL = v; return nil }},
+	{env: "DATASAFE_OIDC_CLIENT_ID", set: func(c *Config, v string) error { c.OIDC.ClientID = v; return nil }},
+	{env: "DATASAFE_OIDC_CLIENT_SECRET", set: func(c *Config, v string) error { c.OIDC.ClientSecret = v; return nil }},
+	{env: "DATASAFE_OIDC_REDIRECT_URL", set: func(c *Config, v string) error { c.OIDC.RedirectURL = v; return nil }},
+	{env: "DATASAFE_OIDC_GROUP_CLAIM", set: func(c *Config, v string) error { c.OIDC.GroupClaim = v; return nil }},
+	{env: "DATASAFE_OIDC_DEFAULT_ROLE", set: func(c *Config, v string) error { c.OIDC.DefaultRole = v; return nil }},
+	{env: "DATASAFE_OIDC_SCOPES", set: func(c *Config, v string) error {
+		c.OIDC.Scopes = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+		return nil
+	}},
+	{env: "DATASAFE_OIDC_GROUP_MAPPING", set: func(c *Config, v string) error {
+		return json.Unmarshal([]byte(v), &c.OIDC.GroupMapping)
+	}},
+}
+
+// smtpEnv 邮件通知配置对应的环境变量
+var smtpEnv = []option{
+	{env: "DATASAFE_SMTP_HOST", set: func(c *Config, v string) error { c.SMTP.Host = v; return nil }},
+	{env: "DATASAFE_SMTP_PORT", set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		c.SMTP.Port = n
+		return nil
+	}},
+	{env: "DATASAFE_SMTP_SECURITY", set: func(c *Config, v string) error { c.SMTP.Security = v; return nil }},
+	{env: "DATASAFE_SMTP_USERNAME", set: func(c *Config, v string) error { c.SMTP.Username = v; return nil }},
+	{env: "DATASAFE_SMTP_PASSWORD", set: func(c *Config, v string) error { c.SMTP.Password = v; return nil }},
+	{env: "DATASAFE_SMTP_FROM", set: func(c *Config, v string) error { c.SMTP.From = v; return nil }},
+	{env: "DATASAFE_SMTP_TO", set: func(c *Config, v string) error {
+		c.SMTP.To = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+		return nil
+	}},
+	{env: "DATASAFE_SMTP_DIGEST", set: func(c *Config, v string) error { c.SMTP.Digest = v; return nil }},
+	{env: "DATASAFE_SMTP_DIGEST_PERIOD", set: func(c *Config, v string) error { c.SMTP.DigestPeriod = v; return nil }},
+}
+
+// Flags 注册到命令行参数集合中的配置参数
+type Flags struct {
+	ConfigFile  string
+	PrintConfig bool
+
+	fs     *flag.FlagSet
+	values map[string]*optionValue
+}
+
+// optionValue 命令行参数的原始值，与环境变量使用相同的解析逻辑
+type optionValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *optionValue) String() string     { return v.value }
+func (v *optionValue) Set(s string) error { v.value = s; return nil }
+func (v *optionValue) IsBoolFlag() bool   { return v.isBool }
+
+// AddFlags 向参数集合注册配置文件、--print-config 和各配置项对应的参数
+func AddFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs, values: make(map[string]*optionValue)}
+	fs.StringVar(&f.ConfigFile, "config", "", "配置文件路径（.yaml/.yml 或 .toml），默认读取 DATASAFE_CONFIG 或当前目录下的 "+DefaultConfigFile)
+	fs.BoolVar(&f.PrintConfig, "print-config", false, "打印生效的配置后退出")
+	for _, opt := range options {
+		value := &optionValue{isBool: opt.isBool}
+		fs.Var(value, opt.flag, opt.usage+"（环境变量 "+opt.env+"）")
+		f.values[opt.flag] = value
+	}
+	return f
+}
+
+// Load 在参数解析后加载配置：读取配置文件，依次应用环境变量和命令行参数，最后校验
+func (f *Flags) Load() (*Config, error) {
+	cfg := Default()
+
+	path, required := f.ConfigFile, true
+	if path == "" {
+		path = os.Getenv("DATASAFE_CONFIG")
+	}
+	if path == "" {
+		path, required = DefaultConfigFile, false
+	}
+	if err := cfg.readFile(path, required); err != nil {
+		return nil, err
+	}
+
+	env := append(append([]option{}, options...), oidcEnv...)
+	for _, opt := range append(env, smtpEnv...) {
+		if value, ok := os.LookupEnv(opt.env); ok {
+			if err := opt.set(cfg, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", opt.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	f.fs.Visit(func(fl *flag.Flag) {
+		for _, opt := range options {
+			if opt.flag == fl.Name && flagErr == nil {
+				if err := opt.set(cfg, f.values[opt.flag].value); err != nil {
+					flagErr = fmt.Errorf("-%s: %v", opt.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile 按扩展名解析 YAML 或 TOML 配置文件，不允许未知字段。文件不存在且 required 为 false 时忽略
+func (c *Config) readFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(c)
+		if err == io.EOF {
+			err = nil
+		}
+	default:
+		return fmt.Errorf("unsupported config file %s, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate 校验并规范化配置，启动时调用
+func (c *Config) Validate() error {
+	if _, port, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %v", c.Server.Listen, err)
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid listen port %q", port)
+	}
+
+	// 规范为 /datasafe 形式，/ 表示不使用前缀
+	basePath := strings.TrimRight(c.Server.BasePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		return fmt.Errorf("base path must start with /: %q", c.Server.BasePath)
+	}
+	if strings.ContainsAny(basePath, "?#'\"<> ") {
+		return fmt.Errorf("invalid base path %q", c.Server.BasePath)
+	}
+	c.Server.BasePath = basePath
+
+	if c.DataDir == "" {
+		return fmt.Errorf("data dir must not be empty")
+	}
+
+	if err := c.validateTLS(); err != nil {
+		return err
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdownTimeout must not be negative")
+	}
+	if err := c.validateSMTP(); err != nil {
+		return err
+	}
+	if c.Backup.Root == "" {
+		return fmt.Errorf("backup root must not be empty")
+	}
+	if c.Backup.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", c.Backup.Workers)
+	}
+
+	switch c.LogLevel {
+	case LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		return fmt.Errorf("invalid log level %q, use debug, info, warn or error", c.LogLevel)
+	}
+	switch c.LogFormat {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("invalid log format %q, use text or json", c.LogFormat)
+	}
+
+	c.location = time.Local
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %v", c.Timezone, err)
+		}
+		c.location = loc
+	}
+	return nil
+}
+
+// validateTLS 校验 HTTPS 配置，自签名证书未指定路径时保存在数据目录下
+func (c *Config) validateTLS() error {
+	t := &c.Server.TLS
+	if t.SelfSigned && t.CertFile == "" && t.KeyFile == "" {
+		t.CertFile = filepath.Join(c.DataDir, "tls", "cert.pem")
+		t.KeyFile = filepath.Join(c.DataDir, "tls", "key.pem")
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("tls certFile and keyFile must be set together")
+	}
+	if t.HSTSMaxAge < 0 {
+		return fmt.Errorf("hstsMaxAge must not be negative")
+	}
+	if t.RedirectHTTP != "" {
+		if !t.Enabled() {
+			return fmt.Errorf("http redirect requires tls")
+		}
+		if _, _, err := net.SplitHostPort(t.RedirectHTTP); err != nil {
+			return fmt.Errorf("invalid http redirect address %q: %v", t.RedirectHTTP, err)
+		}
+		if t.RedirectHTTP == c.Server.Listen {
+			return fmt.Errorf("http redirect address must differ from listen address")
+		}
+	}
+	return nil
+}
+
+// validateSMTP 校验邮件通知配置，未指定端口时按加密方式使用默认端口
+func (c *Config) validateSMTP() error {
+	s := &c.SMTP
+	if s.Host == "" {
+		return nil
+	}
+	if len(s.To) == 0 {
+		return fmt.Errorf("smtp to must not be empty")
+	}
+	if s.From == "" {
+		s.From = s.Username
+	}
+	if s.From == "" {
+		return fmt.Errorf("smtp from must not be empty")
+	}
+	switch s.Security {
+	case SMTPSecurityStartTLS, "":
+		s.Security = SMTPSecurityStartTLS
+		if s.Port == 0 {
+			s.Port = 587
+		}
+	case SMTPSecurityTLS:
+		if s.Port == 0 {
+			s.Port = 465
+		}
+	case SMTPSecurityNone:
+		if s.Port == 0 {
+			s.Port = 25
+		}
+	default:
+		return fmt.Errorf("invalid smtp security %q, use starttls, tls or none", s.Security)
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		return fmt.Errorf("invalid smtp port %d", s.Port)
+	}
+	if s.Digest != "" {
+		if _, err := cron.ParseStandard(s.Digest); err != nil {
+			return fmt.Errorf("invalid smtp digest schedule %q: %v", s.Digest, err)
+		}
+	}
+	switch s.DigestPeriod {
+	case DigestDaily, DigestWeekly:
+	case "":
+		s.DigestPeriod = DigestDaily
+	default:
+		return fmt.Errorf("invalid smtp digest period %q, use daily or weekly", s.DigestPeriod)
+	}
+	return nil
+}
+
+// Location 配置的时区，Validate 之后可用
+func (c *Config) Location() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+	return c.location
+}
+
+// DBPath 数据文件路径
+func (c *Config) DBPath() string {
+	return filepath.Join(c.DataDir, "data.db")
+}
+
+// KeyFile 默认主密钥文件路径
+func (c *Config) KeyFile() string {
+	return filepath.Join(c.DataDir, "data.key")
+}
+
+// Print 以 YAML 格式输出生效的配置，密钥类字段脱敏
+func (c *Config) Print(w io.Writer) error {
+	printed := *c
+	if printed.OIDC.ClientSecret != "" {
+		printed.OIDC.ClientSecret = "******"
+	}
+	if printed.SMTP.Password != "" {
+		printed.SMTP.Password = "******"
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&printed); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
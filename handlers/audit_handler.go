@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"fmt"
+	"mysql-backup/models"
+	"mysql-backup/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListAudit 查询审计日志，format=csv 时导出全部符合条件的日志
+func (h *BackupHandler) ListAudit(c *gin.Context) {
+	var query models.AuditQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := h.audit.Query(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		filename := fmt.Sprintf("audit_%s.csv", time.Now().Format("20060102150405"))
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		if err := services.WriteAuditCSV(c.Writer, entries); err != nil {
+			c.Error(err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PageResponse{
+		Total: len(entries),
+		Data:  paginate(entries, query.Page, query.PageSize),
+	})
+}
+
+// VerifyAudit 校验审计日志哈希链是否完整
+func (h *BackupHandler) VerifyAudit(c *gin.Context) {
+	result, err := h.audit.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
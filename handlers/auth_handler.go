@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"mysql-backup/models"
+	"mysql-backup/services"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionCookie 会话 Cookie 名称
+const sessionCookie = "datasafe_session"
+
+// oidcStateCookie 保存发起单点登录时的 state，回调时核对，确保回调来自发起登录的浏览器
+const oidcStateCookie = "datasafe_oidc_state"
+
+// contextUserKey gin.Context 中保存当前用户的键
+const contextUserKey = "user"
+
+// contextPrincipalKey gin.Context 中保存当前身份及权限的键
+const contextPrincipalKey = "principal"
+
+type AuthHandler struct {
+	auth     *services.AuthService
+	oidc     *services.OIDCService // 未配置单点登录时为 nil
+	basePath string                // 部署在反向代理子路径下时的路径前缀，用于跳转地址和 Cookie 路径
+}
+
+// UserResponse 用户响应结构，不包含密码哈希
+type UserResponse struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	Role        string `json:"role"`
+	SettingIDs  []int  `json:"settingIds"`
+	CreatedAt   string `json:"createdAt"`
+	LastLoginAt string `json:"lastLoginAt,omitempty"`
+}
+
+func NewAuthHandler(auth *services.AuthService, oidc *services.OIDCService, basePath string) *AuthHandler {
+	return &AuthHandler{auth: auth, oidc: oidc, basePath: basePath}
+}
+
+func toUserResponse(user *models.User) UserResponse {
+	role := user.Role
+	if role == "" {
+		role = models.RoleAdmin
+	}
+	return UserResponse{
+		ID:          user.ID,
+		Username:    user.Username,
+		Role:        role,
+		SettingIDs:  user.SettingIDs,
+		CreatedAt:   user.CreatedAt,
+		LastLoginAt: user.LastLoginAt,
+	}
+}
+
+// currentUser 返回当前登录用户，未登录时返回 nil
+func currentUser(c *gin.Context) *models.User {
+	if v, ok := c.Get(contextUserKey); ok {
+		if user, ok := v.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// currentPrincipal 返回当前身份，未登录时返回没有任何权限的身份
+func currentPrincipal(c *gin.Context) *services.Principal {
+	if v, ok := c.Get(contextPrincipalKey); ok {
+		if principal, ok := v.(*services.Principal); ok {
+			return principal
+		}
+	}
+	return &services.Principal{}
+}
+
+// authorize 校验当前身份是否可以对数据库配置执行操作，无权限时返回 403
+func authorize(c *gin.Context, perm string, settingID int) bool {
+	if !currentPrincipal(c).Can(perm, settingID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "没有权限执行该操作"})
+		return false
+	}
+	return true
+}
+
+// RequirePermission 要求当前身份拥有权限且可以访问所有数据库配置，用于全局管理接口
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := currentPrincipal(c)
+		if !principal.HasPermission(perm) || !principal.AllSettings {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "没有权限执行该操作"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// setSessionCookie 写入会话 Cookie，HTTPS 访问时附加 Secure 属性
+func (h *AuthHandler) setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, h.basePath+"/", "", c.Request.TLS != nil, true)
+}
+
+// setOIDCStateCookie 写入单点登录的 state Cookie，只在回调路径下发送，maxAge 小于 0 时删除
+func (h *AuthHandler) setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, h.basePath+"/auth/oidc/", "", c.Request.TLS != nil, true)
+}
+
+// RequireAuth 校验登录会话，未登录时 API 返回 401，页面跳转到登录页。
+// API 请求和 /metrics 也可以使用 Authorization: Bearer 携带 API 令牌。
+func (h *AuthHandler) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiRequest := strings.HasPrefix(c.Request.URL.Path, "/api/") || c.Request.URL.Path == "/metrics"
+		if header := c.GetHeader("Authorization"); apiRequest && strings.HasPrefix(header, "Bearer ") {
+			apiToken, err := h.auth.AuthenticateToken(strings.TrimPrefix(header, "Bearer "), c.ClientIP())
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.Set(contextPrincipalKey, services.PrincipalForToken(apiToken))
+			c.Next()
+			return
+		}
+
+		token, _ := c.Cookie(sessionCookie)
+		user, err := h.auth.Authenticate(token)
+		if err != nil {
+			if apiRequest {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			c.Redirect(http.StatusFound, h.basePath+"/login")
+			c.Abort()
+			return
+		}
+
+		c.Set(contextUserKey, user)
+		c.Set(contextPrincipalKey, services.PrincipalForUser(user))
+		c.Next()
+	}
+}
+
+// RequireSession 要求使用登录会话访问，API 令牌不能调用用户和令牌管理接口
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if currentUser(c) == nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "该操作需要登录会话"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// LoginPage 登录页面，尚无用户时显示初始化管理员表单
+func (h *AuthHandler) LoginPage(c *gin.Context) {
+	needsSetup, err := h.auth.NeedsSetup()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"NeedsSetup":  needsSetup,
+		"OIDCEnabled": h.oidc != nil,
+		"Error":       c.Query("error"),
+	})
+}
+
+// OIDCLogin 跳转到身份提供方登录
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	if h.oidc == nil {
+		c.String(http.StatusNotFound, "未配置单点登录")
+		return
+	}
+
+	authURL, state, err := h.oidc.AuthCodeURL()
+	if err != nil {
+		c.Redirect(http.StatusFound, h.basePath+"/login?error="+url.QueryEscape(err.Error()))
+		return
+	}
+
+	h.setOIDCStateCookie(c, state, int(services.OIDCLoginTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback 身份提供方登录完成后的回调，校验通过后创建会话
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if h.oidc == nil {
+		c.String(http.StatusNotFound, "未配置单点登录")
+		return
+	}
+	// state Cookie 只使用一次
+	cookieState, _ := c.Cookie(oidcStateCookie)
+	h.setOIDCStateCookie(c, "", -1)
+
+	if errMsg := c.Query("error"); errMsg != "" {
+		c.Redirect(http.StatusFound, h.basePath+"/login?error="+url.QueryEscape(errMsg+" "+c.Query("error_description")))
+		return
+	}
+	state := c.Query("state")
+	if cookieState == "" || subtle.ConstantTimeCompare([]byte(cookieState), []byte(state)) != 1 {
+		c.Redirect(http.StatusFound, h.basePath+"/login?error="+url.QueryEscape("登录请求不是由当前浏览器发起，请重新登录"))
+		return
+	}
+
+	token, _, err := h.oidc.HandleCallback(state, c.Query("code"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.Redirect(http.StatusFound, h.basePath+"/login?error="+url.QueryEscape(err.Error()))
+		return
+	}
+
+	h.setSessionCookie(c, token, int(services.SessionTTL.Seconds()))
+	c.Redirect(http.StatusFound, h.basePath+"/")
+}
+
+// Login 用户登录
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req models.LoginRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, user, err := h.auth.Login(req.Username, req.Password, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.setSessionCookie(c, token, int(services.SessionTTL.Seconds()))
+	c.JSON(http.StatusOK, toUserResponse(user))
+}
+
+// Setup 首次运行时创建管理员账号并直接登录
+func (h *AuthHandler) Setup(c *gin.Context) {
+	var req models.LoginRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.auth.Bootstrap(req.Username, req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := h.auth.CreateSession(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.setSessionCookie(c, token, int(services.SessionTTL.Seconds()))
+	c.JSON(http.StatusOK, toUserResponse(user))
+}
+
+// Logout 退出登录
+func (h *AuthHandler) Logout(c *gin.Context) {
+	token, _ := c.Cookie(sessionCookie)
+	if err := h.auth.Logout(token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+// Me 获取当前登录用户，使用 API 令牌时返回令牌的身份
+func (h *AuthHandler) Me(c *gin.Context) {
+	if user := currentUser(c); user != nil {
+		c.JSON(http.StatusOK, toUserResponse(user))
+		return
+	}
+
+	principal := currentPrincipal(c)
+	c.JSON(http.StatusOK, gin.H{
+		"username":    principal.Username,
+		"role":        principal.Role,
+		"permissions": principal.Permissions,
+		"settingIds":  principal.SettingIDs,
+	})
+}
+
+// ChangePassword 修改当前用户密码，成功后需要重新登录
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req models.PasswordRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.auth.ChangePassword(currentUser(c).ID, req.OldPassword, req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "密码已修改，请重新登录"})
+}
+
+// ListUsers 获取用户列表
+func (h *AuthHandler) ListUsers(c *gin.Context) {
+	users, err := h.auth.ListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	responses := make([]UserResponse, 0, len(users))
+	for _, user := range users {
+		responses = append(responses, toUserResponse(user))
+	}
+	c.JSON(http.StatusOK, responses)
+}
+
+// CreateUser 创建用户
+func (h *AuthHandler) CreateUser(c *gin.Context) {
+	var req models.UserRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.auth.CreateUser(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toUserResponse(user))
+}
+
+// UpdateUser 更新用户角色、授权或重置密码
+func (h *AuthHandler) UpdateUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var req models.UserRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if id == currentUser(c).ID && req.Role != models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能取消当前用户的管理员角色"})
+		return
+	}
+
+	user, err := h.auth.UpdateUser(id, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toUserResponse(user))
+}
+
+// DeleteUser 删除用户，不能删除自己
+func (h *AuthHandler) DeleteUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if id == currentUser(c).ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除当前登录的用户"})
+		return
+	}
+
+	if err := h.auth.DeleteUser(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "用户已删除"})
+}
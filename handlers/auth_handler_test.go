@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"mysql-backup/models"
+	"mysql-backup/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		principal *services.Principal // nil 表示未设置身份
+		want      int
+	}{
+		{name: "admin", principal: services.PrincipalForUser(&models.User{Role: models.RoleAdmin}), want: http.StatusOK},
+		{name: "scoped admin token", principal: &services.Principal{Role: "token", Permissions: []string{models.PermManage}, SettingIDs: []int{1}}, want: http.StatusForbidden},
+		{name: "scoped operator", principal: services.PrincipalForUser(&models.User{Role: models.RoleOperator, SettingIDs: []int{1}}), want: http.StatusForbidden},
+		{name: "unscoped token without permission", principal: &services.Principal{Role: "token", Permissions: []string{models.PermView}, AllSettings: true}, want: http.StatusForbidden},
+		{name: "no principal", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/api/users", func(c *gin.Context) {
+				if tt.principal != nil {
+					c.Set(contextPrincipalKey, tt.principal)
+				}
+			}, RequirePermission(models.PermManage), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"fmt"
+	"io"
 	"mysql-backup/models"
 	"mysql-backup/services"
 	"mysql-backup/storage"
 	"net/http"
 	"path/filepath"
+	"reflect"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/robfig/cron"
@@ -18,6 +20,7 @@ type BackupHandler struct {
 	backup   *services.BackupService
 	schedule *services.ScheduleService
 	store    storage.Store
+	audit    *services.AuditService
 }
 
 // BackupRequest 修改备份请求结构
@@ -25,6 +28,7 @@ type BackupRequest struct {
 	SettingID int    `json:"settingId"`
 	Database  string `json:"database"`
 	Schedule  string `json:"schedule,omitempty"`
+	RPO       string `json:"rpo,omitempty"` // 定时任务的恢复点目标，如 26h
 }
 
 // BackupResponse 备份记录响应结构
@@ -35,6 +39,45 @@ type BackupResponse struct {
 	FileName    string `json:"fileName"`
 	CreatedAt   string `json:"createdAt"`
 	Status      string `json:"status"`
+	Size        int64  `json:"size"`
+	Pinned      bool   `json:"pinned"`
+	PinReason   string `json:"pinReason,omitempty"`
+	PinnedBy    string `json:"pinnedBy,omitempty"`
+	PinnedAt    string `json:"pinnedAt,omitempty"`
+	HoldUntil   string `json:"holdUntil,omitempty"`
+	HasLog      bool   `json:"hasLog"`
+}
+
+// SettingsResponse 数据库配置响应结构，不返回密码和私钥
+type SettingsResponse struct {
+	models.DBSettings
+	Password        string `json:"password,omitempty"`
+	PasswordSet     bool   `json:"passwordSet"`     // 是否已保存密码
+	TLSClientKeySet bool   `json:"tlsClientKeySet"` // 是否已保存客户端私钥
+	SSHPasswordSet  bool   `json:"sshPasswordSet"`  // 是否已保存跳板机密码
+	SSHKeySet       bool   `json:"sshKeySet"`       // 是否已保存跳板机私钥
+}
+
+// ToSettingsResponse 去掉配置中的密码和私钥，只返回是否已保存
+func ToSettingsResponse(setting *models.DBSettings) SettingsResponse {
+	response := SettingsResponse{
+		DBSettings:  *setting,
+		PasswordSet: setting.Password != "",
+	}
+	if setting.TLS != nil {
+		tls := *setting.TLS
+		response.TLSClientKeySet = tls.ClientKey != ""
+		tls.ClientKey = ""
+		response.TLS = &tls
+	}
+	if setting.SSH != nil {
+		tunnel := *setting.SSH
+		response.SSHPasswordSet = tunnel.Password != ""
+		response.SSHKeySet = tunnel.PrivateKey != ""
+		tunnel.Password, tunnel.PrivateKey, tunnel.Passphrase = "", "", ""
+		response.SSH = &tunnel
+	}
+	return response
 }
 
 // ScheduleResponse 定时任务响应结构
@@ -44,13 +87,19 @@ type ScheduleResponse struct {
 	SettingName string `json:"settingName"`
 	Database    string `json:"database"`
 	Schedule    string `json:"schedule"`
+	Managed     bool   `json:"managed"`
+	RPO         string `json:"rpo,omitempty"`
 }
 
-func NewBackupHandler(backup *services.BackupService, schedule *services.ScheduleService, store storage.Store) *BackupHandler {
+// errManaged 修改声明式配置文件管理的配置或定时任务时返回的错误
+const errManaged = "该配置由声明式配置文件管理，请修改配置文件后重新加载"
+
+func NewBackupHandler(backup *services.BackupService, schedule *services.ScheduleService, store storage.Store, audit *services.AuditService) *BackupHandler {
 	return &BackupHandler{
 		backup:   backup,
 		schedule: schedule,
 		store:    store,
+		audit:    audit,
 	}
 }
 
@@ -62,6 +111,9 @@ func (h *BackupHandler) GetDatabases(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid setting id"})
 		return
 	}
+	if !authorize(c, models.PermView, settingID) {
+		return
+	}
 
 	// 获取数据库配置
 	setting, err := h.store.GetSettingByID(settingID)
@@ -87,6 +139,9 @@ func (h *BackupHandler) CreateBackup(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !authorize(c, models.PermBackup, req.SettingID) {
+		return
+	}
 
 	// 获取数据库配置
 	setting, err := h.store.GetSettingByID(req.SettingID)
@@ -96,7 +151,8 @@ func (h *BackupHandler) CreateBackup(c *gin.Context) {
 	}
 
 	// 使用配置创建备份
-	err = h.backup.BackupDatabaseWithConfig(setting, req.Database, h.store)
+	_, err = h.backup.BackupDatabaseWithConfig(setting, req.Database, h.store)
+	h.recordAudit(c, "backup.create", "database:"+req.Database, setting.ID, nil, err)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -113,8 +169,24 @@ func (h *BackupHandler) GetBackups(c *gin.Context) {
 		page = models.PageRequest{Page: 1, PageSize: 10}
 	}
 
-	// 获取总记录数和分页数据
-	total, records, err := h.store.GetBackupRecordsWithPage(page.Page, page.PageSize)
+	// 获取总记录数和分页数据，只能访问部分配置时先过滤再分页
+	var total int
+	var records []*models.BackupRecord
+	var err error
+	principal := currentPrincipal(c)
+	if principal.AllSettings {
+		total, records, err = h.store.GetBackupRecordsWithPage(page.Page, page.PageSize)
+	} else {
+		var all []*models.BackupRecord
+		all, err = h.store.GetBackupRecords()
+		for _, record := range all {
+			if principal.Can(models.PermView, record.SettingID) {
+				records = append(records, record)
+			}
+		}
+		total = len(records)
+		records = paginate(records, page.Page, page.PageSize)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -136,6 +208,13 @@ func (h *BackupHandler) GetBackups(c *gin.Context) {
 			FileName:    record.FileName,
 			CreatedAt:   record.CreatedAt,
 			Status:      record.Status,
+			Size:        record.Size,
+			Pinned:      services.IsPinned(record, time.Now()),
+			PinReason:   record.PinReason,
+			PinnedBy:    record.PinnedBy,
+			PinnedAt:    record.PinnedAt,
+			HoldUntil:   record.HoldUntil,
+			HasLog:      record.HasLog,
 		})
 	}
 
@@ -164,6 +243,13 @@ func (h *BackupHandler) ScheduleBackup(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 Cron 表达式"})
 		return
 	}
+	if err := services.ValidateRPO(req.RPO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorize(c, models.PermManage, req.SettingID) {
+		return
+	}
 
 	// 获取数据库配置
 	setting, err := h.store.GetSettingByID(req.SettingID)
@@ -188,7 +274,8 @@ func (h *BackupHandler) ScheduleBackup(c *gin.Context) {
 	}
 
 	// 添加定时任务
-	id, err := h.schedule.AddTaskWithConfig(setting, req.Database, req.Schedule)
+	id, err := h.schedule.AddTaskWithConfig(setting, req.Database, req.Schedule, req.RPO)
+	h.recordAudit(c, "schedule.create", fmt.Sprintf("schedule:%d", id), setting.ID, services.DiffChanges(nil, req), err)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -208,8 +295,21 @@ func (h *BackupHandler) ListSchedules(c *gin.Context) {
 		page = models.PageRequest{Page: 1, PageSize: 10}
 	}
 
-	// 获取总记录数和分页数据
-	total, tasks := h.schedule.ListTasksWithPage(page.Page, page.PageSize)
+	// 获取总记录数和分页数据，只能访问部分配置时先过滤再分页
+	var total int
+	var tasks []services.ScheduledTask
+	principal := currentPrincipal(c)
+	if principal.AllSettings {
+		total, tasks = h.schedule.ListTasksWithPage(page.Page, page.PageSize)
+	} else {
+		for _, task := range h.schedule.ListTasks() {
+			if principal.Can(models.PermView, task.SettingID) {
+				tasks = append(tasks, task)
+			}
+		}
+		total = len(tasks)
+		tasks = paginate(tasks, page.Page, page.PageSize)
+	}
 	var responses []ScheduleResponse
 
 	for _, task := range tasks {
@@ -225,6 +325,8 @@ func (h *BackupHandler) ListSchedules(c *gin.Context) {
 			SettingName: settingName,
 			Database:    task.Database,
 			Schedule:    task.Schedule,
+			Managed:     task.Managed,
+			RPO:         task.RPO,
 		})
 	}
 
@@ -239,7 +341,26 @@ func (h *BackupHandler) DeleteSchedule(c *gin.Context) {
 	taskID := 0
 	fmt.Sscanf(id, "%d", &taskID)
 
-	if err := h.schedule.RemoveTask(taskID); err != nil {
+	var removed *services.ScheduledTask
+	for _, task := range h.schedule.ListTasks() {
+		if task.ID == taskID {
+			if !authorize(c, models.PermManage, task.SettingID) {
+				return
+			}
+			if task.Managed {
+				c.JSON(http.StatusConflict, gin.H{"error": errManaged})
+				return
+			}
+			removed = &task
+		}
+	}
+
+	err := h.schedule.RemoveTask(taskID)
+	if removed != nil {
+		before := models.ScheduledTask{ID: removed.ID, SettingID: removed.SettingID, Database: removed.Database, Schedule: removed.Schedule, RPO: removed.RPO}
+		h.recordAudit(c, "schedule.delete", fmt.Sprintf("schedule:%d", taskID), removed.SettingID, services.DiffChanges(before, nil), err)
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -247,19 +368,94 @@ func (h *BackupHandler) DeleteSchedule(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
 }
 
+// UpdateScheduleRPO 修改定时任务的恢复点目标，rpo 为空表示不检查
+func (h *BackupHandler) UpdateScheduleRPO(c *gin.Context) {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"})
+		return
+	}
+	var req struct {
+		RPO string `json:"rpo"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if err := services.ValidateRPO(req.RPO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var task *services.ScheduledTask
+	for _, t := range h.schedule.ListTasks() {
+		if t.ID == taskID {
+			task = &t
+			break
+		}
+	}
+	if task == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "定时任务不存在"})
+		return
+	}
+	if !authorize(c, models.PermManage, task.SettingID) {
+		return
+	}
+	if task.Managed {
+		c.JSON(http.StatusConflict, gin.H{"error": errManaged})
+		return
+	}
+
+	err = h.schedule.SetRPO(task.ID, req.RPO)
+	changes := []models.AuditChange{{Field: "rpo", Before: task.RPO, After: req.RPO}}
+	h.recordAudit(c, "schedule.update", fmt.Sprintf("schedule:%d", task.ID), task.SettingID, changes, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "恢复点目标已更新"})
+}
+
 func (h *BackupHandler) SaveSettings(c *gin.Context) {
 	var settings models.DBSettings
 	if err := c.BindJSON(&settings); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// 新建配置需要可以访问所有配置的管理员
+	if settings.ID == 0 && !currentPrincipal(c).AllSettings {
+		c.JSON(http.StatusForbidden, gin.H{"error": "没有权限执行该操作"})
+		return
+	}
+	if !authorize(c, models.PermManage, settings.ID) {
+		return
+	}
 
-	if err := h.store.SaveSettings(&settings); err != nil {
+	// 记录修改前的配置用于审计
+	action := "settings.create"
+	var before *models.DBSettings
+	if settings.ID != 0 {
+		action = "settings.update"
+		before, _ = h.store.GetSettingByID(settings.ID)
+	}
+	if before != nil && before.Managed {
+		c.JSON(http.StatusConflict, gin.H{"error": errManaged})
+		return
+	}
+	// 只有声明式配置文件可以创建受管配置
+	settings.Managed = false
+	if !h.prepareSettings(c, &settings, before) {
+		return
+	}
+
+	err := h.store.SaveSettings(&settings)
+	h.recordAudit(c, action, fmt.Sprintf("setting:%d", settings.ID), settings.ID, services.DiffChanges(before, &settings), err)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, settings)
+	c.JSON(http.StatusOK, ToSettingsResponse(&settings))
 }
 
 func (h *BackupHandler) GetSettings(c *gin.Context) {
@@ -269,7 +465,16 @@ func (h *BackupHandler) GetSettings(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, settings)
+	// 只返回有权查看的配置
+	principal := currentPrincipal(c)
+	visible := make([]SettingsResponse, 0, len(settings))
+	for _, setting := range settings {
+		if principal.Can(models.PermView, setting.ID) {
+			visible = append(visible, ToSettingsResponse(&setting))
+		}
+	}
+
+	c.JSON(http.StatusOK, visible)
 }
 
 // TestConnection 测试数据库连接
@@ -280,7 +485,20 @@ func (h *BackupHandler) TestConnection(c *gin.Context) {
 		return
 	}
 
-	err := h.backup.TestConnection(&settings)
+	if !authorize(c, models.PermManage, settings.ID) {
+		return
+	}
+
+	// 编辑已有配置时使用已保存的密码和私钥
+	var saved *models.DBSettings
+	if settings.ID != 0 {
+		saved, _ = h.store.GetSettingByID(settings.ID)
+	}
+	if !h.prepareSettings(c, &settings, saved) {
+		return
+	}
+
+	info, err := h.backup.TestConnection(&settings)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -289,61 +507,478 @@ func (h *BackupHandler) TestConnection(c *gin.Context) {
 		return
 	}
 
+	message := "连接成功，未加密"
+	if info.TLSVersion != "" {
+		message = fmt.Sprintf("连接成功，%s (%s)", info.TLSVersion, info.TLSCipher)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "连接成功",
+		"message": message,
+		"info":    info,
 	})
 }
 
-// ListBackupFiles 获取备份文件列表
+// prepareSettings 校验提交的配置，并补全前端不回显的密码和客户端私钥：
+// 为空表示沿用已保存的值，使用外部密码引用时不保存密码
+func (h *BackupHandler) prepareSettings(c *gin.Context, settings, saved *models.DBSettings) bool {
+	if !h.checkPasswordRef(c, settings, saved) {
+		return false
+	}
+
+	if settings.PasswordRef != "" {
+		settings.Password = ""
+	} else if saved != nil && settings.Password == "" {
+		settings.Password = saved.Password
+	}
+	if tls := settings.TLS; tls != nil && tls.ClientCert != "" && tls.ClientKey == "" && saved != nil && saved.TLS != nil {
+		tls.ClientKey = saved.TLS.ClientKey
+	}
+	if tunnel := settings.SSH; tunnel != nil && saved != nil && saved.SSH != nil {
+		if tunnel.Password == "" {
+			tunnel.Password = saved.SSH.Password
+		}
+		if tunnel.PrivateKey == "" {
+			tunnel.PrivateKey = saved.SSH.PrivateKey
+			if tunnel.Passphrase == "" {
+				tunnel.Passphrase = saved.SSH.Passphrase
+			}
+		}
+	}
+
+	// 未指定备份目录时使用备份根目录下按配置名称生成的目录
+	if settings.BackupDir == "" {
+		settings.BackupDir = h.backup.DefaultBackupDir(settings)
+	}
+
+	if err := services.ValidateConnectionOptions(settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if err := services.ValidateTLSSettings(settings.TLS); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if err := services.ValidateSSHTunnel(settings.SSH); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	// 跳板机可以使用服务器上的私钥和 ssh-agent，只有可以管理所有配置的管理员才能修改
+	var savedSSH *models.SSHTunnel
+	if saved != nil {
+		savedSSH = saved.SSH
+	}
+	if !reflect.DeepEqual(settings.SSH, savedSSH) && !currentPrincipal(c).AllSettings {
+		c.JSON(http.StatusForbidden, gin.H{"error": "没有权限修改跳板机配置"})
+		return false
+	}
+	return true
+}
+
+// checkPasswordRef 校验密码引用。外部引用可以读取程序所在环境中的密钥，
+// 只有可以管理所有配置的管理员才能设置或修改
+func (h *BackupHandler) checkPasswordRef(c *gin.Context, settings, saved *models.DBSettings) bool {
+	if settings.PasswordRef == "" {
+		return true
+	}
+	if err := services.ValidateSecretRef(settings.PasswordRef); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if (saved == nil || saved.PasswordRef != settings.PasswordRef) && !currentPrincipal(c).AllSettings {
+		c.JSON(http.StatusForbidden, gin.H{"error": "没有权限设置密码引用"})
+		return false
+	}
+	return true
+}
+
+// settingFromQuery 根据必填的 settingId 查询参数获取数据库配置并检查权限，失败时已写入响应
+func (h *BackupHandler) settingFromQuery(c *gin.Context, perm string) (*models.DBSettings, bool) {
+	idStr := c.Query("settingId")
+	if idStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 settingId 参数"})
+		return nil, false
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid setting id"})
+		return nil, false
+	}
+	if !authorize(c, perm, id) {
+		return nil, false
+	}
+	setting, err := h.store.GetSettingByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "获取配置失败"})
+		return nil, false
+	}
+	return setting, true
+}
+
+// fileRecord 查找配置下文件名对应的备份记录。多个配置可能共用同一备份目录，
+// 只能访问属于该配置的备份文件，没有对应记录或已清理时返回 404，失败时已写入响应
+func (h *BackupHandler) fileRecord(c *gin.Context, settingID int, filename string) (*models.BackupRecord, bool) {
+	records, err := h.store.GetBackupRecordsBySettingID(settingID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	for _, record := range records {
+		if record.FileName == filename && record.Status != models.StatusPruned {
+			return record, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "备份文件不存在"})
+	return nil, false
+}
+
+// ListBackupFiles 获取备份文件列表，只返回属于该配置的备份记录对应的文件
 func (h *BackupHandler) ListBackupFiles(c *gin.Context) {
-	setting, err := h.store.GetSettingByID(1) // TODO: 从请求参数获取配置ID
+	setting, ok := h.settingFromQuery(c, models.PermView)
+	if !ok {
+		return
+	}
+
+	records, err := h.store.GetBackupRecordsBySettingID(setting.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取配置失败"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	owned := make(map[string]bool, len(records))
+	for _, record := range records {
+		if record.Status != models.StatusPruned {
+			owned[record.FileName] = true
+		}
+	}
 
 	files, err := h.backup.ListBackupFiles(setting.BackupDir)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	visible := make([]services.BackupFile, 0, len(files))
+	for _, file := range files {
+		if owned[file.Name] {
+			visible = append(visible, file)
+		}
+	}
 
-	c.JSON(http.StatusOK, files)
+	c.JSON(http.StatusOK, visible)
 }
 
-// DeleteBackupFile 删除备份文件
+// DeleteBackupFile 删除备份文件，并将对应的备份记录标记为已清理
 func (h *BackupHandler) DeleteBackupFile(c *gin.Context) {
 	filename := c.Param("filename")
-	setting, err := h.store.GetSettingByID(1) // TODO: 从请求参数获取配置ID
+	setting, ok := h.settingFromQuery(c, models.PermManage)
+	if !ok {
+		return
+	}
+	record, ok := h.fileRecord(c, setting.ID, filename)
+	if !ok {
+		return
+	}
+
+	if services.IsPinned(record, time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "备份已固定，请先取消固定"})
+		return
+	}
+	err := h.backup.PruneBackup(setting, record.ID, h.store)
+	h.recordAudit(c, "backup.delete", fmt.Sprintf("backup:%d", record.ID), setting.ID, nil, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "文件删除成功"})
+}
+
+// DeleteBackup 删除备份记录对应的文件，并将记录标记为已清理
+func (h *BackupHandler) DeleteBackup(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+		return
+	}
+
+	record, err := h.store.GetBackupRecordByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorize(c, models.PermManage, record.SettingID) {
+		return
+	}
+
+	setting, err := h.store.GetSettingByID(record.SettingID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取配置失败"})
 		return
 	}
 
-	if err := h.backup.DeleteBackupFile(setting.BackupDir, filename); err != nil {
+	if services.IsPinned(record, time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "备份已固定，请先取消固定"})
+		return
+	}
+
+	err = h.backup.PruneBackup(setting, record.ID, h.store)
+	h.recordAudit(c, "backup.delete", fmt.Sprintf("backup:%d", record.ID), setting.ID, nil, err)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "文件删除成功"})
+	c.JSON(http.StatusOK, gin.H{"message": "备份已删除"})
+}
+
+// authorizeRecord 按备份记录所属配置校验权限，通过时返回备份记录
+func (h *BackupHandler) authorizeRecord(c *gin.Context, perm string, recordID int) (*models.BackupRecord, bool) {
+	record, err := h.store.GetBackupRecordByID(recordID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return record, authorize(c, perm, record.SettingID)
+}
+
+// paginate 对已过滤的列表在内存中分页
+func paginate[T any](items []T, page, pageSize int) []T {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	start := (page - 1) * pageSize
+	if start >= len(items) {
+		return []T{}
+	}
+	end := start + pageSize
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end]
+}
+
+// actorFromContext 返回执行操作的人，用于审计
+func actorFromContext(c *gin.Context) string {
+	if principal := currentPrincipal(c); principal.Username != "" {
+		return principal.Username
+	}
+	return c.ClientIP()
+}
+
+// recordAudit 记录当前请求的操作到审计日志
+func (h *BackupHandler) recordAudit(c *gin.Context, action, target string, settingID int, changes []models.AuditChange, err error) {
+	h.audit.Record(actorFromContext(c), c.ClientIP(), action, target, settingID, changes, err)
+}
+
+// PinBackup 固定备份
+func (h *BackupHandler) PinBackup(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+		return
+	}
+
+	current, ok := h.authorizeRecord(c, models.PermManage, id)
+	if !ok {
+		return
+	}
+
+	var req models.PinRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, err := h.backup.PinBackup(h.store, id, actorFromContext(c), req)
+	h.recordAudit(c, "backup.pin", fmt.Sprintf("backup:%d", id), current.SettingID, services.DiffChanges(nil, req), err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, record)
+}
+
+// UnpinBackup 取消固定备份
+func (h *BackupHandler) UnpinBackup(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+		return
+	}
+
+	current, ok := h.authorizeRecord(c, models.PermManage, id)
+	if !ok {
+		return
+	}
+
+	var req models.PinRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, err := h.backup.UnpinBackup(h.store, id, actorFromContext(c), req.Reason)
+	h.recordAudit(c, "backup.unpin", fmt.Sprintf("backup:%d", id), current.SettingID, services.DiffChanges(nil, req), err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, record)
+}
+
+// GetBackupLog 返回备份任务的日志，任务仍在执行时返回截至目前的日志
+func (h *BackupHandler) GetBackupLog(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+		return
+	}
+
+	record, ok := h.authorizeRecord(c, models.PermView, id)
+	if !ok {
+		return
+	}
+
+	content, running, err := h.backup.JobLog(id, h.store)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      record.ID,
+		"status":  record.Status,
+		"running": running,
+		"log":     content,
+	})
+}
+
+// RestoreBackup 从备份恢复数据库
+func (h *BackupHandler) RestoreBackup(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+		return
+	}
+
+	record, err := h.store.GetBackupRecordByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorize(c, models.PermRestore, record.SettingID) {
+		return
+	}
+
+	var req models.RestoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TargetDatabase != "" {
+		if err := services.ValidateDatabaseName(req.TargetDatabase); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	setting, err := h.store.GetSettingByID(record.SettingID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取配置失败"})
+		return
+	}
+
+	err = h.backup.RestoreBackup(setting, record, req.TargetDatabase, req.DropExisting)
+	h.recordAudit(c, "backup.restore", fmt.Sprintf("backup:%d", record.ID), setting.ID, services.DiffChanges(nil, req), err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "恢复完成"})
+}
+
+// ReconcileCatalog 对账备份目录与备份记录
+func (h *BackupHandler) ReconcileCatalog(c *gin.Context) {
+	var opts services.ReconcileOptions
+	if err := c.ShouldBindJSON(&opts); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.backup.Reconcile(h.store, opts)
+	// 只读对账不记录审计日志
+	if opts.AdoptOrphans || opts.PurgeOrphans || opts.PurgeMissing {
+		h.recordAudit(c, "catalog.reconcile", fmt.Sprintf("setting:%d", opts.SettingID), opts.SettingID, services.DiffChanges(nil, opts), err)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
 }
 
 // DownloadBackupFile 下载备份文件
 func (h *BackupHandler) DownloadBackupFile(c *gin.Context) {
 	filename := c.Param("filename")
-	setting, err := h.store.GetSettingByID(1) // TODO: 从请求参数获取配置ID
+	setting, ok := h.settingFromQuery(c, models.PermDownload)
+	if !ok {
+		return
+	}
+	record, ok := h.fileRecord(c, setting.ID, filename)
+	if !ok {
+		return
+	}
+
+	h.recordAudit(c, "backup.download", fmt.Sprintf("backup:%d", record.ID), setting.ID, nil, nil)
+	c.File(filepath.Join(setting.BackupDir, record.FileName))
+}
+
+// PreviewRetention 预览保留策略将要删除的备份
+func (h *BackupHandler) PreviewRetention(c *gin.Context) {
+	var req models.RetentionPreviewRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorize(c, models.PermManage, req.SettingID) {
+		return
+	}
+
+	setting, err := h.store.GetSettingByID(req.SettingID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取配置失败"})
 		return
 	}
 
-	filePath := filepath.Join(setting.BackupDir, filename)
-	// 验证文件路径
-	if !strings.HasPrefix(filePath, setting.BackupDir) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件路径"})
+	records, err := h.store.GetBackupRecordsBySettingID(setting.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.File(filePath)
+	result := h.backup.PreviewRetention(setting, req.Database, req.Retention, records)
+
+	deleteCount := 0
+	var deleteSize int64
+	for _, decisions := range result {
+		for _, decision := range decisions {
+			if !decision.Keep {
+				deleteCount++
+				deleteSize += decision.Record.Size
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"settingId":   setting.ID,
+		"databases":   result,
+		"deleteCount": deleteCount,
+		"deleteSize":  deleteSize,
+	})
 }
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"mysql-backup/models"
+	"mysql-backup/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导出和导入时通过请求头传递口令，避免口令出现在 URL 和访问日志中
+const (
+	exportPassphraseHeader = "X-Export-Passphrase"
+	importPassphraseHeader = "X-Import-Passphrase"
+)
+
+// BundleHandler 配置导出和导入
+type BundleHandler struct {
+	bundle *services.BundleService
+	audit  *services.AuditService
+}
+
+func NewBundleHandler(bundle *services.BundleService, audit *services.AuditService) *BundleHandler {
+	return &BundleHandler{bundle: bundle, audit: audit}
+}
+
+// Export 导出配置文件，backups=true 时包含备份记录；提供口令时导出加密后的密码和私钥
+func (h *BundleHandler) Export(c *gin.Context) {
+	includeBackups, _ := strconv.ParseBool(c.Query("backups"))
+	opts := services.ExportOptions{
+		IncludeBackups: includeBackups,
+		Passphrase:     c.GetHeader(exportPassphraseHeader),
+	}
+
+	bundle, err := h.bundle.Export(opts)
+	changes := []models.AuditChange{
+		{Field: "backups", After: includeBackups},
+		{Field: "secrets", After: opts.Passphrase != ""},
+	}
+	h.audit.Record(actorFromContext(c), c.ClientIP(), "config.export", "config", 0, changes, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("datasafe_config_%s.json", time.Now().Format("20060102150405"))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.JSON(http.StatusOK, bundle)
+}
+
+// Import 导入配置文件，mode 为 merge（默认）或 replace
+func (h *BundleHandler) Import(c *gin.Context) {
+	var bundle services.ConfigBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的导出文件: " + err.Error()})
+		return
+	}
+	opts := services.ImportOptions{
+		Mode:       c.DefaultQuery("mode", services.ImportModeMerge),
+		Passphrase: c.GetHeader(importPassphraseHeader),
+	}
+
+	report, err := h.bundle.Import(&bundle, opts)
+	h.audit.Record(actorFromContext(c), c.ClientIP(), "config.import", "config", 0, services.DiffChanges(nil, report), err)
+	if err != nil {
+		status := http.StatusBadRequest
+		if report != nil {
+			// 已经执行了部分修改
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"mysql-backup/config"
+	"mysql-backup/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailHandler 测试邮件发送、预览和立即发送备份汇总
+type EmailHandler struct {
+	email *services.EmailService // 未配置 SMTP 时为 nil
+	audit *services.AuditService
+}
+
+func NewEmailHandler(email *services.EmailService, audit *services.AuditService) *EmailHandler {
+	return &EmailHandler{email: email, audit: audit}
+}
+
+func (h *EmailHandler) enabled(c *gin.Context) bool {
+	if h.email == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未配置邮件通知"})
+		return false
+	}
+	return true
+}
+
+// SendTest 发送测试邮件
+func (h *EmailHandler) SendTest(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	if err := h.email.SendTest(); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "测试邮件已发送"})
+}
+
+// PreviewDigest 返回汇总内容但不发送，period 为 daily 或 weekly，默认使用配置的周期
+func (h *EmailHandler) PreviewDigest(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	period := c.Query("period")
+	switch period {
+	case config.DigestDaily, config.DigestWeekly:
+	case "":
+		period = h.email.DigestPeriod()
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period 只能是 daily 或 weekly"})
+		return
+	}
+
+	report, err := h.email.BuildDigest(period, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// SendDigest 立即按配置的周期发送汇总邮件
+func (h *EmailHandler) SendDigest(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	report, err := h.email.SendDigest()
+	h.audit.Record(actorFromContext(c), c.ClientIP(), "email.digest", "email", 0, nil, err)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"log/slog"
+	"mysql-backup/models"
+	"mysql-backup/services"
+	"mysql-backup/storage"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler 服务存活、就绪检查和备份健康状态
+type HealthHandler struct {
+	store     storage.Store
+	backup    *services.BackupService
+	schedules *services.ScheduleService
+	freshness *services.FreshnessService
+}
+
+func NewHealthHandler(store storage.Store, backup *services.BackupService, schedules *services.ScheduleService, freshness *services.FreshnessService) *HealthHandler {
+	return &HealthHandler{store: store, backup: backup, schedules: schedules, freshness: freshness}
+}
+
+// Healthz 存活检查，进程能处理请求即返回 200
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz 就绪检查：存储可用、备份目录可写、调度器在运行且服务没有在停止，任一项失败时返回 503。
+// 无需登录即可访问，返回内容不包含路径等细节，失败原因写入服务日志
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	checks := gin.H{"store": "ok", "backupDir": "ok", "scheduler": "ok"}
+	ready := true
+	fail := func(name, reason string, err error) {
+		ready = false
+		checks[name] = reason
+		if err != nil {
+			slog.Warn("就绪检查失败", "check", name, "error", err)
+		}
+	}
+
+	// 存储不可用时只检查备份根目录
+	var settings []models.DBSettings
+	if err := h.store.Ping(); err != nil {
+		fail("store", "存储不可用", err)
+	} else if settings, err = h.store.GetAllSettings(); err != nil {
+		fail("store", "读取数据库配置失败", err)
+	}
+	if err := h.backup.CheckBackupDirs(settings); err != nil {
+		fail("backupDir", "备份目录不可写", err)
+	}
+	if !h.schedules.Running() {
+		fail("scheduler", "调度器未运行", nil)
+	}
+	if h.backup.Closing() {
+		checks["shutdown"] = "服务正在停止"
+		ready = false
+	}
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"ready": ready, "checks": checks})
+}
+
+// BackupHealth 立即检查恢复点目标，只返回有查看权限的数据库。
+// 有数据库超过恢复点目标时返回 503，便于外部监控直接按状态码告警
+func (h *HealthHandler) BackupHealth(c *gin.Context) {
+	now := time.Now()
+	statuses, err := h.freshness.Check(now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	principal := currentPrincipal(c)
+	healthy := true
+	visible := make([]*services.FreshnessStatus, 0, len(statuses))
+	for _, status := range statuses {
+		if !principal.Can(models.PermView, status.SettingID) {
+			continue
+		}
+		visible = append(visible, status)
+		if status.Breached {
+			healthy = false
+		}
+	}
+
+	code := http.StatusOK
+	if !healthy {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"healthy":    healthy,
+		"checkedAt":  now.Format("2006-01-02 15:04:05"),
+		"objectives": visible,
+	})
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"mysql-backup/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsHandler 以 Prometheus 文本格式输出监控指标
+type MetricsHandler struct {
+	metrics *services.MetricsService
+}
+
+func NewMetricsHandler(metrics *services.MetricsService) *MetricsHandler {
+	return &MetricsHandler{metrics: metrics}
+}
+
+// Metrics 输出所有指标，Prometheus 使用 API 令牌（Bearer）抓取
+func (h *MetricsHandler) Metrics(c *gin.Context) {
+	data, err := h.metrics.Render()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", data)
+}
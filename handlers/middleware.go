@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HSTS 通过 HTTPS 访问时发送 Strict-Transport-Security，要求浏览器之后只使用 HTTPS 访问。
+// maxAge 为 0 时不发送
+func HSTS(maxAge int) gin.HandlerFunc {
+	value := fmt.Sprintf("max-age=%d", maxAge)
+	return func(c *gin.Context) {
+		if maxAge > 0 && c.Request.TLS != nil {
+			c.Header("Strict-Transport-Security", value)
+		}
+		c.Next()
+	}
+}
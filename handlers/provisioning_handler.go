@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"mysql-backup/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProvisioningHandler 声明式配置的状态查询和重新加载
+type ProvisioningHandler struct {
+	provisioning *services.ProvisioningService // 未配置声明式配置文件时为 nil
+}
+
+func NewProvisioningHandler(provisioning *services.ProvisioningService) *ProvisioningHandler {
+	return &ProvisioningHandler{provisioning: provisioning}
+}
+
+func (h *ProvisioningHandler) enabled(c *gin.Context) bool {
+	if h.provisioning == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未配置声明式配置文件"})
+		return false
+	}
+	return true
+}
+
+// Drift 返回配置文件与已保存的配置和定时任务之间的差异
+func (h *ProvisioningHandler) Drift(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	c.JSON(http.StatusOK, h.provisioning.Status())
+}
+
+// Reload 重新读取配置文件并执行变更，与发送 SIGHUP 效果相同
+func (h *ProvisioningHandler) Reload(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	changes, err := h.provisioning.Apply()
+	if changes == nil {
+		changes = []*services.ProvisionChange{}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "changes": changes})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"mysql-backup/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenResponse API 令牌响应结构，不包含令牌哈希
+type TokenResponse struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Prefix      string   `json:"prefix"`
+	Permissions []string `json:"permissions"`
+	SettingIDs  []int    `json:"settingIds"`
+	CreatedBy   string   `json:"createdBy"`
+	CreatedAt   string   `json:"createdAt"`
+	ExpiresAt   string   `json:"expiresAt,omitempty"`
+	LastUsedAt  string   `json:"lastUsedAt,omitempty"`
+	LastUsedIP  string   `json:"lastUsedIp,omitempty"`
+	Revoked     bool     `json:"revoked"`
+	RevokedAt   string   `json:"revokedAt,omitempty"`
+}
+
+func toTokenResponse(token *models.APIToken) TokenResponse {
+	return TokenResponse{
+		ID:          token.ID,
+		Name:        token.Name,
+		Prefix:      token.Prefix,
+		Permissions: token.Permissions,
+		SettingIDs:  token.SettingIDs,
+		CreatedBy:   token.CreatedBy,
+		CreatedAt:   token.CreatedAt,
+		ExpiresAt:   token.ExpiresAt,
+		LastUsedAt:  token.LastUsedAt,
+		LastUsedIP:  token.LastUsedIP,
+		Revoked:     token.Revoked,
+		RevokedAt:   token.RevokedAt,
+	}
+}
+
+// ListTokens 获取 API 令牌列表
+func (h *AuthHandler) ListTokens(c *gin.Context) {
+	tokens, err := h.auth.ListTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	responses := make([]TokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		responses = append(responses, toTokenResponse(token))
+	}
+	c.JSON(http.StatusOK, responses)
+}
+
+// CreateToken 创建 API 令牌，明文令牌只在响应中返回一次
+func (h *AuthHandler) CreateToken(c *gin.Context) {
+	var req models.TokenRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plaintext, token, err := h.auth.CreateToken(req, currentUser(c).Username)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":   plaintext,
+		"details": toTokenResponse(token),
+		"message": "请妥善保存令牌，令牌只显示一次",
+	})
+}
+
+// RevokeToken 吊销 API 令牌
+func (h *AuthHandler) RevokeToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+
+	token, err := h.auth.RevokeToken(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toTokenResponse(token))
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"fmt"
+	"mysql-backup/models"
+	"mysql-backup/services"
+	"mysql-backup/storage"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookHandler Webhook 的管理、测试和投递日志
+type WebhookHandler struct {
+	webhooks *services.WebhookService
+	store    storage.Store
+	audit    *services.AuditService
+}
+
+func NewWebhookHandler(webhooks *services.WebhookService, store storage.Store, audit *services.AuditService) *WebhookHandler {
+	return &WebhookHandler{webhooks: webhooks, store: store, audit: audit}
+}
+
+// webhookHeaderMask 响应中请求头的值替换为该占位值，修改时传回占位值表示保留原值
+const webhookHeaderMask = "******"
+
+// WebhookResponse Webhook 响应结构，不返回签名密钥和请求头的值
+type WebhookResponse struct {
+	models.Webhook
+	Secret    string `json:"secret,omitempty"`
+	SecretSet bool   `json:"secretSet"` // 是否已设置签名密钥
+}
+
+func toWebhookResponse(hook *models.Webhook) WebhookResponse {
+	resp := WebhookResponse{Webhook: *hook, SecretSet: hook.Secret != ""}
+	if hook.Headers != nil {
+		resp.Headers = make(map[string]string, len(hook.Headers))
+		for name := range hook.Headers {
+			resp.Headers[name] = webhookHeaderMask
+		}
+	}
+	return resp
+}
+
+// keepMaskedHeaders 值为占位值的请求头沿用修改前的值
+func keepMaskedHeaders(hook, before *models.Webhook) error {
+	for name, value := range hook.Headers {
+		if value != webhookHeaderMask {
+			continue
+		}
+		previous, ok := "", false
+		if before != nil {
+			previous, ok = before.Headers[name]
+		}
+		if !ok {
+			return fmt.Errorf("请求头 %s 没有可以保留的原值", name)
+		}
+		hook.Headers[name] = previous
+	}
+	return nil
+}
+
+// ListWebhooks 返回所有 Webhook 和可以订阅的事件类型
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	hooks, err := h.store.GetAllWebhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	responses := make([]WebhookResponse, 0, len(hooks))
+	for _, hook := range hooks {
+		responses = append(responses, toWebhookResponse(hook))
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": responses, "eventTypes": models.EventTypes})
+}
+
+// CreateWebhook 新建 Webhook
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var hook models.Webhook
+	if err := c.BindJSON(&hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hook.ID = 0
+	hook.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+	h.save(c, "webhook.create", nil, &hook)
+}
+
+// UpdateWebhook 修改 Webhook，签名密钥留空、请求头的值为占位值表示不修改
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	before, ok := h.getWebhook(c)
+	if !ok {
+		return
+	}
+
+	var hook models.Webhook
+	if err := c.BindJSON(&hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hook.ID = before.ID
+	hook.CreatedAt = before.CreatedAt
+	if hook.Secret == "" {
+		hook.Secret = before.Secret
+	}
+	h.save(c, "webhook.update", before, &hook)
+}
+
+func (h *WebhookHandler) save(c *gin.Context, action string, before, hook *models.Webhook) {
+	if err := keepMaskedHeaders(hook, before); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateWebhook(hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.validateRouting(hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.store.SaveWebhook(hook)
+	h.audit.Record(actorFromContext(c), c.ClientIP(), action, fmt.Sprintf("webhook:%d", hook.ID), 0, services.DiffChanges(before, hook), err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toWebhookResponse(hook))
+}
+
+// validateRouting 检查按配置或定时任务通知时指定的 ID 是否存在
+func (h *WebhookHandler) validateRouting(hook *models.Webhook) error {
+	for _, id := range hook.SettingIDs {
+		if _, err := h.store.GetSettingByID(id); err != nil {
+			return fmt.Errorf("数据库配置 %d 不存在", id)
+		}
+	}
+	if len(hook.ScheduleIDs) == 0 {
+		return nil
+	}
+	tasks, err := h.store.GetAllSchedules()
+	if err != nil {
+		return err
+	}
+	for _, id := range hook.ScheduleIDs {
+		found := false
+		for _, task := range tasks {
+			if task.ID == id {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("定时任务 %d 不存在", id)
+		}
+	}
+	return nil
+}
+
+// DeleteWebhook 删除 Webhook，投递日志保留
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	hook, ok := h.getWebhook(c)
+	if !ok {
+		return
+	}
+
+	err := h.store.DeleteWebhook(hook.ID)
+	h.audit.Record(actorFromContext(c), c.ClientIP(), "webhook.delete", fmt.Sprintf("webhook:%d", hook.ID), 0, services.DiffChanges(hook, nil), err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook 已删除"})
+}
+
+// TestWebhook 立即发送一条测试消息并返回结果
+func (h *WebhookHandler) TestWebhook(c *gin.Context) {
+	hook, ok := h.getWebhook(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, h.webhooks.Test(hook))
+}
+
+// ListDeliveries 按时间倒序返回 Webhook 的投递日志，limit 默认 50
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	hook, ok := h.getWebhook(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 limit"})
+		return
+	}
+
+	deliveries, err := h.store.GetWebhookDeliveries(hook.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*models.WebhookDelivery{}
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *WebhookHandler) getWebhook(c *gin.Context) (*models.Webhook, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return nil, false
+	}
+	hook, err := h.store.GetWebhookByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook 不存在"})
+		return nil, false
+	}
+	return hook, true
+}
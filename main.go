@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"embed"
+	"flag"
+	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
+	"mysql-backup/config"
 	"mysql-backup/handlers"
+	"mysql-backup/models"
 	"mysql-backup/services"
 	"mysql-backup/storage"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/robfig/cron/v3"
@@ -17,59 +30,472 @@ import (
 var staticFiles embed.FS
 
 func main() {
-	r := gin.Default()
-	c := cron.New(cron.WithSeconds())
+	// 第一个参数不是选项时作为子命令，不带子命令时启动 Web 服务，兼容原来的启动方式
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "serve" {
+		serve(args)
+		return
+	}
+	os.Exit(runCommand(name, args))
+}
+
+// serve 启动 Web 服务和定时任务
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFlags := config.AddFlags(fs)
+	rotateKey := fs.Bool("rotate-key", false, "用新的主密钥重新加密所有保存的密码后退出")
+	newKeyFile := fs.String("new-key-file", "", "轮换时使用的新主密钥文件，默认生成新密钥替换 data.key")
+	fs.Parse(args)
+
+	cfg, err := configFlags.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if configFlags.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal("Failed to print configuration:", err)
+		}
+		return
+	}
+
+	setupLogging(cfg)
+
+	// 备份文件名、记录时间和定时任务都使用配置的时区
+	time.Local = cfg.Location()
+
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		log.Fatal("Failed to create data directory:", err)
+	}
+
+	r := newRouter(cfg.LogLevel)
+	r.Use(handlers.HSTS(cfg.Server.TLS.HSTSMaxAge))
+	c := cron.New(cron.WithSeconds(), cron.WithLocation(cfg.Location()))
 
-	// 初始化 BoltDB 存储
-	store, err := storage.NewBoltStore("./data.db")
+	store, secretCipher, keySource, err := openStore(cfg)
 	if err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		log.Fatal(err)
 	}
 	defer store.Close()
 
+	if *rotateKey {
+		if err := rotateMasterKey(store, keySource, *newKeyFile); err != nil {
+			log.Fatal("Failed to rotate master key:", err)
+		}
+		return
+	}
+
+	// 加密旧版本以明文保存的密码
+	if count, err := store.ReencryptSecrets(secretCipher); err != nil {
+		log.Fatal("Failed to decrypt stored secrets, check the master key:", err)
+	} else if count > 0 {
+		slog.Info("已加密以明文保存的数据库密码", "count", count)
+	}
+
 	// 初始化服务和处理器
-	backupService := services.NewBackupService()
-	scheduleService := services.NewScheduleService(c, backupService, store)
-	backupHandler := handlers.NewBackupHandler(backupService, scheduleService, store)
+	backupService := services.NewBackupService(cfg)
+	// 上次服务异常退出时未完成的备份不会再完成，需要在定时任务开始执行前处理
+	if count, err := backupService.FailInterrupted(store); err != nil {
+		slog.Error("处理未完成的备份记录失败", "error", err)
+	} else if count > 0 {
+		slog.Warn("已将上次未完成的备份标记为失败", "count", count)
+	}
+	auditService := services.NewAuditService(store)
+	scheduleService := services.NewScheduleService(c, backupService, store, auditService)
+	backupHandler := handlers.NewBackupHandler(backupService, scheduleService, store, auditService)
+	webhookService := services.NewWebhookService(store, backupService.Events())
+	freshnessService := services.NewFreshnessService(store, scheduleService, backupService.Events())
+	healthHandler := handlers.NewHealthHandler(store, backupService, scheduleService, freshnessService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService, store, auditService)
+
+	// 配置了 SMTP 时发送告警邮件和备份汇总
+	var emailService *services.EmailService
+	if cfg.SMTP.Enabled() {
+		emailService, err = services.NewEmailService(&cfg.SMTP, store, backupService.Events())
+		if err != nil {
+			log.Fatal("Failed to initialize email notifications:", err)
+		}
+		if err := emailService.StartDigestJob(c); err != nil {
+			log.Fatal("Failed to schedule email digest:", err)
+		}
+	}
+	emailHandler := handlers.NewEmailHandler(emailService, auditService)
+	metricsHandler := handlers.NewMetricsHandler(services.NewMetricsService(store, backupService, scheduleService, freshnessService))
+	authService := services.NewAuthService(store)
+
+	// 配置了声明式配置文件时按文件维护数据库配置和定时任务，收到 SIGHUP 时重新加载
+	var provisioningService *services.ProvisioningService
+	if cfg.Provisioning != "" {
+		provisioningService = services.NewProvisioningService(cfg.Provisioning, store, backupService, scheduleService, auditService)
+		if _, err := provisioningService.Apply(); err != nil {
+			log.Fatal("Failed to apply provisioning file:", err)
+		}
+		notifyReload(func() {
+			slog.Info("收到 SIGHUP，重新加载声明式配置文件", "path", cfg.Provisioning)
+			if _, err := provisioningService.Apply(); err != nil {
+				slog.Error("重新加载声明式配置文件失败，保持当前配置", "error", err)
+			}
+		})
+	}
+	provisioningHandler := handlers.NewProvisioningHandler(provisioningService)
+	bundleHandler := handlers.NewBundleHandler(services.NewBundleService(store, backupService, scheduleService), auditService)
 
-	// 设置 HTML 模板，修改分隔符以避免与 Vue 冲突
-	t := template.New("").Delims("[[", "]]")
+	// 配置了身份提供方时启用 OIDC 单点登录
+	var oidcService *services.OIDCService
+	if cfg.OIDC.Enabled() {
+		oidcService, err = services.NewOIDCService(&cfg.OIDC, authService)
+		if err != nil {
+			log.Fatal("Failed to initialize OIDC:", err)
+		}
+	}
+	authHandler := handlers.NewAuthHandler(authService, oidcService, cfg.Server.BasePath)
+
+	// 设置 HTML 模板，修改分隔符以避免与 Vue 冲突。页面中的链接和请求通过 basePath 加上路径前缀
+	t := template.New("").Delims("[[", "]]").Funcs(template.FuncMap{
+		"basePath": func() string { return cfg.Server.BasePath },
+	})
 	t, err = t.ParseFS(staticFiles, "static/*.html")
 	if err != nil {
 		log.Fatal("Failed to parse templates:", err)
 	}
 	r.SetHTMLTemplate(t)
 
+	// 登录相关路由，无需登录即可访问
+	r.GET("/login", authHandler.LoginPage)
+	auth := r.Group("/auth")
+	{
+		auth.POST("/login", authHandler.Login)
+		auth.POST("/setup", authHandler.Setup)
+		auth.POST("/logout", authHandler.Logout)
+		auth.GET("/oidc/login", authHandler.OIDCLogin)
+		auth.GET("/oidc/callback", authHandler.OIDCCallback)
+	}
+
+	// 存活和就绪检查，无需登录即可访问
+	r.GET("/healthz", healthHandler.Healthz)
+	r.GET("/readyz", healthHandler.Readyz)
+
 	// 首页路由
-	r.GET("/", func(c *gin.Context) {
+	r.GET("/", authHandler.RequireAuth(), func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.html", nil)
 	})
 
 	// 设置页面路由
-	r.GET("/backup", func(c *gin.Context) {
+	r.GET("/backup", authHandler.RequireAuth(), func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index1.html", nil)
 	})
 
+	// Prometheus 指标，包含所有数据库配置的信息，需要可以查看所有配置的会话或 API 令牌
+	r.GET("/metrics", authHandler.RequireAuth(), handlers.RequirePermission(models.PermView), metricsHandler.Metrics)
+
 	// API路由
-	api := r.Group("/api")
+	api := r.Group("/api", authHandler.RequireAuth())
 	{
+		api.GET("/auth/me", authHandler.Me)
+		api.POST("/auth/password", handlers.RequireSession(), authHandler.ChangePassword)
+
+		// 用户和令牌管理仅限使用登录会话的管理员
+		users := api.Group("/users", handlers.RequireSession(), handlers.RequirePermission(models.PermManage))
+		users.GET("", authHandler.ListUsers)
+		users.POST("", authHandler.CreateUser)
+		users.PUT("/:id", authHandler.UpdateUser)
+		users.DELETE("/:id", authHandler.DeleteUser)
+
+		tokens := api.Group("/tokens", handlers.RequireSession(), handlers.RequirePermission(models.PermManage))
+		tokens.GET("", authHandler.ListTokens)
+		tokens.POST("", authHandler.CreateToken)
+		tokens.DELETE("/:id", authHandler.RevokeToken)
+
 		api.GET("/databases", backupHandler.GetDatabases)
 		api.GET("/backups", backupHandler.GetBackups)
 		api.POST("/backup", backupHandler.CreateBackup)
 		api.GET("/schedules", backupHandler.ListSchedules)
 		api.POST("/schedules", backupHandler.ScheduleBackup)
 		api.DELETE("/schedules/:id", backupHandler.DeleteSchedule)
+		api.PUT("/schedules/:id/rpo", backupHandler.UpdateScheduleRPO)
+		api.GET("/health/backups", healthHandler.BackupHealth)
 		api.GET("/settings", backupHandler.GetSettings)
 		api.POST("/settings", backupHandler.SaveSettings)
 		api.POST("/test-connection", backupHandler.TestConnection)
 		api.GET("/backup-files", backupHandler.ListBackupFiles)
 		api.DELETE("/backup-files/:filename", backupHandler.DeleteBackupFile)
 		api.GET("/backup-files/:filename", backupHandler.DownloadBackupFile)
+		api.DELETE("/backups/:id", backupHandler.DeleteBackup)
+		api.GET("/backups/:id/log", backupHandler.GetBackupLog)
+		api.POST("/backups/:id/pin", backupHandler.PinBackup)
+		api.DELETE("/backups/:id/pin", backupHandler.UnpinBackup)
+		api.POST("/backups/:id/restore", backupHandler.RestoreBackup)
+		api.POST("/retention/preview", backupHandler.PreviewRetention)
+		api.POST("/catalog/reconcile", handlers.RequirePermission(models.PermManage), backupHandler.ReconcileCatalog)
+
+		// 审计日志仅限可以管理所有配置的管理员
+		audit := api.Group("/audit", handlers.RequirePermission(models.PermManage))
+		audit.GET("", backupHandler.ListAudit)
+		audit.GET("/verify", backupHandler.VerifyAudit)
+
+		// 导出的配置可能包含加密后的密码，仅限使用登录会话的管理员
+		api.GET("/export", handlers.RequireSession(), handlers.RequirePermission(models.PermManage), bundleHandler.Export)
+		api.POST("/import", handlers.RequireSession(), handlers.RequirePermission(models.PermManage), bundleHandler.Import)
+
+		webhooks := api.Group("/webhooks", handlers.RequirePermission(models.PermManage))
+		webhooks.GET("", webhookHandler.ListWebhooks)
+		webhooks.POST("", webhookHandler.CreateWebhook)
+		webhooks.PUT("/:id", webhookHandler.UpdateWebhook)
+		webhooks.DELETE("/:id", webhookHandler.DeleteWebhook)
+		webhooks.POST("/:id/test", webhookHandler.TestWebhook)
+		webhooks.GET("/:id/deliveries", webhookHandler.ListDeliveries)
+
+		email := api.Group("/email", handlers.RequirePermission(models.PermManage))
+		email.POST("/test", emailHandler.SendTest)
+		email.GET("/digest", emailHandler.PreviewDigest)
+		email.POST("/digest", emailHandler.SendDigest)
+
+		provisioning := api.Group("/provisioning", handlers.RequirePermission(models.PermManage))
+		provisioning.GET("/drift", provisioningHandler.Drift)
+		provisioning.POST("/reload", provisioningHandler.Reload)
+	}
+
+	// 每天凌晨对账备份目录与备份记录
+	if err := scheduleService.StartReconcileJob("30 3 * * *"); err != nil {
+		slog.Error("启动对账任务失败", "error", err)
+	}
+
+	// 每分钟检查恢复点目标，需要在通知订阅之后启动，启动时发现的超标同样发送通知
+	if err := freshnessService.StartChecker(c); err != nil {
+		slog.Error("启动恢复点目标检查失败", "error", err)
 	}
 
 	// 启动定时任务
-	c.Start()
+	scheduleService.Start()
+
+	// 收到 SIGINT 或 SIGTERM 时停止服务
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// 启动服务器
-	log.Fatal(r.Run(":6680"))
+	server := &http.Server{
+		Addr:    cfg.Server.Listen,
+		Handler: withBasePath(cfg.Server.BasePath, r),
+	}
+	servers := []*http.Server{server}
+	serveErr := make(chan error, 2)
+	tlsConfig := cfg.Server.TLS
+	if !tlsConfig.Enabled() {
+		slog.Info("DataSafe 已启动", "listen", cfg.Server.Listen, "path", cfg.Server.BasePath+"/")
+		go func() { serveErr <- server.ListenAndServe() }()
+	} else {
+		if tlsConfig.SelfSigned {
+			if err := services.EnsureSelfSignedCert(tlsConfig.CertFile, tlsConfig.KeyFile); err != nil {
+				log.Fatal("Failed to generate self-signed certificate:", err)
+			}
+		}
+		certReloader, err := services.NewCertReloader(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			log.Fatal("Failed to load TLS certificate:", err)
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certReloader.GetCertificate,
+		}
+
+		if tlsConfig.RedirectHTTP != "" {
+			redirectServer := &http.Server{
+				Addr:    tlsConfig.RedirectHTTP,
+				Handler: redirectToHTTPS(cfg.Server.Listen),
+			}
+			servers = append(servers, redirectServer)
+			slog.Info("HTTP 跳转已启动", "listen", tlsConfig.RedirectHTTP)
+			go func() { serveErr <- redirectServer.ListenAndServe() }()
+		}
+		slog.Info("DataSafe 已启动（HTTPS）", "listen", cfg.Server.Listen, "path", cfg.Server.BasePath+"/")
+		go func() { serveErr <- server.ListenAndServeTLS("", "") }()
+	}
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	// 恢复默认的信号处理，停止过程中再次收到信号时直接退出
+	stop()
+	shutdown(cfg.Server.ShutdownTimeoutDuration(), scheduleService, backupService, webhookService, emailService, servers)
+}
+
+const (
+	httpDrainTimeout   = 10 * time.Second // 停止服务时等待正在处理的 HTTP 请求完成的时长
+	notifyDrainTimeout = 10 * time.Second // 停止服务时等待 Webhook 投递和重试的时长，超时后取消
+)
+
+// shutdown 依次停止调度器、等待或取消正在执行的备份和恢复任务、停止接受新的 HTTP 请求并等待已有请求完成，
+// 最后等待或取消通知的发送。等待任务期间 HTTP 服务继续运行，就绪检查返回 503，新的备份和恢复请求会被拒绝。
+// 存储由调用方关闭
+func shutdown(timeout time.Duration, schedules *services.ScheduleService, backup *services.BackupService,
+	webhooks *services.WebhookService, email *services.EmailService, servers []*http.Server) {
+	slog.Info("收到停止信号，正在停止服务", "timeout", timeout)
+	cronDone := schedules.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := backup.Shutdown(ctx); err != nil {
+		slog.Warn("部分备份或恢复任务没有正常结束", "error", err)
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), httpDrainTimeout)
+	defer drainCancel()
+	for _, server := range servers {
+		if err := server.Shutdown(drainCtx); err != nil {
+			slog.Warn("等待 HTTP 请求完成超时", "listen", server.Addr, "error", err)
+		}
+	}
+	select {
+	case <-cronDone.Done():
+	case <-drainCtx.Done():
+		slog.Warn("等待定时任务结束超时")
+	}
+
+	// 通知会写入投递记录，需要在关闭存储之前结束
+	notifyCtx, notifyCancel := context.WithTimeout(context.Background(), notifyDrainTimeout)
+	defer notifyCancel()
+	webhooks.Shutdown(notifyCtx)
+	if email != nil {
+		email.Wait()
+	}
+	slog.Info("服务已停止")
+}
+
+// openStore 加载主密钥（用于加密保存的数据库密码）并打开 BoltDB 存储
+func openStore(cfg *config.Config) (*storage.BoltStore, *storage.SecretCipher, string, error) {
+	key, keySource, err := storage.LoadMasterKey(cfg.KeyFile())
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("Failed to load master key: %v", err)
+	}
+	secretCipher, err := storage.NewSecretCipher(key)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("Failed to initialize master key: %v", err)
+	}
+
+	store, err := storage.NewBoltStore(cfg.DBPath(), secretCipher)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("Failed to initialize database: %v", err)
+	}
+	return store, secretCipher, keySource, nil
+}
+
+// redirectToHTTPS 将 HTTP 请求跳转到 HTTPS 监听端口的相同路径
+func redirectToHTTPS(httpsListen string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsListen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + strings.Trim(host, "[]") + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// setupLogging 按配置的级别和格式设置默认的 slog 日志，输出到标准错误，标准库 log 的输出同样经过该日志
+func setupLogging(cfg *config.Config) {
+	level := slog.LevelInfo
+	switch cfg.LogLevel {
+	case config.LogLevelDebug:
+		level = slog.LevelDebug
+	case config.LogLevelWarn:
+		level = slog.LevelWarn
+	case config.LogLevelError:
+		level = slog.LevelError
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if cfg.LogFormat == config.LogFormatJSON {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// newRouter 按日志级别创建路由：debug 使用 Gin 调试模式，warn 及以上不记录请求日志
+func newRouter(logLevel string) *gin.Engine {
+	if logLevel == config.LogLevelDebug {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	r := gin.New()
+	if logLevel == config.LogLevelDebug || logLevel == config.LogLevelInfo {
+		r.Use(gin.Logger())
+	}
+	r.Use(gin.Recovery())
+	return r
+}
+
+// withBasePath 部署在反向代理子路径下时，去掉路径前缀后交给路由处理，路由本身不需要感知前缀
+func withBasePath(basePath string, handler http.Handler) http.Handler {
+	if basePath == "" {
+		return handler
+	}
+	stripped := http.StripPrefix(basePath, handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == basePath:
+			http.Redirect(w, r, basePath+"/", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// rotateMasterKey 用新主密钥重新加密所有保存的密码。使用默认密钥文件时生成新密钥并替换该文件，
+// 密钥来自环境变量时需要通过 newKeyFile 提供新密钥，完成后由运维更新环境变量
+func rotateMasterKey(store *storage.BoltStore, keySource, newKeyFile string) error {
+	defaultKeyFile := strings.TrimPrefix(keySource, "keyfile:")
+	if newKeyFile == "" && defaultKeyFile == keySource {
+		return fmt.Errorf("主密钥来自 %s，请通过 -new-key-file 指定新密钥", keySource)
+	}
+
+	var key []byte
+	var err error
+	pending := ""
+	if newKeyFile != "" {
+		key, err = storage.ReadKeyFile(newKeyFile)
+	} else {
+		// 先写入临时文件，重新加密成功后再替换原密钥文件
+		pending = defaultKeyFile + ".new"
+		key, err = storage.GenerateKeyFile(pending)
+	}
+	if err != nil {
+		return err
+	}
+
+	next, err := storage.NewSecretCipher(key)
+	if err != nil {
+		return err
+	}
+	count, err := store.ReencryptSecrets(next)
+	if err != nil {
+		if pending != "" {
+			os.Remove(pending)
+		}
+		return err
+	}
+
+	if pending != "" {
+		if err := os.Rename(pending, defaultKeyFile); err != nil {
+			return fmt.Errorf("密码已使用 %s 中的新密钥加密，但替换密钥文件失败: %v", pending, err)
+		}
+		slog.Info("已重新加密密码，新密钥已写入密钥文件", "count", count, "keyFile", defaultKeyFile)
+		return nil
+	}
+
+	slog.Info("已重新加密密码，请将主密钥配置更新为新密钥文件", "count", count, "keyId", next.KeyID(), "keyFile", newKeyFile)
+	return nil
 }
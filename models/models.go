@@ -2,14 +2,84 @@ package models
 
 // DBSettings 数据库配置结构
 type DBSettings struct {
-	ID         int    `json:"id"`
-	Name       string `json:"name"`
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	User      string `json:"user"`
+	Password  string `json:"password"`
+	BackupDir string `json:"backupDir"`
+
+	// PasswordRef 外部密码引用，设置后不保存密码，在连接时读取：
+	// env:变量名、file:/run/secrets/xxx 或 vault:<路径>#<字段>
+	PasswordRef string `json:"passwordRef,omitempty"`
+
+	TLS *TLSSettings `json:"tls,omitempty"` // 为空表示不使用 TLS
+	SSH *SSHTunnel   `json:"ssh,omitempty"` // 为空表示直连数据库
+
+	// 连接选项，超时单位均为秒
+	Socket          string            `json:"socket,omitempty"`          // Unix socket 路径，设置后忽略 Host/Port
+	ConnectTimeout  int               `json:"connectTimeout,omitempty"`  // 连接超时，默认 10 秒
+	ReadTimeout     int               `json:"readTimeout,omitempty"`     // 读超时，0 表示不限制
+	WriteTimeout    int               `json:"writeTimeout,omitempty"`    // 写超时，0 表示不限制
+	Charset         string            `json:"charset,omitempty"`         // 连接字符集，如 utf8mb4
+	Collation       string            `json:"collation,omitempty"`       // 连接排序规则，如 utf8mb4_general_ci
+	NetWriteTimeout int               `json:"netWriteTimeout,omitempty"` // 会话变量 net_write_timeout，备份大表时避免服务器断开连接
+	Params          map[string]string `json:"params,omitempty"`          // 其他驱动参数或会话变量
+
+	MaxBackups int `json:"maxBackups"` // 保留的最大备份数量，0表示不限制
+
+	Retention *RetentionPolicy `json:"retention,omitempty"` // 保留策略，设置后优先于 MaxBackups
+
+	// Managed 由声明式配置文件创建和维护，不能在界面或 API 中修改
+	Managed bool `json:"managed,omitempty"`
+}
+
+// TLS 连接模式
+const (
+	TLSModeDisabled       = "disabled"        // 不使用 TLS
+	TLSModePreferred      = "preferred"       // 服务器支持时使用 TLS，不校验证书
+	TLSModeRequired       = "required"        // 必须使用 TLS，不校验证书
+	TLSModeVerifyCA       = "verify-ca"       // 必须使用 TLS，校验证书由受信任的 CA 签发
+	TLSModeVerifyIdentity = "verify-identity" // 在 verify-ca 基础上校验证书中的主机名
+)
+
+// TLSSettings 数据库连接的 TLS 配置，证书和私钥可以填写服务器上的文件路径或 PEM 内容
+type TLSSettings struct {
+	Mode       string `json:"mode"`
+	CA         string `json:"ca,omitempty"`         // CA 证书，为空时使用系统证书
+	ClientCert string `json:"clientCert,omitempty"` // 客户端证书
+	ClientKey  string `json:"clientKey,omitempty"`  // 客户端私钥，加密保存，不通过 API 返回
+	ServerName string `json:"serverName,omitempty"` // 校验证书时使用的主机名，默认使用 Host
+}
+
+// SSHTunnel 通过 SSH 跳板机连接数据库的配置，Host/Port 为跳板机地址，
+// 数据库配置中的 Host/Port 为从跳板机访问数据库的地址
+type SSHTunnel struct {
 	Host       string `json:"host"`
-	Port       int    `json:"port"`
+	Port       int    `json:"port"` // 默认 22
 	User       string `json:"user"`
-	Password   string `json:"password"`
-	BackupDir  string `json:"backupDir"`
-	MaxBackups int    `json:"maxBackups"` // 保留的最大备份数量，0表示不限制
+	Password   string `json:"password,omitempty"`   // 密码认证，加密保存
+	PrivateKey string `json:"privateKey,omitempty"` // 私钥文件路径或 PEM 内容，加密保存
+	Passphrase string `json:"passphrase,omitempty"` // 私钥口令，加密保存
+	UseAgent   bool   `json:"useAgent"`             // 使用 SSH_AUTH_SOCK 指向的 ssh-agent
+
+	// 主机公钥校验：HostKey 为 authorized_keys 格式的公钥，
+	// 为空时使用 KnownHosts 文件（默认 ~/.ssh/known_hosts）
+	HostKey    string `json:"hostKey,omitempty"`
+	KnownHosts string `json:"knownHosts,omitempty"`
+}
+
+// RetentionPolicy 备份保留策略（祖父-父-子），按备份记录的创建时间计算
+type RetentionPolicy struct {
+	KeepLast    int `json:"keepLast"`    // 保留最近的 N 份
+	KeepDaily   int `json:"keepDaily"`   // 保留最近 N 天，每天最新的一份
+	KeepWeekly  int `json:"keepWeekly"`  // 保留最近 N 周，每周最新的一份
+	KeepMonthly int `json:"keepMonthly"` // 保留最近 N 个月，每月最新的一份
+	KeepYearly  int `json:"keepYearly"`  // 保留最近 N 年，每年最新的一份
+
+	MaxAgeDays     int   `json:"maxAgeDays"`     // 超过该天数的备份一律删除，0表示不限制
+	MaxTotalSizeMB int64 `json:"maxTotalSizeMB"` // 保留备份的总大小上限，0表示不限制
 }
 
 // BackupRecord 备份记录结构
@@ -19,10 +89,49 @@ type BackupRecord struct {
 	DBName    string `json:"dbName"`
 	FileName  string `json:"fileName"`
 	CreatedAt string `json:"createdAt"`
-	Status    string `json:"status"` // "completed", "failed", "in_progress"
-	Error     string `json:"error"`  // 错误信息
+	Status    string `json:"status"`             // "completed", "failed", "in_progress", "pruned"
+	Error     string `json:"error"`              // 错误信息
+	Size      int64  `json:"size"`               // 备份文件大小（字节）
+	Rows      int64  `json:"rows,omitempty"`     // 导出的数据行数
+	Checksum  string `json:"checksum,omitempty"` // 备份文件的 SHA-256（十六进制）
+	Duration  int64  `json:"duration,omitempty"` // 备份耗时（毫秒）
+	PrunedAt  string `json:"prunedAt,omitempty"` // 备份文件被清理的时间
+	HasLog    bool   `json:"hasLog,omitempty"`   // 是否保存了备份任务的日志
+
+	ScheduleID int `json:"scheduleId,omitempty"` // 由定时任务执行时的任务 ID，手动备份为 0
+
+	// 固定（法律保留）的备份不会被保留策略清理，也不能被手动删除
+	Pinned     bool       `json:"pinned"`
+	PinReason  string     `json:"pinReason,omitempty"`
+	PinnedBy   string     `json:"pinnedBy,omitempty"`
+	PinnedAt   string     `json:"pinnedAt,omitempty"`
+	HoldUntil  string     `json:"holdUntil,omitempty"` // 保留截止时间，为空表示一直保留到取消固定
+	PinHistory []PinEvent `json:"pinHistory,omitempty"`
 }
 
+// PinEvent 备份固定/取消固定的审计记录
+type PinEvent struct {
+	Action    string `json:"action"` // "pin", "unpin"
+	By        string `json:"by"`
+	At        string `json:"at"`
+	Reason    string `json:"reason,omitempty"`
+	HoldUntil string `json:"holdUntil,omitempty"`
+}
+
+// PinRequest 固定备份请求
+type PinRequest struct {
+	Reason    string `json:"reason"`
+	HoldUntil string `json:"holdUntil,omitempty"` // 格式 2006-01-02 或 2006-01-02 15:04:05
+}
+
+// 备份记录状态
+const (
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusPruned     = "pruned" // 备份文件已被删除，记录仅作历史保留
+)
+
 // BackupRequest 备份请求结构
 type BackupRequest struct {
 	SettingID int    `json:"settingId"`
@@ -30,12 +139,26 @@ type BackupRequest struct {
 	Schedule  string `json:"schedule,omitempty"`
 }
 
+// RetentionPreviewRequest 保留策略预览请求
+type RetentionPreviewRequest struct {
+	SettingID int              `json:"settingId"`
+	Database  string           `json:"database,omitempty"`  // 为空时预览该配置下的所有数据库
+	Retention *RetentionPolicy `json:"retention,omitempty"` // 为空时使用配置中保存的策略
+}
+
 // ScheduledTask 定时任务结构
 type ScheduledTask struct {
 	ID        int    `json:"id"`
 	SettingID int    `json:"settingId"`
 	Database  string `json:"database"`
 	Schedule  string `json:"schedule"`
+	Managed   bool   `json:"managed,omitempty"` // 由声明式配置文件维护
+
+	// RPO 恢复点目标，如 26h：该数据库必须有一份比该时长更新的成功备份，为空表示不检查
+	RPO string `json:"rpo,omitempty"`
+
+	// CreatedAt 创建时间，从未成功备份的数据库从此时开始计算恢复点目标
+	CreatedAt string `json:"createdAt,omitempty"`
 }
 
 // PageRequest 分页请求参数
@@ -49,3 +172,213 @@ type PageResponse struct {
 	Total int         `json:"total"` // 总记录数
 	Data  interface{} `json:"data"`  // 数据列表
 }
+
+// User 本地用户账号
+type User struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash"` // bcrypt 哈希，不通过 API 返回
+	Role         string `json:"role"`         // "viewer", "operator", "admin"
+	SettingIDs   []int  `json:"settingIds"`   // 非管理员可访问的数据库配置
+	Provider     string `json:"provider"`     // 账号来源，空表示本地账号，"oidc" 表示单点登录
+	Subject      string `json:"subject"`      // 单点登录账号在身份提供方的唯一标识
+	CreatedAt    string `json:"createdAt"`
+	LastLoginAt  string `json:"lastLoginAt,omitempty"`
+}
+
+// 用户角色
+const (
+	RoleViewer   = "viewer"   // 查看备份
+	RoleOperator = "operator" // 查看、触发备份和下载
+	RoleAdmin    = "admin"    // 管理配置、定时任务、恢复和用户
+)
+
+// 权限
+const (
+	PermView     = "view"     // 查看配置、备份历史和定时任务
+	PermBackup   = "backup"   // 触发备份
+	PermDownload = "download" // 下载备份文件
+	PermManage   = "manage"   // 管理配置、定时任务和备份文件
+	PermRestore  = "restore"  // 从备份恢复数据库
+)
+
+// UserRequest 创建或更新用户请求
+type UserRequest struct {
+	Username   string `json:"username"`
+	Password   string `json:"password,omitempty"` // 更新时为空表示不修改
+	Role       string `json:"role"`
+	SettingIDs []int  `json:"settingIds"`
+}
+
+// APIToken 用于自动化调用的 API 令牌，只保存令牌的哈希
+type APIToken struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Prefix      string   `json:"prefix"`      // 令牌前缀，便于识别
+	TokenHash   string   `json:"tokenHash"`   // SHA-256 哈希，不通过 API 返回
+	Permissions []string `json:"permissions"` // 令牌拥有的权限
+	SettingIDs  []int    `json:"settingIds"`  // 可访问的数据库配置，为空表示全部
+	CreatedBy   string   `json:"createdBy"`
+	CreatedAt   string   `json:"createdAt"`
+	ExpiresAt   string   `json:"expiresAt,omitempty"` // 为空表示永不过期
+	LastUsedAt  string   `json:"lastUsedAt,omitempty"`
+	LastUsedIP  string   `json:"lastUsedIp,omitempty"`
+	Revoked     bool     `json:"revoked"`
+	RevokedAt   string   `json:"revokedAt,omitempty"`
+}
+
+// TokenRequest 创建 API 令牌请求
+type TokenRequest struct {
+	Name          string   `json:"name"`
+	Permissions   []string `json:"permissions"`
+	SettingIDs    []int    `json:"settingIds"`
+	ExpiresInDays int      `json:"expiresInDays"` // 0 表示永不过期
+}
+
+// RestoreRequest 恢复备份请求
+type RestoreRequest struct {
+	TargetDatabase string `json:"targetDatabase,omitempty"` // 为空时恢复到原数据库
+	DropExisting   bool   `json:"dropExisting"`             // 恢复前删除同名表
+}
+
+// Session 登录会话，ID 为会话令牌的 SHA-256 哈希
+type Session struct {
+	ID        string `json:"id"`
+	UserID    int    `json:"userId"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+}
+
+// LoginRequest 登录请求
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// PasswordRequest 修改密码请求
+type PasswordRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// AuditEntry 审计日志条目，按 ID 顺序通过哈希链相连，任何修改或删除都会破坏链
+type AuditEntry struct {
+	ID        int           `json:"id"`
+	Time      string        `json:"time"`
+	Actor     string        `json:"actor"`             // 操作人，定时任务为 "scheduler"
+	IP        string        `json:"ip,omitempty"`      // 来源 IP
+	Action    string        `json:"action"`            // 例如 "settings.save"、"backup.create"
+	Target    string        `json:"target"`            // 操作对象，例如 "setting:1"、"backup:12"
+	SettingID int           `json:"settingId"`         // 关联的数据库配置，0 表示无
+	Changes   []AuditChange `json:"changes,omitempty"` // 修改前后的字段差异，敏感字段已脱敏
+	Success   bool          `json:"success"`
+	Error     string        `json:"error,omitempty"`
+	PrevHash  string        `json:"prevHash"` // 上一条审计日志的哈希
+	Hash      string        `json:"hash"`     // 本条审计日志（含 PrevHash）的 SHA-256 哈希
+}
+
+// AuditChange 审计日志中单个字段的修改
+type AuditChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// AuditQuery 审计日志查询条件
+type AuditQuery struct {
+	Actor     string `form:"actor"`
+	Action    string `form:"action"` // 以 "." 结尾时按前缀匹配，例如 "backup."
+	SettingID int    `form:"settingId"`
+	From      string `form:"from"` // 格式 2006-01-02 或 2006-01-02 15:04:05
+	To        string `form:"to"`
+	Page      int    `form:"page"`
+	PageSize  int    `form:"pageSize"`
+}
+
+// 事件类型，用于通知
+const (
+	EventBackupStarted   = "backup.started"
+	EventBackupSucceeded = "backup.succeeded"
+	EventBackupFailed    = "backup.failed"
+	EventRetentionPruned = "retention.pruned"
+	EventVerifyFailed    = "verify.failed"
+	EventRPOBreached     = "rpo.breached"  // 最近一次成功备份超过恢复点目标
+	EventRPORecovered    = "rpo.recovered" // 超过恢复点目标后又有了新的成功备份
+	EventTest            = "test"
+)
+
+// EventTypes 可以订阅的事件类型，不含测试事件
+var EventTypes = []string{EventBackupStarted, EventBackupSucceeded, EventBackupFailed, EventRetentionPruned, EventVerifyFailed, EventRPOBreached, EventRPORecovered}
+
+// Event 备份相关事件，作为通知的内容
+type Event struct {
+	ID          string        `json:"id"`
+	Type        string        `json:"type"`
+	Time        string        `json:"time"`
+	SettingID   int           `json:"settingId,omitempty"`
+	SettingName string        `json:"settingName,omitempty"`
+	Database    string        `json:"database,omitempty"`
+	ScheduleID  int           `json:"scheduleId,omitempty"` // 由定时任务触发时的任务 ID
+	Record      *BackupRecord `json:"record,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Message     string        `json:"message"`
+
+	// 恢复点目标事件的目标时长和最近一次成功备份的时间（从未成功时为空）
+	RPO         string `json:"rpo,omitempty"`
+	LastSuccess string `json:"lastSuccess,omitempty"`
+}
+
+// 通知渠道类型
+const (
+	ChannelWebhook  = "webhook"  // 通用 Webhook，默认类型
+	ChannelDingTalk = "dingtalk" // 钉钉群机器人
+	ChannelWeCom    = "wecom"    // 企业微信群机器人
+	ChannelFeishu   = "feishu"   // 飞书群机器人
+)
+
+// Webhook 通知目标，事件发生时向 URL 发送 POST 请求
+type Webhook struct {
+	ID      int               `json:"id"`
+	Name    string            `json:"name"`
+	Type    string            `json:"type,omitempty"` // 渠道类型，为空表示通用 Webhook
+	URL     string            `json:"url"`
+	Enabled bool              `json:"enabled"`
+	Events  []string          `json:"events,omitempty"`  // 订阅的事件类型，为空表示全部
+	Headers map[string]string `json:"headers,omitempty"` // 自定义请求头
+
+	// 只通知指定数据库配置或定时任务的事件，为空表示不限制；指定定时任务时不通知手动备份
+	SettingIDs  []int `json:"settingIds,omitempty"`
+	ScheduleIDs []int `json:"scheduleIds,omitempty"`
+
+	// BodyTemplate 请求体模板（Go text/template），数据为 Event。为空时通用 Webhook 发送 Event 的 JSON，
+	// 群机器人发送包含数据库、连接名称、耗时、大小和错误信息的消息卡片
+	BodyTemplate string `json:"bodyTemplate,omitempty"`
+	// Secret 通用 Webhook 使用 HMAC-SHA256 对请求体签名，签名放在 X-DataSafe-Signature 请求头中；
+	// 钉钉和飞书机器人为安全设置中的加签密钥
+	Secret     string `json:"secret,omitempty"`
+	MaxRetries int    `json:"maxRetries"` // 失败后的重试次数，重试间隔依次翻倍
+	CreatedAt  string `json:"createdAt"`
+}
+
+// 投递状态
+const (
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery 一次 Webhook 请求的结果，每次重试单独记录
+type WebhookDelivery struct {
+	ID         int    `json:"id"`
+	WebhookID  int    `json:"webhookId"`
+	EventID    string `json:"eventId"`
+	EventType  string `json:"eventType"`
+	Attempt    int    `json:"attempt"` // 从 1 开始
+	Status     string `json:"status"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Response   string `json:"response,omitempty"` // 响应内容，只保留开头部分
+	Duration   int64  `json:"duration"`           // 请求耗时（毫秒）
+	Time       string `json:"time"`
+}
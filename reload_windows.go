@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// notifyReload Windows 没有 SIGHUP，只能通过 API 重新加载
+func notifyReload(reload func()) {}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 非用户触发的操作使用的操作人
+const (
+	AuditActorScheduler = "scheduler" // 定时任务
+	AuditActorCLI       = "cli"       // 命令行
+)
+
+// redactedValue 审计日志中敏感字段的占位值
+const redactedValue = "******"
+
+// sensitiveFields 审计日志中需要脱敏的字段（JSON 字段名）
+var sensitiveFields = map[string]bool{
+	"password":     true,
+	"clientSecret": true,
+	"clientKey":    true,
+	"privateKey":   true,
+	"passphrase":   true,
+	"secret":       true,
+}
+
+// AuditService 负责写入、查询和校验审计日志
+type AuditService struct {
+	store storage.Store
+}
+
+func NewAuditService(store storage.Store) *AuditService {
+	return &AuditService{store: store}
+}
+
+// Record 追加一条审计日志，err 不为空时记录为失败操作。写入失败只打印日志，不影响业务操作
+func (s *AuditService) Record(actor, ip, action, target string, settingID int, changes []models.AuditChange, err error) {
+	entry := &models.AuditEntry{
+		Time:      time.Now().Format("2006-01-02 15:04:05"),
+		Actor:     actor,
+		IP:        ip,
+		Action:    action,
+		Target:    target,
+		SettingID: settingID,
+		Changes:   changes,
+		Success:   err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if err := s.store.AppendAuditEntry(entry); err != nil {
+		slog.Error("写入审计日志失败", "action", action, "target", target, "error", err)
+	}
+}
+
+// DiffChanges 比较修改前后的对象，返回发生变化的字段，敏感字段只记录是否修改。
+// before 或 after 为 nil 分别表示新建和删除
+func DiffChanges(before, after interface{}) []models.AuditChange {
+	beforeFields := toFieldMap(before)
+	afterFields := toFieldMap(after)
+
+	names := make(map[string]bool)
+	for name := range beforeFields {
+		names[name] = true
+	}
+	for name := range afterFields {
+		names[name] = true
+	}
+
+	var changes []models.AuditChange
+	for name := range names {
+		oldValue, newValue := beforeFields[name], afterFields[name]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		// Webhook 请求头中通常包含访问令牌，一并脱敏
+		if sensitiveFields[name[strings.LastIndex(name, ".")+1:]] || strings.HasPrefix(name, "headers.") {
+			oldValue, newValue = redact(oldValue), redact(newValue)
+		}
+		changes = append(changes, models.AuditChange{Field: name, Before: oldValue, After: newValue})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// toFieldMap 将对象按 JSON 字段展开为 map，嵌套对象展开为 "tls.mode" 形式，便于逐字段比较和脱敏
+func toFieldMap(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	flattenFields("", decoded, fields)
+	return fields
+}
+
+func flattenFields(prefix string, values, fields map[string]interface{}) {
+	for name, value := range values {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenFields(prefix+name+".", nested, fields)
+			continue
+		}
+		fields[prefix+name] = value
+	}
+}
+
+func redact(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return v
+	}
+	return redactedValue
+}
+
+// Query 按条件筛选审计日志，按时间倒序返回
+func (s *AuditService) Query(q models.AuditQuery) ([]*models.AuditEntry, error) {
+	entries, err := s.store.GetAuditEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	// 只有日期时，截止时间包含当天
+	to := q.To
+	if len(to) == len("2006-01-02") {
+		to += " 23:59:59"
+	}
+
+	var result []*models.AuditEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if q.Actor != "" && entry.Actor != q.Actor {
+			continue
+		}
+		if q.Action != "" {
+			if strings.HasSuffix(q.Action, ".") {
+				if !strings.HasPrefix(entry.Action, q.Action) {
+					continue
+				}
+			} else if entry.Action != q.Action {
+				continue
+			}
+		}
+		if q.SettingID != 0 && entry.SettingID != q.SettingID {
+			continue
+		}
+		// 时间格式固定，可以直接按字符串比较
+		if q.From != "" && entry.Time < q.From {
+			continue
+		}
+		if to != "" && entry.Time > to {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+// AuditVerifyResult 哈希链校验结果
+type AuditVerifyResult struct {
+	Valid    bool   `json:"valid"`
+	Count    int    `json:"count"`
+	BrokenAt int    `json:"brokenAt,omitempty"` // 第一条校验失败的日志 ID
+	Message  string `json:"message"`
+}
+
+// Verify 从头校验审计日志的哈希链，发现被修改、删除或插入的日志。
+// 最后一条日志的 ID 与 bucket 序列号比较，发现末尾被删除的日志
+func (s *AuditService) Verify() (*AuditVerifyResult, error) {
+	// 先读取序列号，之后追加的日志不会被误判为缺失
+	seq, err := s.store.AuditSequence()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.store.GetAuditEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	prevHash := ""
+	for i, entry := range entries {
+		broken := func(message string) (*AuditVerifyResult, error) {
+			return &AuditVerifyResult{Count: len(entries), BrokenAt: entry.ID, Message: message}, nil
+		}
+
+		if i > 0 && entry.ID != entries[i-1].ID+1 {
+			return broken(fmt.Sprintf("日志 %d 之前存在缺失的日志", entry.ID))
+		}
+		if entry.PrevHash != prevHash {
+			return broken(fmt.Sprintf("日志 %d 与上一条日志的哈希不连续", entry.ID))
+		}
+		hash, err := storage.AuditEntryHash(entry)
+		if err != nil {
+			return nil, err
+		}
+		if hash != entry.Hash {
+			return broken(fmt.Sprintf("日志 %d 的内容已被修改", entry.ID))
+		}
+		prevHash = entry.Hash
+	}
+
+	lastID := 0
+	if len(entries) > 0 {
+		lastID = entries[len(entries)-1].ID
+	}
+	if lastID < seq {
+		return &AuditVerifyResult{
+			Count:    len(entries),
+			BrokenAt: lastID + 1,
+			Message:  fmt.Sprintf("最后 %d 条日志（%d 至 %d）已被删除", seq-lastID, lastID+1, seq),
+		}, nil
+	}
+
+	return &AuditVerifyResult{Valid: true, Count: len(entries), Message: "审计日志完整"}, nil
+}
+
+// WriteAuditCSV 将审计日志导出为 CSV
+func WriteAuditCSV(w io.Writer, entries []*models.AuditEntry) error {
+	writer := csv.NewWriter(w)
+	header := []string{"id", "time", "actor", "ip", "action", "target", "settingId", "success", "error", "changes", "hash"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		changes := ""
+		if len(entry.Changes) > 0 {
+			data, err := json.Marshal(entry.Changes)
+			if err != nil {
+				return err
+			}
+			changes = string(data)
+		}
+		row := []string{
+			strconv.Itoa(entry.ID),
+			entry.Time,
+			entry.Actor,
+			entry.IP,
+			entry.Action,
+			entry.Target,
+			strconv.Itoa(entry.SettingID),
+			strconv.FormatBool(entry.Success),
+			entry.Error,
+			changes,
+			entry.Hash,
+		}
+		for i, cell := range row {
+			row[i] = csvSafe(cell)
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvSafe 以 =、+、-、@ 开头的单元格前加单引号，避免表格软件把操作人、目标等内容当作公式执行
+func csvSafe(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"strings"
+	"testing"
+)
+
+// tamperedStore 在读取审计日志时改写返回结果，模拟直接修改数据文件
+type tamperedStore struct {
+	storage.Store
+	tamper func([]*models.AuditEntry) []*models.AuditEntry
+}
+
+func (s *tamperedStore) GetAuditEntries() ([]*models.AuditEntry, error) {
+	entries, err := s.Store.GetAuditEntries()
+	if err != nil {
+		return nil, err
+	}
+	return s.tamper(entries), nil
+}
+
+// rehash 重新计算从 from 开始的哈希链，模拟知道哈希算法的篡改者
+func rehash(t *testing.T, entries []*models.AuditEntry, from int) {
+	t.Helper()
+	for i := from; i < len(entries); i++ {
+		entries[i].PrevHash = ""
+		if i > 0 {
+			entries[i].PrevHash = entries[i-1].Hash
+		}
+		hash, err := storage.AuditEntryHash(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[i].Hash = hash
+	}
+}
+
+func TestAuditVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(*testing.T, []*models.AuditEntry) []*models.AuditEntry
+		brokenAt int
+		message  string // 为空表示校验通过
+	}{
+		{
+			name:   "intact",
+			tamper: func(t *testing.T, entries []*models.AuditEntry) []*models.AuditEntry { return entries },
+		},
+		{
+			name: "modified entry",
+			tamper: func(t *testing.T, entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[1].Actor = "nobody"
+				return entries
+			},
+			brokenAt: 2,
+			message:  "日志 2 的内容已被修改",
+		},
+		{
+			name: "modified entry with recomputed hash",
+			tamper: func(t *testing.T, entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[1].Actor = "nobody"
+				hash, err := storage.AuditEntryHash(entries[1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries[1].Hash = hash
+				return entries
+			},
+			brokenAt: 3,
+			message:  "日志 3 与上一条日志的哈希不连续",
+		},
+		{
+			name: "deleted middle entry",
+			tamper: func(t *testing.T, entries []*models.AuditEntry) []*models.AuditEntry {
+				return append(entries[:1], entries[2:]...)
+			},
+			brokenAt: 3,
+			message:  "日志 3 之前存在缺失的日志",
+		},
+		{
+			name: "deleted middle entry with rebuilt chain",
+			tamper: func(t *testing.T, entries []*models.AuditEntry) []*models.AuditEntry {
+				entries = append(entries[:1], entries[2:]...)
+				rehash(t, entries, 1)
+				return entries
+			},
+			brokenAt: 3,
+			message:  "日志 3 之前存在缺失的日志",
+		},
+		{
+			name: "deleted tail",
+			tamper: func(t *testing.T, entries []*models.AuditEntry) []*models.AuditEntry {
+				return entries[:2]
+			},
+			brokenAt: 3,
+			message:  "最后 2 条日志（3 至 4）已被删除",
+		},
+		{
+			name: "deleted everything",
+			tamper: func(t *testing.T, entries []*models.AuditEntry) []*models.AuditEntry {
+				return nil
+			},
+			brokenAt: 1,
+			message:  "最后 4 条日志（1 至 4）已被删除",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			writer := NewAuditService(store)
+			writer.Record("admin", "10.0.0.1", "settings.save", "setting:1", 1, nil, nil)
+			writer.Record("admin", "10.0.0.1", "schedule.add", "schedule:1", 1, nil, nil)
+			writer.Record(AuditActorScheduler, "", "backup.create", "backup:1", 1, nil, errors.New("连接超时"))
+			writer.Record("admin", "10.0.0.1", "backup.delete", "backup:1", 1, nil, nil)
+
+			audit := NewAuditService(&tamperedStore{Store: store, tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				return tt.tamper(t, entries)
+			}})
+			result, err := audit.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.message == "" {
+				if !result.Valid || result.Count != 4 {
+					t.Fatalf("result = %+v", result)
+				}
+				return
+			}
+			if result.Valid || result.BrokenAt != tt.brokenAt || !strings.Contains(result.Message, tt.message) {
+				t.Fatalf("result = %+v, want broken at %d with %q", result, tt.brokenAt, tt.message)
+			}
+		})
+	}
+}
+
+func TestWriteAuditCSVEscapesFormulas(t *testing.T) {
+	entries := []*models.AuditEntry{
+		{ID: 1, Time: "2025-01-10 12:00:00", Actor: "=HYPERLINK(\"http://evil\")", Action: "settings.save", Target: "+cmd", Error: "-1", Success: true},
+		{ID: 2, Time: "2025-01-10 12:00:01", Actor: "@SUM(A1)", Action: "backup.create", Target: "backup:1", Success: true},
+	}
+	var buf bytes.Buffer
+	if err := WriteAuditCSV(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		row, col int
+		want     string
+	}{
+		{1, 2, "'=HYPERLINK(\"http://evil\")"},
+		{1, 5, "'+cmd"},
+		{1, 8, "'-1"},
+		{2, 2, "'@SUM(A1)"},
+		{2, 5, "backup:1"},
+	}
+	for _, tt := range tests {
+		if got := rows[tt.row][tt.col]; got != tt.want {
+			t.Errorf("%s = %q, want %q", rows[0][tt.col], got, tt.want)
+		}
+	}
+}
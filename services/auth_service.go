@@ -0,0 +1,276 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionTTL 登录会话有效期
+const SessionTTL = 12 * time.Hour
+
+// minPasswordLength 本地用户密码最小长度
+const minPasswordLength = 8
+
+// dummyPasswordHash 用户不存在时用于比较的哈希
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("datasafe-dummy-password"), bcrypt.DefaultCost)
+
+type AuthService struct {
+	store storage.Store
+}
+
+func NewAuthService(store storage.Store) *AuthService {
+	return &AuthService{store: store}
+}
+
+// NeedsSetup 是否还没有任何用户，需要创建首个管理员
+func (s *AuthService) NeedsSetup() (bool, error) {
+	count, err := s.store.CountUsers()
+	if err != nil {
+		return false, fmt.Errorf("获取用户数量失败: %v", err)
+	}
+	return count == 0, nil
+}
+
+// Bootstrap 首次运行时创建管理员账号，已存在用户时拒绝。检查和创建在同一事务中完成，
+// 并发的初始化请求只有一个会成功
+func (s *AuthService) Bootstrap(username, password string) (*models.User, error) {
+	user, err := newUser(models.UserRequest{
+		Username: username,
+		Password: password,
+		Role:     models.RoleAdmin,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.CreateFirstUser(user); err != nil {
+		if errors.Is(err, storage.ErrUsersExist) {
+			return nil, fmt.Errorf("系统已初始化")
+		}
+		return nil, fmt.Errorf("保存用户失败: %v", err)
+	}
+	return user, nil
+}
+
+// CreateUser 创建本地用户
+func (s *AuthService) CreateUser(req models.UserRequest) (*models.User, error) {
+	user, err := newUser(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.SaveUser(user); err != nil {
+		return nil, fmt.Errorf("保存用户失败: %v", err)
+	}
+	return user, nil
+}
+
+// newUser 校验请求并生成待保存的用户
+func newUser(req models.UserRequest) (*models.User, error) {
+	username := strings.TrimSpace(req.Username)
+	if username == "" {
+		return nil, fmt.Errorf("用户名不能为空")
+	}
+	if err := validateUserRequest(req.Role); err != nil {
+		return nil, err
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         req.Role,
+		SettingIDs:   req.SettingIDs,
+		CreatedAt:    time.Now().Format("2006-01-02 15:04:05"),
+	}, nil
+}
+
+// UpdateUser 更新用户角色和授权，密码不为空时重置密码
+func (s *AuthService) UpdateUser(id int, req models.UserRequest) (*models.User, error) {
+	if err := validateUserRequest(req.Role); err != nil {
+		return nil, err
+	}
+
+	user, err := s.store.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = req.Role
+	user.SettingIDs = req.SettingIDs
+	if req.Password != "" {
+		if err := s.SetPassword(user, req.Password); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	if err := s.store.SaveUser(user); err != nil {
+		return nil, fmt.Errorf("保存用户失败: %v", err)
+	}
+	return user, nil
+}
+
+// ChangePassword 修改密码，并使该用户的其他会话失效
+func (s *AuthService) ChangePassword(userID int, oldPassword, newPassword string) error {
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(oldPassword)) != nil {
+		return fmt.Errorf("原密码错误")
+	}
+
+	return s.SetPassword(user, newPassword)
+}
+
+// SetPassword 直接设置用户密码（管理员重置），并使该用户的所有会话失效
+func (s *AuthService) SetPassword(user *models.User, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = hash
+	if err := s.store.SaveUser(user); err != nil {
+		return fmt.Errorf("保存用户失败: %v", err)
+	}
+	return s.store.DeleteUserSessions(user.ID)
+}
+
+// ListUsers 获取所有用户
+func (s *AuthService) ListUsers() ([]*models.User, error) {
+	return s.store.GetAllUsers()
+}
+
+// DeleteUser 删除用户及其会话
+func (s *AuthService) DeleteUser(id int) error {
+	if err := s.store.DeleteUserSessions(id); err != nil {
+		return fmt.Errorf("删除用户会话失败: %v", err)
+	}
+	return s.store.DeleteUser(id)
+}
+
+// Login 校验用户名密码，成功后创建会话并返回会话令牌
+func (s *AuthService) Login(username, password, ip, userAgent string) (string, *models.User, error) {
+	user, err := s.store.GetUserByUsername(strings.TrimSpace(username))
+	if err != nil || user.PasswordHash == "" {
+		// 用户不存在时也执行一次比较，避免通过耗时判断用户是否存在
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return "", nil, fmt.Errorf("用户名或密码错误")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return "", nil, fmt.Errorf("用户名或密码错误")
+	}
+
+	token, err := s.CreateSession(user, ip, userAgent)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, user, nil
+}
+
+// CreateSession 为用户创建会话并返回会话令牌，存储中只保存令牌的哈希。
+// 只更新用户的最后登录时间，不会覆盖登录期间管理员修改的角色；同时清理已过期的会话
+func (s *AuthService) CreateSession(user *models.User, ip, userAgent string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("生成会话失败: %v", err)
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:        hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now.Format("2006-01-02 15:04:05"),
+		ExpiresAt: now.Add(SessionTTL).Format("2006-01-02 15:04:05"),
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+	if err := s.store.SaveSession(session); err != nil {
+		return "", fmt.Errorf("保存会话失败: %v", err)
+	}
+
+	user.LastLoginAt = session.CreatedAt
+	if err := s.store.TouchUserLogin(user.ID, user.LastLoginAt); err != nil {
+		return "", fmt.Errorf("保存用户失败: %v", err)
+	}
+
+	// 未退出登录的会话过期后不会再被访问，在登录时顺带删除
+	if _, err := s.store.DeleteExpiredSessions(session.CreatedAt); err != nil {
+		slog.Warn("清理过期会话失败", "error", err)
+	}
+	return token, nil
+}
+
+// Authenticate 根据会话令牌返回当前用户，会话过期时删除会话
+func (s *AuthService) Authenticate(token string) (*models.User, error) {
+	if token == "" {
+		return nil, fmt.Errorf("未登录")
+	}
+
+	id := hashToken(token)
+	session, err := s.store.GetSession(id)
+	if err != nil {
+		return nil, fmt.Errorf("会话无效")
+	}
+
+	expiresAt, err := time.ParseInLocation("2006-01-02 15:04:05", session.ExpiresAt, time.Local)
+	if err != nil || time.Now().After(expiresAt) {
+		s.store.DeleteSession(id)
+		return nil, fmt.Errorf("会话已过期")
+	}
+
+	user, err := s.store.GetUserByID(session.UserID)
+	if err != nil {
+		s.store.DeleteSession(id)
+		return nil, fmt.Errorf("用户不存在")
+	}
+	return user, nil
+}
+
+// Logout 删除会话
+func (s *AuthService) Logout(token string) error {
+	if token == "" {
+		return nil
+	}
+	return s.store.DeleteSession(hashToken(token))
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("密码长度不能少于 %d 位", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("生成密码哈希失败: %v", err)
+	}
+	return string(hash), nil
+}
+
+// randomToken 生成 n 字节的随机令牌（十六进制）
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken 计算令牌的 SHA-256 哈希，存储中不保存明文令牌
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"mysql-backup/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreateSessionKeepsConcurrentRoleChange(t *testing.T) {
+	store := newTestStore(t)
+	auth := NewAuthService(store)
+	created, err := auth.CreateUser(models.UserRequest{Username: "alice", Password: "password123", Role: models.RoleOperator, SettingIDs: []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 登录时读取的用户，会话创建之前管理员降低了权限
+	stale, err := store.GetUserByID(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.UpdateUser(created.ID, models.UserRequest{Role: models.RoleViewer, SettingIDs: []int{2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := auth.CreateSession(stale, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := auth.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleViewer || !reflect.DeepEqual(user.SettingIDs, []int{2}) {
+		t.Fatalf("role = %s, settings = %v, want the role change kept", user.Role, user.SettingIDs)
+	}
+	if user.LastLoginAt == "" {
+		t.Fatal("last login time not recorded")
+	}
+}
+
+func TestCreateSessionPrunesExpiredSessions(t *testing.T) {
+	store := newTestStore(t)
+	auth := NewAuthService(store)
+	user, err := auth.CreateUser(models.UserRequest{Username: "alice", Password: "password123", Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	sessions := map[string]time.Time{
+		"expired": now.Add(-time.Hour),
+		"active":  now.Add(time.Hour),
+	}
+	for id, expiresAt := range sessions {
+		// 其他用户的过期会话同样会被清理
+		if err := store.SaveSession(&models.Session{ID: id, UserID: user.ID + 1, ExpiresAt: expiresAt.Format("2006-01-02 15:04:05")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := auth.CreateSession(user, "10.0.0.1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSession("expired"); err == nil {
+		t.Fatal("expired session kept")
+	}
+	if _, err := store.GetSession("active"); err != nil {
+		t.Fatalf("active session removed: %v", err)
+	}
+}
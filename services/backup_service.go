@@ -1,97 +1,231 @@
 package services
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"mysql-backup/config"
 	"mysql-backup/models"
 	"mysql-backup/storage"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type BackupService struct {
-	config *config.Config
+	backupRoot string
+	workers    chan struct{} // 限制同时执行的备份和恢复任务数量
+	queued     atomic.Int64  // 等待任务名额的备份和恢复任务数量
+	events     *EventBus     // 备份开始、完成、失败等事件
+
+	jobsMu  sync.Mutex
+	jobLogs map[int]*jobLog // 正在执行的备份任务的日志，按备份记录 ID 索引
+	jobs    sync.WaitGroup  // 正在执行和排队的备份、恢复任务
+	closing bool            // 服务正在停止，不再接受新的任务
+
+	ctx    context.Context // 停止服务等待超时后取消，正在执行的备份和恢复随之中止
+	cancel context.CancelFunc
+
+	dirCheckMu  sync.Mutex
+	dirCheckAt  time.Time // 上次检查备份目录的时间，检查结果缓存 dirCheckTTL
+	dirCheckErr error
 }
 
-func NewBackupService() *BackupService {
-	return &BackupService{}
+const (
+	// cancelGracePeriod 取消任务后等待其退出的时长
+	cancelGracePeriod = 10 * time.Second
+	// dirCheckTTL 备份目录检查结果的缓存时长，避免频繁的就绪检查反复写文件
+	dirCheckTTL = 10 * time.Second
+)
+
+var (
+	errShuttingDown = errors.New("服务正在停止，不再接受新的任务")
+	errJobCanceled  = errors.New("服务正在停止，任务已取消")
+)
+
+func NewBackupService(cfg *config.Config) *BackupService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BackupService{
+		backupRoot: cfg.Backup.Root,
+		workers:    make(chan struct{}, cfg.Backup.Workers),
+		events:     NewEventBus(),
+		jobLogs:    make(map[int]*jobLog),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
 }
 
-func (s *BackupService) BackupDatabase(dbName string) error {
-	timestamp := time.Now().Format("20060102150405")
-	filename := fmt.Sprintf("%s/%s_%s.sql", s.config.Backup.BackupDir, dbName, timestamp)
+// Events 返回备份事件总线，用于订阅通知
+func (s *BackupService) Events() *EventBus {
+	return s.events
+}
 
-	cmd := exec.Command("mysqldump",
-		"-h", s.config.Database.Host,
-		"-P", fmt.Sprintf("%d", s.config.Database.Port),
-		"-u", s.config.Database.User,
-		fmt.Sprintf("-p%s", s.config.Database.Password),
-		dbName,
-	)
+// acquireWorker 等待空闲的任务名额，返回的函数用于释放名额。等待期间任务被取消时返回错误
+func (s *BackupService) acquireWorker() (func(), error) {
+	s.queued.Add(1)
+	defer s.queued.Add(-1)
+	select {
+	case s.workers <- struct{}{}:
+		return func() { <-s.workers }, nil
+	case <-s.ctx.Done():
+		return nil, errJobCanceled
+	}
+}
 
-	if s.config.Backup.Compression {
-		filename += ".gz"
-		gzip := exec.Command("gzip")
-		pipe, _ := gzip.StdinPipe()
+// startJob 登记一个备份或恢复任务，服务正在停止时返回错误，返回的函数在任务结束时调用
+func (s *BackupService) startJob() (func(), error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	if s.closing {
+		return nil, errShuttingDown
+	}
+	s.jobs.Add(1)
+	return s.jobs.Done, nil
+}
 
-		cmd.Stdout = pipe
-		gzip.Stdout, _ = os.Create(filename)
+// Closing 服务是否正在停止
+func (s *BackupService) Closing() bool {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	return s.closing
+}
 
-		gzip.Start()
-		err := cmd.Run()
-		pipe.Close()
-		gzip.Wait()
+// Shutdown 不再接受新的备份和恢复任务，等待正在执行的任务结束。ctx 到期后取消剩余任务，
+// 被取消的备份记录标记为失败并删除写了一半的文件
+func (s *BackupService) Shutdown(ctx context.Context) error {
+	s.jobsMu.Lock()
+	s.closing = true
+	s.jobsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	slog.Warn("等待任务超时，取消正在执行的备份和恢复")
+	s.cancel()
+	select {
+	case <-done:
+	case <-time.After(cancelGracePeriod):
+		return fmt.Errorf("取消后 %s 内仍有任务没有结束", cancelGracePeriod)
+	}
+	return ctx.Err()
+}
 
-		return err
+// CheckBackupDirs 检查备份根目录和各数据库配置的备份目录是否可写，结果缓存 dirCheckTTL。
+// 不会创建目录，尚未创建的目录检查最近的已存在的上级目录
+func (s *BackupService) CheckBackupDirs(settings []models.DBSettings) error {
+	s.dirCheckMu.Lock()
+	defer s.dirCheckMu.Unlock()
+	if !s.dirCheckAt.IsZero() && time.Since(s.dirCheckAt) < dirCheckTTL {
+		return s.dirCheckErr
 	}
 
-	outFile, err := os.Create(filename)
+	dirs := []string{s.backupRoot}
+	for _, setting := range settings {
+		if setting.BackupDir != "" {
+			dirs = append(dirs, setting.BackupDir)
+		}
+	}
+	s.dirCheckErr = nil
+	for _, dir := range dirs {
+		if err := checkWritable(dir); err != nil {
+			s.dirCheckErr = fmt.Errorf("备份目录 %s 不可写: %v", dir, err)
+			break
+		}
+	}
+	s.dirCheckAt = time.Now()
+	return s.dirCheckErr
+}
+
+// checkWritable 在目录中创建并删除一个临时文件，目录不存在时检查最近的已存在的上级目录
+func checkWritable(dir string) error {
+	dir, err := filepath.Abs(dir)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s 不是目录", dir)
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			return err
+		}
+		dir = parent
+	}
 
-	cmd.Stdout = outFile
-	return cmd.Run()
+	file, err := os.CreateTemp(dir, ".datasafe-ready-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
 }
 
-func (s *BackupService) GetDatabases() ([]string, error) {
-	// 执行 MySQL 命令列出所有数据库
-	cmd := exec.Command("mysql",
-		"-h", s.config.Database.Host,
-		"-P", fmt.Sprintf("%d", s.config.Database.Port),
-		"-u", s.config.Database.User,
-		fmt.Sprintf("-p%s", s.config.Database.Password),
-		"-e", "SHOW DATABASES",
-	)
-
-	output, err := cmd.Output()
+// FailInterrupted 将上次服务异常退出时未完成的备份记录标记为失败，并删除写了一半的文件。
+// 需要在开始执行任务之前调用
+func (s *BackupService) FailInterrupted(store storage.Store) (int, error) {
+	records, err := store.GetBackupRecords()
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("获取备份记录失败: %v", err)
 	}
-
-	// 解析输出，跳过第一行（标题行）
-	databases := strings.Split(string(output), "\n")[1:]
-	// 过滤掉空行和系统数据库
-	var result []string
-	for _, db := range databases {
-		db = strings.TrimSpace(db)
-		if db != "" && !strings.HasPrefix(db, "information_schema") &&
-			!strings.HasPrefix(db, "performance_schema") &&
-			!strings.HasPrefix(db, "mysql") &&
-			!strings.HasPrefix(db, "sys") {
-			result = append(result, db)
+	count := 0
+	for _, record := range records {
+		if record.Status != models.StatusInProgress {
+			continue
 		}
+		if setting, err := store.GetSettingByID(record.SettingID); err == nil {
+			path := filepath.Join(setting.BackupDir, record.FileName)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				slog.Warn("删除未完成的备份文件失败", "file", path, "error", err)
+			}
+		}
+		record.Status = models.StatusFailed
+		record.Error = "服务停止时备份未完成"
+		record.HasLog = false // 任务日志在任务结束时才保存，异常退出时没有保存
+		if err := store.UpdateBackupRecord(record); err != nil {
+			return count, fmt.Errorf("更新备份记录失败: %v", err)
+		}
+		count++
 	}
+	return count, nil
+}
 
-	return result, nil
+// JobStats 返回排队等待和正在执行的备份、恢复任务数量
+func (s *BackupService) JobStats() (queued, running int) {
+	return int(s.queued.Load()), len(s.workers)
+}
+
+// DefaultBackupDir 未指定备份目录时，在备份根目录下按配置名称生成目录
+func (s *BackupService) DefaultBackupDir(setting *models.DBSettings) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(setting.Name))
+	if strings.Trim(name, "_") == "" {
+		name = "default"
+	}
+	return filepath.Join(s.backupRoot, name)
 }
 
 func (s *BackupService) ScheduleBackup(database, schedule string) (int, error) {
@@ -102,16 +236,9 @@ func (s *BackupService) ScheduleBackup(database, schedule string) (int, error) {
 }
 
 func (s *BackupService) GetDatabasesWithConfig(setting *models.DBSettings) ([]string, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/",
-		setting.User,
-		setting.Password,
-		setting.Host,
-		setting.Port,
-	)
-
-	db, err := sql.Open("mysql", dsn)
+	db, err := openDB(setting, "")
 	if err != nil {
-		return nil, fmt.Errorf("连接数据库失败: %v", err)
+		return nil, err
 	}
 	defer db.Close()
 
@@ -144,68 +271,164 @@ func (s *BackupService) GetDatabasesWithConfig(setting *models.DBSettings) ([]st
 	return filterSystemDatabases(databases), nil
 }
 
-func (s *BackupService) BackupDatabaseWithConfig(setting *models.DBSettings, dbName string, store storage.Store) error {
+// BackupDatabaseWithConfig 执行备份并保存备份记录，返回的记录包含备份状态、大小和校验和
+func (s *BackupService) BackupDatabaseWithConfig(setting *models.DBSettings, dbName string, store storage.Store) (*models.BackupRecord, error) {
+	return s.backupDatabase(setting, dbName, store, 0)
+}
+
+// BackupScheduled 执行定时任务的备份，备份记录和事件中记录任务 ID
+func (s *BackupService) BackupScheduled(setting *models.DBSettings, dbName string, store storage.Store, scheduleID int) (*models.BackupRecord, error) {
+	return s.backupDatabase(setting, dbName, store, scheduleID)
+}
+
+func (s *BackupService) backupDatabase(setting *models.DBSettings, dbName string, store storage.Store, scheduleID int) (*models.BackupRecord, error) {
+	done, err := s.startJob()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	// 创建备份记录
 	record := &models.BackupRecord{
-		DBName:    dbName,
-		FileName:  fmt.Sprintf("%s_%s.sql", dbName, time.Now().Format("20060102150405")),
-		CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
-		Status:    "in_progress",
-		SettingID: setting.ID,
+		DBName:     dbName,
+		FileName:   fmt.Sprintf("%s_%s.sql", dbName, time.Now().Format("20060102150405")),
+		CreatedAt:  time.Now().Format("2006-01-02 15:04:05"),
+		Status:     models.StatusInProgress,
+		SettingID:  setting.ID,
+		ScheduleID: scheduleID,
+		HasLog:     true,
 	}
 
 	// 保存初始记录
 	if err := store.SaveBackupRecord(record); err != nil {
-		return fmt.Errorf("保存备份记录失败: %v", err)
+		err = fmt.Errorf("保存备份记录失败: %v", err)
+		slog.Error("备份失败", "setting", setting.Name, "database", dbName, "error", err)
+		event := newBackupEvent(models.EventBackupFailed, setting, dbName, nil, err)
+		event.ScheduleID = scheduleID
+		s.events.Publish(event)
+		return nil, err
 	}
 
-	// 执行备份
-	err := s.performBackup(setting, dbName, record.FileName)
+	// 任务日志在执行期间可以查看，结束后随备份记录保存
+	jobLog := &jobLog{}
+	s.jobsMu.Lock()
+	s.jobLogs[record.ID] = jobLog
+	s.jobsMu.Unlock()
+	defer func() {
+		if err := store.SaveBackupLog(record.ID, jobLog.String()); err != nil {
+			slog.Error("保存备份任务日志失败", "backup", record.ID, "error", err)
+		}
+		s.jobsMu.Lock()
+		delete(s.jobLogs, record.ID)
+		s.jobsMu.Unlock()
+	}()
+	logger := newJobLogger(jobLog, "backup", record.ID, "setting", setting.Name, "database", dbName)
+	if scheduleID != 0 {
+		logger.Info("开始定时备份", "file", record.FileName, "schedule", scheduleID)
+	} else {
+		logger.Info("开始备份", "file", record.FileName)
+	}
+	s.events.Publish(newBackupEvent(models.EventBackupStarted, setting, dbName, record, nil))
+
+	// 执行备份，任务数量达到上限时排队等待
+	queuedAt := time.Now()
+	release, err := s.acquireWorker()
+	if err == nil {
+		if waited := time.Since(queuedAt); waited >= time.Second {
+			logger.Info("等待任务名额", "waited", formatJobDuration(waited))
+		}
+		started := time.Now()
+		var rows int64
+		rows, err = s.performBackup(setting, dbName, record.FileName, logger)
+		record.Rows = rows
+		record.Duration = time.Since(started).Milliseconds()
+		release()
+		if err != nil && s.ctx.Err() != nil {
+			err = errJobCanceled
+		}
+	}
 
 	// 更新备份状态
 	if err != nil {
-		record.Status = "failed"
+		record.Status = models.StatusFailed
 		record.Error = err.Error()
 	} else {
-		record.Status = "completed"
+		record.Status = models.StatusCompleted
+		path := filepath.Join(setting.BackupDir, record.FileName)
+		if info, statErr := os.Stat(path); statErr == nil {
+			record.Size = info.Size()
+		} else {
+			logger.Warn("读取备份文件大小失败", "error", statErr)
+		}
+		// 记录校验和，用于之后校验备份文件是否损坏或被修改
+		if checksum, sumErr := fileSHA256(path); sumErr == nil {
+			record.Checksum = checksum
+		} else {
+			logger.Warn("计算备份文件校验和失败", "error", sumErr)
+		}
 	}
 
 	// 更新记录
 	if updateErr := store.UpdateBackupRecord(record); updateErr != nil {
 		// 如果更新记录失败，但备份成功，返回更新错误
 		if err == nil {
-			return fmt.Errorf("备份成功但更新记录失败: %v", updateErr)
+			err = fmt.Errorf("备份成功但更新记录失败: %v", updateErr)
+			logger.Error("备份失败", "error", err)
+			s.events.Publish(newBackupEvent(models.EventBackupFailed, setting, dbName, record, err))
+			return record, err
 		}
 	}
+	duration := formatJobDuration(time.Duration(record.Duration) * time.Millisecond)
+	if err != nil {
+		logger.Error("备份失败", "duration", duration, "error", err)
+		s.events.Publish(newBackupEvent(models.EventBackupFailed, setting, dbName, record, err))
+		return record, err
+	}
+	logger.Info("备份完成", "size", record.Size, "rows", record.Rows, "duration", duration)
+	s.events.Publish(newBackupEvent(models.EventBackupSucceeded, setting, dbName, record, nil))
+
+	// 在备份完成后按保留策略清理旧备份
+	removed, err := s.ApplyRetention(setting, dbName, store, false)
+	for _, decision := range removed {
+		logger.Info("按保留策略清理旧备份", "file", decision.Record.FileName, "reason", strings.Join(decision.Reasons, "，"))
+	}
+	if err != nil {
+		logger.Error("清理旧备份失败", "error", err)
+		return record, fmt.Errorf("清理旧备份失败: %v", err)
+	}
 
-	return err
+	return record, nil
 }
 
-// performBackup 执行实际的备份操作
-func (s *BackupService) performBackup(setting *models.DBSettings, dbName, fileName string) error {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-		setting.User,
-		setting.Password,
-		setting.Host,
-		setting.Port,
-		dbName,
-	)
-
-	db, err := sql.Open("mysql", dsn)
+// JobLog 返回备份任务的日志，任务仍在执行时 running 为 true，返回截至目前的日志
+func (s *BackupService) JobLog(id int, store storage.Store) (content string, running bool, err error) {
+	s.jobsMu.Lock()
+	live, ok := s.jobLogs[id]
+	s.jobsMu.Unlock()
+	if ok {
+		return live.String(), true, nil
+	}
+	content, err = store.GetBackupLog(id)
+	return content, false, err
+}
+
+// performBackup 执行实际的备份操作，返回导出的数据行数。备份失败或被取消时删除写了一半的文件
+func (s *BackupService) performBackup(setting *models.DBSettings, dbName, fileName string, logger *slog.Logger) (total int64, err error) {
+	db, err := openDB(setting, dbName)
 	if err != nil {
-		return fmt.Errorf("连接数据库失败: %v", err)
+		return 0, err
 	}
 	defer db.Close()
 
 	// 确保备份目录存在
 	if err := os.MkdirAll(setting.BackupDir, 0755); err != nil {
-		return fmt.Errorf("创建备份目录失败: %v", err)
+		return 0, fmt.Errorf("创建备份目录失败: %v", err)
 	}
 
 	// 获取所有表
-	rows, err := db.Query("SHOW TABLES")
+	rows, err := db.QueryContext(s.ctx, "SHOW TABLES")
 	if err != nil {
-		return fmt.Errorf("获取表列表失败: %v", err)
+		return 0, fmt.Errorf("获取表列表失败: %v", err)
 	}
 	defer rows.Close()
 
@@ -213,19 +436,27 @@ func (s *BackupService) performBackup(setting *models.DBSettings, dbName, fileNa
 	for rows.Next() {
 		var tableName string
 		if err := rows.Scan(&tableName); err != nil {
-			return fmt.Errorf("读取表名失败: %v", err)
+			return 0, fmt.Errorf("读取表名失败: %v", err)
 		}
 		tables = append(tables, tableName)
 	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("获取表列表失败: %v", err)
+	}
+	logger.Info("读取表列表", "tables", len(tables))
 
-	// 创建备份文件
-	timestamp := time.Now().Format("20060102150405")
-	filename := filepath.Join(setting.BackupDir, fmt.Sprintf("%s_%s.sql", dbName, timestamp))
+	// 创建备份文件，文件名与备份记录保持一致
+	filename := filepath.Join(setting.BackupDir, fileName)
 	file, err := os.Create(filename)
 	if err != nil {
-		return fmt.Errorf("创建备份文件失败: %v", err)
+		return 0, fmt.Errorf("创建备份文件失败: %v", err)
 	}
-	defer file.Close()
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(filename)
+		}
+	}()
 
 	// 写入数据库创建语句
 	fmt.Fprintf(file, "CREATE DATABASE IF NOT EXISTS `%s`;\n", dbName)
@@ -233,24 +464,26 @@ func (s *BackupService) performBackup(setting *models.DBSettings, dbName, fileNa
 
 	// 备份每个表的结构和数据
 	for _, table := range tables {
+		tableStarted := time.Now()
+		var tableRows int64
 		// 获取表结构
 		var createTable string
-		err := db.QueryRow("SHOW CREATE TABLE `"+table+"`").Scan(&table, &createTable)
+		err := db.QueryRowContext(s.ctx, "SHOW CREATE TABLE `"+table+"`").Scan(&table, &createTable)
 		if err != nil {
-			return fmt.Errorf("获取表 %s 的结构失败: %v", table, err)
+			return 0, fmt.Errorf("获取表 %s 的结构失败: %v", table, err)
 		}
 		fmt.Fprintln(file, createTable+";\n")
 
 		// 获取表数据
-		rows, err := db.Query("SELECT * FROM `" + table + "`")
+		rows, err := db.QueryContext(s.ctx, "SELECT * FROM `"+table+"`")
 		if err != nil {
-			return fmt.Errorf("读取表 %s 的数据失败: %v", table, err)
+			return 0, fmt.Errorf("读取表 %s 的数据失败: %v", table, err)
 		}
 
 		columns, err := rows.Columns()
 		if err != nil {
 			rows.Close()
-			return fmt.Errorf("获取表 %s 的列信息失败: %v", table, err)
+			return 0, fmt.Errorf("获取表 %s 的列信息失败: %v", table, err)
 		}
 
 		// 准备数据
@@ -265,7 +498,7 @@ func (s *BackupService) performBackup(setting *models.DBSettings, dbName, fileNa
 			err := rows.Scan(scanArgs...)
 			if err != nil {
 				rows.Close()
-				return fmt.Errorf("读取行数据失败: %v", err)
+				return 0, fmt.Errorf("读取行数据失败: %v", err)
 			}
 
 			fmt.Fprintf(file, "INSERT INTO `%s` VALUES (", table)
@@ -276,17 +509,19 @@ func (s *BackupService) performBackup(setting *models.DBSettings, dbName, fileNa
 				fmt.Fprint(file, formatValue(value))
 			}
 			fmt.Fprintln(file, ");")
+			tableRows++
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("读取表 %s 的数据失败: %v", table, err)
 		}
 		rows.Close()
 		fmt.Fprintln(file)
+		total += tableRows
+		logger.Info("导出表", "table", table, "rows", tableRows, "duration", formatJobDuration(time.Since(tableStarted)))
 	}
 
-	// 在备份完成后清理旧文件
-	if err := s.cleanOldBackups(setting, dbName); err != nil {
-		return fmt.Errorf("清理旧备份失败: %v", err)
-	}
-
-	return nil
+	return total, nil
 }
 
 // 格式化值为 SQL 字符串
@@ -306,9 +541,11 @@ func formatValue(value interface{}) string {
 	}
 }
 
-// 转义 SQL 字符串
+// 转义 SQL 字符串。MySQL 把反斜杠当作转义符，原样写入的反斜杠会和后面的字符组成转义序列，
+// 以反斜杠结尾的值还会转义掉结尾的引号，所以反斜杠本身也要转义
 func escapeString(s string) string {
 	return strings.NewReplacer(
+		"\\", "\\\\",
 		"'", "\\'",
 		"\"", "\\\"",
 		"\n", "\\n",
@@ -349,36 +586,77 @@ func filterSystemDatabases(databases []string) []string {
 	return result
 }
 
-// TestConnection 测试数据库连接
-func (s *BackupService) TestConnection(settings *models.DBSettings) error {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=5s",
-		settings.User,
-		settings.Password,
-		settings.Host,
-		settings.Port,
-	)
+// ConnectionInfo 测试连接时获取的服务器和加密信息
+type ConnectionInfo struct {
+	ServerVersion string `json:"serverVersion"`
+	TLSVersion    string `json:"tlsVersion,omitempty"` // 为空表示未加密
+	TLSCipher     string `json:"tlsCipher,omitempty"`
+}
+
+// testConnectTimeout 测试连接时的最长连接超时
+const testConnectTimeout = 5 * time.Second
 
-	db, err := sql.Open("mysql", dsn)
+// TestConnection 测试数据库连接，返回服务器版本和协商的 TLS 版本、加密套件
+func (s *BackupService) TestConnection(settings *models.DBSettings) (*ConnectionInfo, error) {
+	cfg, err := buildMySQLConfig(settings, "", false)
 	if err != nil {
-		return fmt.Errorf("初始化连接失败: %v", err)
+		return nil, err
+	}
+	// 测试连接时不使用过长的连接超时
+	if cfg.Timeout > testConnectTimeout {
+		cfg.Timeout = testConnectTimeout
+	}
+
+	db, err := connectDB(cfg)
+	if err != nil {
+		return nil, err
 	}
 	defer db.Close()
 
-	// 测试连接
-	if err := db.Ping(); err != nil {
-		return fmt.Errorf("连接测试失败: %v", err)
+	// 使用同一个连接测试并查询会话的加密状态
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("连接测试失败: %v", err)
 	}
+	defer conn.Close()
 
-	return nil
+	info := &ConnectionInfo{}
+	ctx := context.Background()
+	if err := conn.QueryRowContext(ctx, "SELECT VERSION()").Scan(&info.ServerVersion); err != nil {
+		return nil, fmt.Errorf("查询服务器版本失败: %v", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, "SHOW SESSION STATUS WHERE Variable_name IN ('Ssl_version', 'Ssl_cipher')")
+	if err != nil {
+		return nil, fmt.Errorf("查询加密状态失败: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("读取加密状态失败: %v", err)
+		}
+		switch name {
+		case "Ssl_version":
+			info.TLSVersion = value
+		case "Ssl_cipher":
+			info.TLSCipher = value
+		}
+	}
+
+	return info, rows.Err()
 }
 
 // ValidateSettings 验证配置
 func (s *BackupService) ValidateSettings(settings *models.DBSettings) error {
-	if settings.Host == "" {
-		return fmt.Errorf("主机地址不能为空")
-	}
-	if settings.Port <= 0 || settings.Port > 65535 {
-		return fmt.Errorf("无效的端口号")
+	// 使用 Unix socket 时不需要主机地址和端口
+	if settings.Socket == "" {
+		if settings.Host == "" {
+			return fmt.Errorf("主机地址不能为空")
+		}
+		if settings.Port <= 0 || settings.Port > 65535 {
+			return fmt.Errorf("无效的端口号")
+		}
 	}
 	if settings.User == "" {
 		return fmt.Errorf("用户名不能为空")
@@ -386,7 +664,7 @@ func (s *BackupService) ValidateSettings(settings *models.DBSettings) error {
 	if settings.BackupDir == "" {
 		return fmt.Errorf("备份目录不能为空")
 	}
-	return nil
+	return ValidateConnectionOptions(settings)
 }
 
 // 添加备份文件管理功能
@@ -433,42 +711,56 @@ type BackupFile struct {
 	CreatedAt string `json:"createdAt"`
 }
 
-// cleanOldBackups 清理旧的备份文件
-func (s *BackupService) cleanOldBackups(setting *models.DBSettings, dbName string) error {
-	if setting.MaxBackups <= 0 {
-		return nil // 不限制备份数量
+// ApplyRetention 按配置的保留策略清理数据库的旧备份，database 为空时处理该配置下的所有数据库。
+// 返回需要删除的备份，dryRun 为 true 时只返回结果不删除
+func (s *BackupService) ApplyRetention(setting *models.DBSettings, database string, store storage.Store, dryRun bool) ([]*RetentionDecision, error) {
+	policy := effectivePolicy(setting)
+	if policy == nil {
+		return nil, nil // 不限制备份数量
 	}
 
-	files, err := os.ReadDir(setting.BackupDir)
+	records, err := store.GetBackupRecordsBySettingID(setting.ID)
 	if err != nil {
-		return fmt.Errorf("读取备份目录失败: %v", err)
+		return nil, fmt.Errorf("获取备份记录失败: %v", err)
 	}
 
-	// 获取指定数据库的备份文件
-	var backupFiles []os.DirEntry
-	for _, file := range files {
-		if !file.IsDir() && strings.HasPrefix(file.Name(), dbName+"_") {
-			backupFiles = append(backupFiles, file)
+	var removed []*RetentionDecision
+	for _, decisions := range s.PreviewRetention(setting, database, policy, records) {
+		for _, decision := range decisions {
+			if decision.Keep {
+				continue
+			}
+			if !dryRun {
+				if err := s.PruneBackup(setting, decision.Record.ID, store); err != nil {
+					return removed, fmt.Errorf("删除旧备份文件失败: %v", err)
+				}
+				s.events.Publish(newBackupEvent(models.EventRetentionPruned, setting, decision.Record.DBName, decision.Record, nil))
+			}
+			removed = append(removed, decision)
 		}
 	}
 
-	// 如果备份文件数量超过限制，删除最旧的文件
-	if len(backupFiles) > setting.MaxBackups {
-		// 按修改时间排序
-		sort.Slice(backupFiles, func(i, j int) bool {
-			iInfo, _ := backupFiles[i].Info()
-			jInfo, _ := backupFiles[j].Info()
-			return iInfo.ModTime().After(jInfo.ModTime())
-		})
+	return removed, nil
+}
 
-		// 删除多余的文件
-		for i := setting.MaxBackups; i < len(backupFiles); i++ {
-			filename := backupFiles[i].Name()
-			if err := s.DeleteBackupFile(setting.BackupDir, filename); err != nil {
-				return fmt.Errorf("删除旧备份文件失败: %v", err)
+// PruneBackup 删除备份记录对应的文件，并在同一事务中将记录标记为已清理
+func (s *BackupService) PruneBackup(setting *models.DBSettings, recordID int, store storage.Store) error {
+	return store.PruneBackupRecord(recordID, func(record *models.BackupRecord) error {
+		if record.SettingID != setting.ID {
+			return fmt.Errorf("备份记录 %d 不属于配置 %d", record.ID, setting.ID)
+		}
+		if record.Status == models.StatusInProgress {
+			return fmt.Errorf("备份 %d 正在进行中，无法删除", record.ID)
+		}
+		if IsPinned(record, time.Now()) {
+			return fmt.Errorf("备份 %d 已固定（%s），请先取消固定", record.ID, record.PinReason)
+		}
+		err := s.DeleteBackupFile(setting.BackupDir, record.FileName)
+		if err != nil {
+			if _, statErr := os.Stat(filepath.Join(setting.BackupDir, record.FileName)); os.IsNotExist(statErr) {
+				return nil // 文件已不存在，仅更新记录
 			}
 		}
-	}
-
-	return nil
+		return err
+	})
 }
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"fmt"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReconcileOptions 备份目录与备份记录对账选项
+type ReconcileOptions struct {
+	SettingID    int  `json:"settingId"`    // 只对账指定配置，0表示全部
+	AdoptOrphans bool `json:"adoptOrphans"` // 为没有记录的备份文件补建记录
+	PurgeOrphans bool `json:"purgeOrphans"` // 删除没有记录的备份文件
+	PurgeMissing bool `json:"purgeMissing"` // 删除文件已不存在的备份记录
+}
+
+// OrphanFile 没有对应备份记录的备份文件
+type OrphanFile struct {
+	SettingID int    `json:"settingId"`
+	FileName  string `json:"fileName"`
+	Size      int64  `json:"size"`
+	ModTime   string `json:"modTime"`
+	Action    string `json:"action,omitempty"` // "adopted", "purged"
+	Error     string `json:"error,omitempty"`
+}
+
+// MissingFile 文件已不存在的备份记录
+type MissingFile struct {
+	Record *models.BackupRecord `json:"record"`
+	Action string               `json:"action,omitempty"` // "purged"
+	Error  string               `json:"error,omitempty"`
+}
+
+// ReconcileReport 对账结果
+type ReconcileReport struct {
+	OrphanFiles  []*OrphanFile  `json:"orphanFiles"`
+	MissingFiles []*MissingFile `json:"missingFiles"`
+}
+
+// Reconcile 对比备份目录中的文件与备份记录，报告没有记录的文件和没有文件的记录，
+// 并按选项补建记录或清理。多个配置共用同一备份目录时，文件只要属于其中任一配置即视为已登记。
+func (s *BackupService) Reconcile(store storage.Store, opts ReconcileOptions) (*ReconcileReport, error) {
+	if opts.AdoptOrphans && opts.PurgeOrphans {
+		return nil, fmt.Errorf("adoptOrphans 与 purgeOrphans 不能同时开启")
+	}
+
+	settings, err := store.GetAllSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库配置失败: %v", err)
+	}
+	records, err := store.GetBackupRecords()
+	if err != nil {
+		return nil, fmt.Errorf("获取备份记录失败: %v", err)
+	}
+
+	settingDirs := make(map[int]string)
+	for _, setting := range settings {
+		settingDirs[setting.ID] = filepath.Clean(setting.BackupDir)
+	}
+
+	// 每个备份目录下已登记的文件名
+	known := make(map[string]map[string]bool)
+	for _, record := range records {
+		dir, ok := settingDirs[record.SettingID]
+		if !ok || record.Status == models.StatusPruned {
+			continue
+		}
+		if known[dir] == nil {
+			known[dir] = make(map[string]bool)
+		}
+		known[dir][record.FileName] = true
+	}
+
+	report := &ReconcileReport{
+		OrphanFiles:  []*OrphanFile{},
+		MissingFiles: []*MissingFile{},
+	}
+
+	// 文件已不存在的记录
+	for _, record := range records {
+		if opts.SettingID != 0 && record.SettingID != opts.SettingID {
+			continue
+		}
+		dir, ok := settingDirs[record.SettingID]
+		if !ok || record.Status != models.StatusCompleted {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, record.FileName)); !os.IsNotExist(err) {
+			continue
+		}
+
+		missing := &MissingFile{Record: record}
+		if opts.PurgeMissing && IsPinned(record, time.Now()) {
+			missing.Error = "备份已固定，不清理记录"
+		} else if opts.PurgeMissing {
+			if err := store.DeleteBackupRecord(record.ID); err != nil {
+				missing.Error = err.Error()
+			} else {
+				missing.Action = "purged"
+			}
+		}
+		report.MissingFiles = append(report.MissingFiles, missing)
+	}
+
+	// 没有记录的文件，同一目录只扫描一次
+	scanned := make(map[string]bool)
+	for _, setting := range settings {
+		if opts.SettingID != 0 && setting.ID != opts.SettingID {
+			continue
+		}
+		dir := settingDirs[setting.ID]
+		if scanned[dir] {
+			continue
+		}
+		scanned[dir] = true
+
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		files, err := s.ListBackupFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if known[dir][file.Name] {
+				continue
+			}
+
+			orphan := &OrphanFile{
+				SettingID: setting.ID,
+				FileName:  file.Name,
+				Size:      file.Size,
+				ModTime:   file.CreatedAt,
+			}
+			switch {
+			case opts.AdoptOrphans:
+				if err := adoptOrphan(store, setting.ID, file); err != nil {
+					orphan.Error = err.Error()
+				} else {
+					orphan.Action = "adopted"
+				}
+			case opts.PurgeOrphans:
+				if err := s.DeleteBackupFile(dir, file.Name); err != nil {
+					orphan.Error = err.Error()
+				} else {
+					orphan.Action = "purged"
+				}
+			}
+			report.OrphanFiles = append(report.OrphanFiles, orphan)
+		}
+	}
+
+	return report, nil
+}
+
+// adoptOrphan 为备份文件补建一条已完成的备份记录，优先使用文件名中的时间戳作为创建时间
+func adoptOrphan(store storage.Store, settingID int, file BackupFile) error {
+	dbName, createdAt := parseBackupFileName(file.Name)
+	if createdAt == "" {
+		createdAt = file.CreatedAt
+	}
+
+	return store.SaveBackupRecord(&models.BackupRecord{
+		SettingID: settingID,
+		DBName:    dbName,
+		FileName:  file.Name,
+		CreatedAt: createdAt,
+		Status:    models.StatusCompleted,
+		Size:      file.Size,
+	})
+}
+
+// parseBackupFileName 从 "<数据库>_<20060102150405>.sql" 格式的文件名中解析数据库名和创建时间
+func parseBackupFileName(name string) (string, string) {
+	base := strings.TrimSuffix(name, ".sql")
+	i := strings.LastIndex(base, "_")
+	if i <= 0 {
+		return base, ""
+	}
+
+	t, err := time.ParseInLocation("20060102150405", base[i+1:], time.Local)
+	if err != nil {
+		return base, ""
+	}
+	return base[:i], t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mysql-backup/models"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// botErrorLimit 消息卡片中错误信息的最大长度（字符），企业微信的 markdown 消息最长 4096 字节
+const botErrorLimit = 500
+
+// botField 消息卡片中的一行
+type botField struct {
+	Label string
+	Value string
+}
+
+// eventTitles 各类事件在消息卡片中的标题
+var eventTitles = map[string]string{
+	models.EventBackupStarted:   "备份开始",
+	models.EventBackupSucceeded: "备份成功",
+	models.EventBackupFailed:    "备份失败",
+	models.EventRetentionPruned: "备份已按保留策略删除",
+	models.EventVerifyFailed:    "备份校验失败",
+	models.EventRPOBreached:     "备份超过恢复点目标",
+	models.EventRPORecovered:    "备份已恢复到恢复点目标内",
+	models.EventTest:            "测试消息",
+}
+
+func eventTitle(event *models.Event) string {
+	if title, ok := eventTitles[event.Type]; ok {
+		return "DataSafe " + title
+	}
+	return "DataSafe " + event.Type
+}
+
+// eventFailed 失败类事件在卡片中使用醒目的颜色
+func eventFailed(event *models.Event) bool {
+	return event.Type == models.EventBackupFailed || event.Type == models.EventVerifyFailed || event.Type == models.EventRPOBreached
+}
+
+// eventFields 从事件和备份记录中提取连接名称、数据库、耗时、大小和错误信息
+func eventFields(event *models.Event) []botField {
+	if event.Type == models.EventTest {
+		return []botField{{"内容", event.Message}, {"时间", event.Time}}
+	}
+
+	var fields []botField
+	add := func(label, value string) {
+		if value != "" {
+			fields = append(fields, botField{label, value})
+		}
+	}
+	add("连接名称", event.SettingName)
+	add("数据库", event.Database)
+	if event.ScheduleID != 0 {
+		add("定时任务", fmt.Sprintf("#%d", event.ScheduleID))
+	}
+	if record := event.Record; record != nil {
+		add("备份文件", record.FileName)
+		if record.Size > 0 {
+			add("大小", formatBytes(record.Size))
+		}
+		if record.Duration > 0 {
+			add("耗时", formatDuration(record.Duration))
+		}
+	}
+	if event.RPO != "" {
+		add("恢复点目标", event.RPO)
+		if event.LastSuccess == "" {
+			add("最近成功备份", "从未成功")
+		} else {
+			add("最近成功备份", event.LastSuccess)
+		}
+	}
+	add("时间", event.Time)
+	if event.Error != "" {
+		message := []rune(event.Error)
+		if len(message) > botErrorLimit {
+			message = append(message[:botErrorLimit], []rune("...")...)
+		}
+		add("错误", string(message))
+	}
+	return fields
+}
+
+// botMarkdown 钉钉和企业微信使用的 markdown 消息内容
+func botMarkdown(event *models.Event, channel string) string {
+	var b strings.Builder
+	title := eventTitle(event)
+	if channel == models.ChannelWeCom {
+		color := "info"
+		if eventFailed(event) {
+			color = "warning"
+		}
+		title = fmt.Sprintf(`<font color="%s">%s</font>`, color, title)
+	}
+	fmt.Fprintf(&b, "### %s\n", title)
+	for _, field := range eventFields(event) {
+		fmt.Fprintf(&b, "> **%s**：%s\n", field.Label, field.Value)
+		if channel == models.ChannelDingTalk {
+			b.WriteString("\n") // 钉钉的引用块需要空行分隔才能换行
+		}
+	}
+	return b.String()
+}
+
+// botPayload 生成群机器人的消息卡片，飞书开启加签时在请求体中带上签名
+func botPayload(hook *models.Webhook, event *models.Event, now time.Time) ([]byte, error) {
+	switch hook.Type {
+	case models.ChannelDingTalk:
+		return json.Marshal(map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]string{
+				"title": eventTitle(event),
+				"text":  botMarkdown(event, hook.Type),
+			},
+		})
+	case models.ChannelWeCom:
+		return json.Marshal(map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]string{
+				"content": botMarkdown(event, hook.Type),
+			},
+		})
+	case models.ChannelFeishu:
+		return json.Marshal(feishuCard(hook, event, now))
+	}
+	return nil, fmt.Errorf("不支持的通知渠道: %s", hook.Type)
+}
+
+// feishuCard 飞书消息卡片，每个字段占半行
+func feishuCard(hook *models.Webhook, event *models.Event, now time.Time) map[string]interface{} {
+	color := "blue"
+	switch {
+	case eventFailed(event):
+		color = "red"
+	case event.Type == models.EventBackupSucceeded:
+		color = "green"
+	}
+
+	var fields []map[string]interface{}
+	for _, field := range eventFields(event) {
+		fields = append(fields, map[string]interface{}{
+			"is_short": field.Label != "错误" && field.Label != "内容",
+			"text": map[string]string{
+				"tag":     "lark_md",
+				"content": fmt.Sprintf("**%s**\n%s", field.Label, field.Value),
+			},
+		})
+	}
+
+	payload := map[string]interface{}{
+		"msg_type": "interactive",
+		"card": map[string]interface{}{
+			"header": map[string]interface{}{
+				"title":    map[string]string{"tag": "plain_text", "content": eventTitle(event)},
+				"template": color,
+			},
+			"elements": []interface{}{
+				map[string]interface{}{"tag": "div", "fields": fields},
+			},
+		},
+	}
+	if hook.Secret != "" {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		payload["timestamp"] = timestamp
+		payload["sign"] = feishuSign(hook.Secret, timestamp)
+	}
+	return payload
+}
+
+// dingTalkSignedURL 钉钉加签：对 "timestamp\nsecret" 以 secret 为密钥计算 HMAC-SHA256，
+// 时间戳（毫秒）和签名作为查询参数
+func dingTalkSignedURL(rawURL, secret string, now time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + secret))
+
+	query := u.Query()
+	query.Set("timestamp", timestamp)
+	query.Set("sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// feishuSign 飞书加签：以 "timestamp\nsecret" 为密钥对空字符串计算 HMAC-SHA256，时间戳单位为秒
+func feishuSign(secret, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkBotResponse 群机器人出错时 HTTP 状态码仍为 200，需要检查响应中的错误码
+func checkBotResponse(channel string, body []byte) error {
+	var result struct {
+		ErrCode *int   `json:"errcode"` // 钉钉、企业微信
+		ErrMsg  string `json:"errmsg"`
+		Code    *int   `json:"code"` // 飞书
+		Msg     string `json:"msg"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("无法解析机器人响应: %v", err)
+	}
+	switch channel {
+	case models.ChannelDingTalk, models.ChannelWeCom:
+		if result.ErrCode != nil && *result.ErrCode != 0 {
+			return fmt.Errorf("机器人返回错误 %d: %s", *result.ErrCode, result.ErrMsg)
+		}
+	case models.ChannelFeishu:
+		if result.Code != nil && *result.Code != 0 {
+			return fmt.Errorf("机器人返回错误 %d: %s", *result.Code, result.Msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mysql-backup/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// botSecret 模拟的机器人在安全设置中配置的加签密钥
+const botSecret = "SECtest"
+
+// newFakeBot 按各平台的文档独立校验签名，校验失败时和真实机器人一样返回 200 和错误码
+func newFakeBot(t *testing.T, channel string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch channel {
+		case models.ChannelDingTalk:
+			timestamp := r.URL.Query().Get("timestamp")
+			mac := hmac.New(sha256.New, []byte(botSecret))
+			mac.Write([]byte(timestamp + "\n" + botSecret))
+			if !botTimestampFresh(timestamp, time.Millisecond) || r.URL.Query().Get("sign") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+				fmt.Fprint(w, `{"errcode":310000,"errmsg":"sign not match"}`)
+				return
+			}
+			if body["msgtype"] != "markdown" {
+				fmt.Fprint(w, `{"errcode":40035,"errmsg":"missing msgtype"}`)
+				return
+			}
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+		case models.ChannelFeishu:
+			timestamp, _ := body["timestamp"].(string)
+			mac := hmac.New(sha256.New, []byte(timestamp+"\n"+botSecret))
+			if !botTimestampFresh(timestamp, time.Second) || body["sign"] != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+				fmt.Fprint(w, `{"code":19021,"msg":"sign match fail or timestamp is not within one hour from current time"}`)
+				return
+			}
+			if body["msg_type"] != "interactive" {
+				fmt.Fprint(w, `{"code":19002,"msg":"params error"}`)
+				return
+			}
+			fmt.Fprint(w, `{"code":0,"msg":"success"}`)
+		case models.ChannelWeCom:
+			fmt.Fprint(w, `{"errcode":93000,"errmsg":"invalid webhook url"}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// botTimestampFresh 时间戳与当前时间相差不超过一分钟
+func botTimestampFresh(timestamp string, unit time.Duration) bool {
+	value, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	diff := time.Since(time.Unix(0, value*int64(unit)))
+	return diff > -time.Minute && diff < time.Minute
+}
+
+func TestChatbotDelivery(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		secret  string
+		wantErr string // 为空表示投递成功
+	}{
+		{name: "dingtalk signed", channel: models.ChannelDingTalk, secret: botSecret},
+		{name: "dingtalk wrong secret", channel: models.ChannelDingTalk, secret: "SECother", wantErr: "机器人返回错误 310000"},
+		{name: "dingtalk unsigned", channel: models.ChannelDingTalk, wantErr: "机器人返回错误 310000"},
+		{name: "feishu signed", channel: models.ChannelFeishu, secret: botSecret},
+		{name: "feishu wrong secret", channel: models.ChannelFeishu, secret: "SECother", wantErr: "机器人返回错误 19021"},
+		{name: "wecom error code", channel: models.ChannelWeCom, wantErr: "机器人返回错误 93000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := newFakeBot(t, tt.channel)
+			service := NewWebhookService(newTestStore(t), NewEventBus())
+			hook := &models.Webhook{ID: 1, Name: "bot", Type: tt.channel, URL: bot.URL + "/robot/send?access_token=x", Secret: tt.secret}
+
+			delivery := service.Test(hook)
+			if delivery.StatusCode != http.StatusOK {
+				t.Fatalf("status code = %d", delivery.StatusCode)
+			}
+			if tt.wantErr == "" {
+				if delivery.Status != models.DeliverySucceeded {
+					t.Fatalf("delivery failed: %s (%s)", delivery.Error, delivery.Response)
+				}
+				return
+			}
+			if delivery.Status != models.DeliveryFailed || !strings.Contains(delivery.Error, tt.wantErr) {
+				t.Fatalf("delivery = %s %q, want error %q", delivery.Status, delivery.Error, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDingTalkSignedURLKeepsQuery(t *testing.T) {
+	now := time.UnixMilli(1700000000123)
+	signed, err := dingTalkSignedURL("https://oapi.dingtalk.com/robot/send?access_token=abc", botSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(signed, "access_token=abc") || !strings.Contains(signed, "timestamp=1700000000123") {
+		t.Fatalf("signed url = %s", signed)
+	}
+}
+
+func TestCheckBotResponse(t *testing.T) {
+	tests := []struct {
+		channel string
+		body    string
+		wantErr bool
+	}{
+		{models.ChannelDingTalk, `{"errcode":0,"errmsg":"ok"}`, false},
+		{models.ChannelDingTalk, `{"errcode":130101,"errmsg":"send too fast"}`, true},
+		{models.ChannelWeCom, `{"errcode":45009,"errmsg":"api freq out of limit"}`, true},
+		{models.ChannelFeishu, `{"code":0,"msg":"success","StatusCode":0}`, false},
+		{models.ChannelFeishu, `{"code":9499,"msg":"Bad Request"}`, true},
+		{models.ChannelFeishu, `not json`, true},
+	}
+	for _, tt := range tests {
+		err := checkBotResponse(tt.channel, []byte(tt.body))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %s: err = %v", tt.channel, tt.body, err)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ConfigBundleVersion 当前导出文件的格式版本，格式不兼容时递增
+const ConfigBundleVersion = 1
+
+// 导出文件中敏感字段的处理方式
+const (
+	BundleSecretsOmitted   = "omitted"   // 不包含密码和私钥
+	BundleSecretsEncrypted = "encrypted" // 使用口令加密
+)
+
+// 导入模式
+const (
+	ImportModeMerge   = "merge"   // 同名配置以导出文件为准，其他配置保留
+	ImportModeReplace = "replace" // 删除导出文件中没有的配置，定时任务以导出文件为准
+)
+
+// ConfigBundle 导出的 DataSafe 配置，包含数据库配置、定时任务和可选的备份记录
+type ConfigBundle struct {
+	Version    int                     `json:"version"`
+	ExportedAt string                  `json:"exportedAt"`
+	Secrets    string                  `json:"secrets"`
+	Encryption *BundleEncryption       `json:"encryption,omitempty"`
+	Settings   []*models.DBSettings    `json:"settings"`
+	Schedules  []*models.ScheduledTask `json:"schedules"`
+	Backups    []*models.BackupRecord  `json:"backups,omitempty"`
+}
+
+// BundleEncryption 导出文件中敏感字段的加密参数，密钥由口令通过 scrypt 派生
+type BundleEncryption struct {
+	KDF  string `json:"kdf"`
+	Salt string `json:"salt"` // base64
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// ExportOptions 导出选项
+type ExportOptions struct {
+	IncludeBackups bool   // 包含备份记录
+	Passphrase     string // 为空时不导出密码和私钥
+}
+
+// ImportOptions 导入选项
+type ImportOptions struct {
+	Mode       string // merge 或 replace，默认 merge
+	Passphrase string // 导出文件中的敏感字段已加密时必须提供
+}
+
+// ImportReport 导入结果，SettingIDMap 为导出文件中的配置 ID 到导入后 ID 的映射
+type ImportReport struct {
+	Mode             string      `json:"mode"`
+	SettingsCreated  int         `json:"settingsCreated"`
+	SettingsUpdated  int         `json:"settingsUpdated"`
+	SettingsDeleted  int         `json:"settingsDeleted"`
+	SchedulesCreated int         `json:"schedulesCreated"`
+	SchedulesDeleted int         `json:"schedulesDeleted"`
+	BackupsImported  int         `json:"backupsImported"`
+	BackupsSkipped   int         `json:"backupsSkipped"`
+	SettingIDMap     map[int]int `json:"settingIdMap"`
+	Warnings         []string    `json:"warnings,omitempty"`
+}
+
+// BundleService 导出和导入 DataSafe 配置，用于迁移到新的主机
+type BundleService struct {
+	store    storage.Store
+	backup   *BackupService
+	schedule *ScheduleService
+}
+
+func NewBundleService(store storage.Store, backup *BackupService, schedule *ScheduleService) *BundleService {
+	return &BundleService{store: store, backup: backup, schedule: schedule}
+}
+
+// Export 导出所有配置和定时任务
+func (s *BundleService) Export(opts ExportOptions) (*ConfigBundle, error) {
+	settings, err := s.store.GetAllSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取配置失败: %v", err)
+	}
+	schedules, err := s.store.GetAllSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("获取定时任务失败: %v", err)
+	}
+
+	bundle := &ConfigBundle{
+		Version:    ConfigBundleVersion,
+		ExportedAt: time.Now().Format("2006-01-02 15:04:05"),
+		Secrets:    BundleSecretsOmitted,
+		Settings:   make([]*models.DBSettings, 0, len(settings)),
+		Schedules:  schedules,
+	}
+	if bundle.Schedules == nil {
+		bundle.Schedules = []*models.ScheduledTask{}
+	}
+
+	var cipher *storage.SecretCipher
+	if opts.Passphrase != "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("生成盐值失败: %v", err)
+		}
+		bundle.Secrets = BundleSecretsEncrypted
+		bundle.Encryption = &BundleEncryption{
+			KDF:  "scrypt",
+			Salt: base64.StdEncoding.EncodeToString(salt),
+			N:    storage.ScryptN,
+			R:    storage.ScryptR,
+			P:    storage.ScryptP,
+		}
+		if cipher, err = bundle.Encryption.cipher(opts.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range settings {
+		setting := &settings[i]
+		if cipher != nil {
+			if setting, err = storage.EncryptSettingsSecrets(setting, cipher); err != nil {
+				return nil, fmt.Errorf("加密配置 %s 失败: %v", settings[i].Name, err)
+			}
+		} else {
+			storage.ClearSettingsSecrets(setting)
+		}
+		bundle.Settings = append(bundle.Settings, setting)
+	}
+
+	if opts.IncludeBackups {
+		if bundle.Backups, err = s.store.GetBackupRecords(); err != nil {
+			return nil, fmt.Errorf("获取备份记录失败: %v", err)
+		}
+	}
+	return bundle, nil
+}
+
+func (e *BundleEncryption) cipher(passphrase string) (*storage.SecretCipher, error) {
+	if e.KDF != "scrypt" {
+		return nil, fmt.Errorf("不支持的密钥派生算法 %q", e.KDF)
+	}
+	salt, err := base64.StdEncoding.DecodeString(e.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("无效的盐值: %v", err)
+	}
+	cipher, err := storage.NewPassphraseCipher(passphrase, salt, e.N, e.R, e.P)
+	if err != nil {
+		return nil, fmt.Errorf("派生密钥失败: %v", err)
+	}
+	return cipher, nil
+}
+
+// validate 校验导出文件并解密敏感字段，配置按界面保存时相同的规则校验，校验失败时不做任何修改。
+// existing 为按名称索引的已有配置，导出文件不含敏感字段时用于补全密码后再校验
+func (b *ConfigBundle) validate(backup *BackupService, passphrase string, existing map[string]*models.DBSettings) error {
+	if b.Version < 1 || b.Version > ConfigBundleVersion {
+		return fmt.Errorf("不支持的导出文件版本 %d，当前版本支持 1-%d", b.Version, ConfigBundleVersion)
+	}
+
+	var cipher *storage.SecretCipher
+	switch b.Secrets {
+	case BundleSecretsOmitted, "":
+	case BundleSecretsEncrypted:
+		if passphrase == "" {
+			return fmt.Errorf("导出文件中的密码已加密，请提供口令")
+		}
+		if b.Encryption == nil {
+			return fmt.Errorf("导出文件缺少加密参数")
+		}
+		var err error
+		if cipher, err = b.Encryption.cipher(passphrase); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("无效的 secrets 字段 %q", b.Secrets)
+	}
+
+	ids := make(map[int]bool)
+	names := make(map[string]bool)
+	for _, setting := range b.Settings {
+		if setting == nil || setting.Name == "" {
+			return fmt.Errorf("导出文件中的配置缺少名称")
+		}
+		if ids[setting.ID] || names[setting.Name] {
+			return fmt.Errorf("导出文件中的配置 %s 重复", setting.Name)
+		}
+		ids[setting.ID], names[setting.Name] = true, true
+		if cipher != nil {
+			if err := storage.DecryptSettingsSecrets(setting, cipher); err != nil {
+				return fmt.Errorf("解密配置 %s 失败，口令错误或导出文件已损坏", setting.Name)
+			}
+		}
+		if err := b.validateSettings(backup, setting, existing[setting.Name]); err != nil {
+			return fmt.Errorf("配置 %s: %v", setting.Name, err)
+		}
+	}
+	for _, task := range b.Schedules {
+		if task == nil || !ids[task.SettingID] {
+			return fmt.Errorf("定时任务引用了导出文件中不存在的配置")
+		}
+		if _, err := cron.ParseStandard(task.Schedule); err != nil {
+			return fmt.Errorf("定时任务 %d 的 Cron 表达式无效: %v", task.ID, err)
+		}
+		if err := ValidateRPO(task.RPO); err != nil {
+			return fmt.Errorf("定时任务 %d: %v", task.ID, err)
+		}
+	}
+	for _, record := range b.Backups {
+		if record == nil || record.FileName == "" {
+			return fmt.Errorf("导出文件中的备份记录缺少文件名")
+		}
+	}
+	return nil
+}
+
+// validateSettings 校验导入的配置，未指定备份目录时使用默认目录
+func (b *ConfigBundle) validateSettings(backup *BackupService, setting, current *models.DBSettings) error {
+	// 由声明式配置文件管理的配置导入时会跳过
+	if current != nil && current.Managed {
+		return nil
+	}
+	if setting.BackupDir == "" {
+		setting.BackupDir = backup.DefaultBackupDir(setting)
+	}
+	if setting.PasswordRef != "" {
+		if err := ValidateSecretRef(setting.PasswordRef); err != nil {
+			return err
+		}
+	}
+
+	checked := setting
+	if current != nil && b.Secrets != BundleSecretsEncrypted {
+		checked = storage.CopySettings(setting)
+		keepSecrets(checked, current)
+	}
+	if err := backup.ValidateSettings(checked); err != nil {
+		return err
+	}
+	if err := ValidateTLSSettings(checked.TLS); err != nil {
+		return err
+	}
+	return ValidateSSHTunnel(checked.SSH)
+}
+
+// Import 导入配置。配置按名称与已有配置对应，定时任务和备份记录按新的配置 ID 重新编号，
+// 导入完成后重新加载定时任务。由声明式配置文件管理的配置和定时任务不会被修改或删除
+func (s *BundleService) Import(bundle *ConfigBundle, opts ImportOptions) (*ImportReport, error) {
+	if opts.Mode == "" {
+		opts.Mode = ImportModeMerge
+	}
+	if opts.Mode != ImportModeMerge && opts.Mode != ImportModeReplace {
+		return nil, fmt.Errorf("无效的导入模式 %q，可选 merge 或 replace", opts.Mode)
+	}
+
+	existing, err := s.store.GetAllSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取配置失败: %v", err)
+	}
+	tasks, err := s.store.GetAllSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("获取定时任务失败: %v", err)
+	}
+	byName := make(map[string]*models.DBSettings)
+	for i := range existing {
+		byName[existing[i].Name] = &existing[i]
+	}
+	if err := bundle.validate(s.backup, opts.Passphrase, byName); err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{Mode: opts.Mode, SettingIDMap: make(map[int]int)}
+	// 导入完成后按存储重新注册定时任务，中途失败时也保持内存与存储一致
+	defer func() {
+		if err := s.schedule.Reload(); err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("重新加载定时任务失败: %v", err))
+		}
+	}()
+
+	// 替换模式下先删除导出文件中没有的配置，以及导入配置上的定时任务
+	if opts.Mode == ImportModeReplace {
+		imported := make(map[string]bool)
+		for _, setting := range bundle.Settings {
+			imported[setting.Name] = true
+		}
+		// 被删除和被导入覆盖的配置，其上的未受管定时任务以导出文件为准
+		replaced := make(map[int]bool)
+		for i := range existing {
+			setting := &existing[i]
+			if setting.Managed {
+				continue
+			}
+			replaced[setting.ID] = true
+			if imported[setting.Name] {
+				continue
+			}
+			if err := s.store.DeleteSettings(setting.ID); err != nil {
+				return report, fmt.Errorf("删除配置 %s 失败: %v", setting.Name, err)
+			}
+			delete(byName, setting.Name)
+			report.SettingsDeleted++
+		}
+		remaining := tasks[:0]
+		for _, task := range tasks {
+			if !task.Managed && replaced[task.SettingID] {
+				if err := s.store.DeleteSchedule(task.ID); err != nil {
+					return report, fmt.Errorf("删除定时任务 %d 失败: %v", task.ID, err)
+				}
+				report.SchedulesDeleted++
+				continue
+			}
+			remaining = append(remaining, task)
+		}
+		tasks = remaining
+	}
+
+	for _, setting := range bundle.Settings {
+		bundleID := setting.ID
+		imported := storage.CopySettings(setting)
+		imported.Managed = false
+		if current := byName[setting.Name]; current != nil {
+			if current.Managed {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("配置 %s 由声明式配置文件管理，已跳过", setting.Name))
+				continue
+			}
+			imported.ID = current.ID
+			if bundle.Secrets != BundleSecretsEncrypted {
+				keepSecrets(imported, current)
+			}
+			report.SettingsUpdated++
+		} else {
+			imported.ID = 0
+			report.SettingsCreated++
+		}
+		if err := s.store.SaveSettings(imported); err != nil {
+			return report, fmt.Errorf("保存配置 %s 失败: %v", setting.Name, err)
+		}
+		report.SettingIDMap[bundleID] = imported.ID
+	}
+
+	type taskKey struct {
+		settingID          int
+		database, schedule string
+	}
+	seen := make(map[taskKey]bool)
+	for _, task := range tasks {
+		seen[taskKey{task.SettingID, task.Database, task.Schedule}] = true
+	}
+	for _, task := range bundle.Schedules {
+		settingID, ok := report.SettingIDMap[task.SettingID]
+		if !ok {
+			continue // 所属配置被跳过
+		}
+		key := taskKey{settingID, task.Database, task.Schedule}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		createdAt := task.CreatedAt
+		if createdAt == "" {
+			createdAt = time.Now().Format("2006-01-02 15:04:05")
+		}
+		if err := s.store.SaveSchedule(&models.ScheduledTask{SettingID: settingID, Database: task.Database, Schedule: task.Schedule, RPO: task.RPO, CreatedAt: createdAt}); err != nil {
+			return report, fmt.Errorf("保存定时任务失败: %v", err)
+		}
+		report.SchedulesCreated++
+	}
+
+	if len(bundle.Backups) > 0 {
+		if err := s.importBackups(bundle.Backups, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// importBackups 导入备份记录，已存在相同文件的记录跳过
+func (s *BundleService) importBackups(records []*models.BackupRecord, report *ImportReport) error {
+	existing, err := s.store.GetBackupRecords()
+	if err != nil {
+		return fmt.Errorf("获取备份记录失败: %v", err)
+	}
+	type recordKey struct {
+		settingID int
+		fileName  string
+	}
+	seen := make(map[recordKey]bool)
+	for _, record := range existing {
+		seen[recordKey{record.SettingID, record.FileName}] = true
+	}
+
+	for _, record := range records {
+		settingID, ok := report.SettingIDMap[record.SettingID]
+		key := recordKey{settingID, record.FileName}
+		if !ok || seen[key] {
+			report.BackupsSkipped++
+			continue
+		}
+		seen[key] = true
+
+		imported := *record
+		imported.ID = 0
+		imported.SettingID = settingID
+		imported.ScheduleID = 0 // 定时任务导入后 ID 会变化，不再关联
+		imported.HasLog = false // 任务日志不在导出文件中
+		// 导入时正在进行的备份不会再完成
+		if imported.Status == models.StatusInProgress {
+			imported.Status = models.StatusFailed
+			imported.Error = "导入时备份未完成"
+		}
+		if err := s.store.SaveBackupRecord(&imported); err != nil {
+			return fmt.Errorf("保存备份记录失败: %v", err)
+		}
+		report.BackupsImported++
+	}
+	return nil
+}
+
+// keepSecrets 导出文件不含敏感字段时沿用已有配置中的密码和私钥
+func keepSecrets(imported, current *models.DBSettings) {
+	if imported.Password == "" && imported.PasswordRef == "" {
+		imported.Password = current.Password
+	}
+	if imported.TLS != nil && current.TLS != nil && imported.TLS.ClientKey == "" {
+		imported.TLS.ClientKey = current.TLS.ClientKey
+	}
+	if imported.SSH != nil && current.SSH != nil {
+		if imported.SSH.Password == "" {
+			imported.SSH.Password = current.SSH.Password
+		}
+		if imported.SSH.PrivateKey == "" {
+			imported.SSH.PrivateKey = current.SSH.PrivateKey
+			imported.SSH.Passphrase = current.SSH.Passphrase
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"encoding/json"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestBundleService(t *testing.T, store storage.Store) *BundleService {
+	t.Helper()
+	backup, schedule := newTestScheduleService(t, store)
+	return NewBundleService(store, backup, schedule)
+}
+
+func saveTestSetting(t *testing.T, store storage.Store, setting *models.DBSettings, schedules ...string) *models.DBSettings {
+	t.Helper()
+	if setting.Host == "" {
+		setting.Host, setting.Port, setting.User, setting.BackupDir = "10.0.0.5", 3306, "backup", "/var/backups/"+setting.Name
+	}
+	if err := store.SaveSettings(setting); err != nil {
+		t.Fatal(err)
+	}
+	for _, schedule := range schedules {
+		if err := store.SaveSchedule(&models.ScheduledTask{SettingID: setting.ID, Database: "shop", Schedule: schedule}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return setting
+}
+
+func settingsByName(t *testing.T, store storage.Store) map[string]models.DBSettings {
+	t.Helper()
+	settings, err := store.GetAllSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]models.DBSettings)
+	for _, setting := range settings {
+		byName[setting.Name] = setting
+	}
+	return byName
+}
+
+// scheduleList 返回定时任务，格式为 "配置 ID:Cron 表达式"
+func scheduleList(t *testing.T, store storage.Store) []string {
+	t.Helper()
+	tasks, err := store.GetAllSchedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	for _, task := range tasks {
+		list = append(list, strconv.Itoa(task.SettingID)+":"+task.Schedule)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// roundTrip 模拟下载导出文件后再上传
+func roundTrip(t *testing.T, bundle *ConfigBundle) *ConfigBundle {
+	t.Helper()
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ConfigBundle
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return &decoded
+}
+
+func TestBundleEncryptedRoundTrip(t *testing.T) {
+	source := newTestStore(t)
+	prod := saveTestSetting(t, source, &models.DBSettings{Name: "prod", Password: "db-pass",
+		SSH: &models.SSHTunnel{Host: "bastion", Port: 22, User: "tunnel", Password: "ssh-pass"}}, "0 2 * * *")
+	if err := source.SaveBackupRecord(&models.BackupRecord{SettingID: prod.ID, DBName: "shop", FileName: "shop_1.sql", Status: models.StatusCompleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := newTestBundleService(t, source).Export(ExportOptions{IncludeBackups: true, Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(bundle)
+	if strings.Contains(string(data), "db-pass") || strings.Contains(string(data), "ssh-pass") {
+		t.Fatal("export contains plaintext secrets")
+	}
+
+	// 目标实例已有其他配置，导入的配置会分配新的 ID
+	target := newTestStore(t)
+	saveTestSetting(t, target, &models.DBSettings{Name: "other"})
+	service := newTestBundleService(t, target)
+
+	if _, err := service.Import(roundTrip(t, bundle), ImportOptions{Passphrase: "wrong"}); err == nil || !strings.Contains(err.Error(), "口令错误") {
+		t.Fatalf("err = %v, want wrong passphrase", err)
+	}
+	if _, err := service.Import(roundTrip(t, bundle), ImportOptions{}); err == nil || !strings.Contains(err.Error(), "请提供口令") {
+		t.Fatalf("err = %v, want passphrase required", err)
+	}
+	if got := settingsByName(t, target); len(got) != 1 {
+		t.Fatalf("failed import wrote settings: %v", got)
+	}
+
+	report, err := service.Import(roundTrip(t, bundle), ImportOptions{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SettingsCreated != 1 || report.SchedulesCreated != 1 || report.BackupsImported != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	imported := settingsByName(t, target)["prod"]
+	if report.SettingIDMap[prod.ID] != imported.ID || imported.ID == prod.ID {
+		t.Fatalf("id map = %v, imported id = %d", report.SettingIDMap, imported.ID)
+	}
+	if imported.Password != "db-pass" || imported.SSH == nil || imported.SSH.Password != "ssh-pass" {
+		t.Fatalf("imported secrets = %q, ssh = %+v", imported.Password, imported.SSH)
+	}
+	if got := scheduleList(t, target); len(got) != 1 || got[0] != strconv.Itoa(imported.ID)+":0 2 * * *" {
+		t.Fatalf("schedules = %v", got)
+	}
+	records, err := target.GetBackupRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].SettingID != imported.ID {
+		t.Fatalf("backup records = %+v", records)
+	}
+}
+
+func TestBundleImportModes(t *testing.T) {
+	tests := []struct {
+		mode      string
+		settings  []string
+		schedules func(prod, local int) []string
+	}{
+		{
+			mode:     ImportModeMerge,
+			settings: []string{"local", "prod"},
+			schedules: func(prod, local int) []string {
+				return []string{strconv.Itoa(local) + ":0 4 * * *", strconv.Itoa(prod) + ":0 1 * * *", strconv.Itoa(prod) + ":0 3 * * *"}
+			},
+		},
+		{
+			mode:     ImportModeReplace,
+			settings: []string{"prod"},
+			schedules: func(prod, local int) []string {
+				return []string{strconv.Itoa(prod) + ":0 3 * * *"}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			target := newTestStore(t)
+			prod := saveTestSetting(t, target, &models.DBSettings{Name: "prod", Password: "old-pass"}, "0 1 * * *")
+			local := saveTestSetting(t, target, &models.DBSettings{Name: "local"}, "0 4 * * *")
+
+			// 不含密码的导出文件，prod 在导出实例中的 ID 为 7
+			bundle := &ConfigBundle{
+				Version: ConfigBundleVersion,
+				Secrets: BundleSecretsOmitted,
+				Settings: []*models.DBSettings{
+					{ID: 7, Name: "prod", Host: "10.0.0.9", Port: 3306, User: "backup", BackupDir: "/var/backups/prod"},
+				},
+				Schedules: []*models.ScheduledTask{{ID: 1, SettingID: 7, Database: "shop", Schedule: "0 3 * * *"}},
+			}
+			report, err := newTestBundleService(t, target).Import(roundTrip(t, bundle), ImportOptions{Mode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.SettingsUpdated != 1 || report.SettingIDMap[7] != prod.ID {
+				t.Fatalf("report = %+v", report)
+			}
+
+			settings := settingsByName(t, target)
+			var names []string
+			for name := range settings {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(tt.settings, ",") {
+				t.Fatalf("settings = %v, want %v", names, tt.settings)
+			}
+			if got := settings["prod"]; got.Host != "10.0.0.9" || got.Password != "old-pass" {
+				t.Fatalf("prod = %+v, want updated host and kept password", got)
+			}
+			want := tt.schedules(prod.ID, local.ID)
+			sort.Strings(want)
+			if got := scheduleList(t, target); strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Fatalf("schedules = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBundleImportValidation(t *testing.T) {
+	valid := func() *models.DBSettings {
+		return &models.DBSettings{ID: 1, Name: "new", Host: "10.0.0.9", Port: 3306, User: "backup"}
+	}
+	tests := []struct {
+		name    string
+		setting func(*models.DBSettings)
+		wantErr string // 为空表示导入成功
+	}{
+		{name: "valid", setting: func(s *models.DBSettings) {}},
+		{name: "missing user", setting: func(s *models.DBSettings) { s.User = "" }, wantErr: "用户名不能为空"},
+		{name: "invalid port", setting: func(s *models.DBSettings) { s.Port = 70000 }, wantErr: "无效的端口号"},
+		{name: "invalid charset", setting: func(s *models.DBSettings) { s.Charset = "utf8mb4;DROP" }, wantErr: "无效的字符集"},
+		{name: "unsafe driver param", setting: func(s *models.DBSettings) { s.Params = map[string]string{"allowAllFiles": "true"} }, wantErr: "allowAllFiles"},
+		{name: "invalid tls", setting: func(s *models.DBSettings) { s.TLS = &models.TLSSettings{Mode: models.TLSModeVerifyCA} }, wantErr: "CA 证书"},
+		{name: "ssh without credentials", setting: func(s *models.DBSettings) { s.SSH = &models.SSHTunnel{Host: "bastion", User: "tunnel"} }, wantErr: "跳板机需要配置"},
+		{name: "master key reference", setting: func(s *models.DBSettings) { s.PasswordRef = "env:DATASAFE_MASTER_KEY" }, wantErr: "不允许引用"},
+		{
+			name: "existing ssh keeps saved password",
+			setting: func(s *models.DBSettings) {
+				s.Name = "existing"
+				s.SSH = &models.SSHTunnel{Host: "bastion", User: "tunnel"}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestStore(t)
+			saveTestSetting(t, target, &models.DBSettings{Name: "existing",
+				SSH: &models.SSHTunnel{Host: "bastion", User: "tunnel", Password: "ssh-pass"}}, "0 1 * * *")
+
+			setting := valid()
+			tt.setting(setting)
+			// 有效的配置排在前面，校验失败时也不能被写入
+			bundle := &ConfigBundle{
+				Version:   ConfigBundleVersion,
+				Settings:  []*models.DBSettings{{ID: 9, Name: "first", Host: "10.0.0.8", Port: 3306, User: "backup"}, setting},
+				Schedules: []*models.ScheduledTask{{SettingID: 9, Database: "shop", Schedule: "0 2 * * *"}},
+			}
+			_, err := newTestBundleService(t, target).Import(roundTrip(t, bundle), ImportOptions{Mode: ImportModeReplace})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				imported := settingsByName(t, target)[setting.Name]
+				if setting.SSH != nil && (imported.SSH == nil || imported.SSH.Password != "ssh-pass") {
+					t.Fatalf("%s ssh = %+v, want saved password", setting.Name, imported.SSH)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if settings := settingsByName(t, target); len(settings) != 1 || settings["existing"].ID == 0 {
+				t.Fatalf("invalid bundle changed settings: %v", settings)
+			}
+			if got := scheduleList(t, target); len(got) != 1 {
+				t.Fatalf("invalid bundle changed schedules: %v", got)
+			}
+		})
+	}
+}
+
+func TestBundleImportDefaultsBackupDir(t *testing.T) {
+	target := newTestStore(t)
+	bundle := &ConfigBundle{
+		Version:  ConfigBundleVersion,
+		Settings: []*models.DBSettings{{ID: 1, Name: "prod", Host: "10.0.0.9", Port: 3306, User: "backup"}},
+	}
+	if _, err := newTestBundleService(t, target).Import(bundle, ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if dir := settingsByName(t, target)["prod"].BackupDir; dir == "" {
+		t.Fatal("backup dir not set")
+	}
+}
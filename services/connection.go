@@ -0,0 +1,203 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"mysql-backup/models"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// defaultConnectTimeout 未配置连接超时时使用的默认值
+const defaultConnectTimeout = 10 * time.Second
+
+// reservedParams 由连接选项设置的参数，不能在自定义参数中重复设置
+var reservedParams = map[string]string{
+	"tls":               "TLS 配置",
+	"timeout":           "连接超时",
+	"readTimeout":       "读超时",
+	"writeTimeout":      "写超时",
+	"charset":           "字符集",
+	"collation":         "排序规则",
+	"net_write_timeout": "net_write_timeout",
+}
+
+// driverParams 允许在自定义参数中设置的驱动参数。allowAllFiles、allowCleartextPasswords、
+// allowOldPasswords 等会降低安全性的参数不在其中
+var driverParams = map[string]bool{
+	"parseTime":            true,
+	"loc":                  true,
+	"timeTruncate":         true,
+	"maxAllowedPacket":     true,
+	"interpolateParams":    true,
+	"clientFoundRows":      true,
+	"columnsWithAlias":     true,
+	"rejectReadOnly":       true,
+	"checkConnLiveness":    true,
+	"connectionAttributes": true,
+}
+
+// 驱动参数以外的自定义参数作为会话变量，连接时原样拼入 SET 语句，
+// 因此变量名只允许小写字母、数字和下划线，值只允许简单的数字、关键字或不含引号和反斜杠的单引号字符串
+var (
+	sessionVarNamePattern  = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	sessionVarValuePattern = regexp.MustCompile(`^([A-Za-z0-9_.+-]+|'[^'\\]*')$`)
+)
+
+// charsetPattern 字符集和排序规则的名称。驱动连接时把两者直接拼入 SET NAMES 语句，不能包含其他字符
+var charsetPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ValidateConnectionOptions 校验 Unix socket、超时、字符集和自定义参数等连接选项
+func ValidateConnectionOptions(setting *models.DBSettings) error {
+	if setting.Socket != "" {
+		if !strings.HasPrefix(setting.Socket, "/") {
+			return fmt.Errorf("Unix socket 必须使用绝对路径")
+		}
+		if setting.SSH != nil {
+			return fmt.Errorf("Unix socket 不能与跳板机同时使用")
+		}
+	}
+	if setting.ConnectTimeout < 0 || setting.ReadTimeout < 0 || setting.WriteTimeout < 0 || setting.NetWriteTimeout < 0 {
+		return fmt.Errorf("超时时间不能为负数")
+	}
+	if setting.Charset != "" && !charsetPattern.MatchString(setting.Charset) {
+		return fmt.Errorf("无效的字符集: %s", setting.Charset)
+	}
+	if setting.Collation != "" && !charsetPattern.MatchString(setting.Collation) {
+		return fmt.Errorf("无效的排序规则: %s", setting.Collation)
+	}
+	for key := range setting.Params {
+		if key == "" {
+			return fmt.Errorf("连接参数名不能为空")
+		}
+		if name, ok := reservedParams[key]; ok {
+			return fmt.Errorf("连接参数 %s 请通过对应的连接选项设置: %s", key, name)
+		}
+		if driverParams[key] {
+			continue
+		}
+		if !sessionVarNamePattern.MatchString(key) {
+			return fmt.Errorf("不支持的连接参数 %s", key)
+		}
+		if !sessionVarValuePattern.MatchString(setting.Params[key]) {
+			return fmt.Errorf("会话变量 %s 的值无效，字符串需要用单引号括起来且不能包含引号和反斜杠", key)
+		}
+	}
+	if _, err := parseExtraParams(setting.Params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseExtraParams 由驱动解析自定义参数，驱动支持的参数（如 parseTime）写入对应字段，
+// 其他参数作为会话变量在连接时设置
+func parseExtraParams(params map[string]string) (*mysql.Config, error) {
+	if len(params) == 0 {
+		return mysql.NewConfig(), nil
+	}
+
+	// 按参数名排序，保证相同配置得到相同结果
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(params[key]))
+	}
+
+	cfg, err := mysql.ParseDSN("/?" + strings.Join(pairs, "&"))
+	if err != nil {
+		return nil, fmt.Errorf("无效的连接参数: %v", err)
+	}
+	return cfg, nil
+}
+
+// buildMySQLConfig 根据数据库配置生成驱动配置，在连接时读取外部密码引用并注册 TLS 配置和 SSH 跳板机。
+// 使用 mysql.Config 而不是拼接 DSN，密码中的特殊字符和 IPv6 地址不需要转义。
+// saved 表示配置来自存储；测试连接时传入的是尚未保存的配置，不能影响已保存配置正在使用的 SSH 连接
+func buildMySQLConfig(setting *models.DBSettings, dbName string, saved bool) (*mysql.Config, error) {
+	if err := ValidateConnectionOptions(setting); err != nil {
+		return nil, err
+	}
+	cfg, err := parseExtraParams(setting.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := ResolvePassword(setting)
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库密码失败: %v", err)
+	}
+
+	tlsName, err := registerTLS(setting)
+	if err != nil {
+		return nil, fmt.Errorf("TLS 配置错误: %v", err)
+	}
+
+	cfg.User = setting.User
+	cfg.Passwd = password
+	cfg.DBName = dbName
+	cfg.TLSConfig = tlsName
+
+	if setting.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = setting.Socket
+	} else {
+		network, err := registerSSHTunnel(setting, saved)
+		if err != nil {
+			return nil, fmt.Errorf("跳板机配置错误: %v", err)
+		}
+		cfg.Net = network
+		// 兼容填写为 [::1] 形式的 IPv6 地址
+		host := strings.TrimSuffix(strings.TrimPrefix(setting.Host, "["), "]")
+		cfg.Addr = net.JoinHostPort(host, strconv.Itoa(setting.Port))
+	}
+
+	cfg.Timeout = defaultConnectTimeout
+	if setting.ConnectTimeout > 0 {
+		cfg.Timeout = time.Duration(setting.ConnectTimeout) * time.Second
+	}
+	cfg.ReadTimeout = time.Duration(setting.ReadTimeout) * time.Second
+	cfg.WriteTimeout = time.Duration(setting.WriteTimeout) * time.Second
+
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	if setting.Charset != "" {
+		cfg.Params["charset"] = setting.Charset
+	}
+	if setting.Collation != "" {
+		cfg.Collation = setting.Collation
+	}
+	if setting.NetWriteTimeout > 0 {
+		cfg.Params["net_write_timeout"] = strconv.Itoa(setting.NetWriteTimeout)
+	}
+
+	return cfg, nil
+}
+
+// openDB 按已保存的数据库配置打开连接池，所有连接数据库的地方都应使用该方法
+func openDB(setting *models.DBSettings, dbName string) (*sql.DB, error) {
+	cfg, err := buildMySQLConfig(setting, dbName, true)
+	if err != nil {
+		return nil, err
+	}
+	return connectDB(cfg)
+}
+
+func connectDB(cfg *mysql.Config) (*sql.DB, error) {
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("初始化连接失败: %v", err)
+	}
+	return sql.OpenDB(connector), nil
+}
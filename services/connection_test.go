@@ -0,0 +1,33 @@
+package services
+
+import (
+	"mysql-backup/models"
+	"testing"
+)
+
+func TestValidateConnectionOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting models.DBSettings
+		wantErr bool
+	}{
+		{name: "charset", setting: models.DBSettings{Charset: "utf8mb4", Collation: "utf8mb4_0900_ai_ci"}},
+		{name: "charset injection", setting: models.DBSettings{Charset: "utf8mb4; DROP DATABASE shop"}, wantErr: true},
+		{name: "collation injection", setting: models.DBSettings{Collation: "utf8mb4_bin, sql_mode=''"}, wantErr: true},
+		{name: "driver param", setting: models.DBSettings{Params: map[string]string{"parseTime": "true"}}},
+		{name: "session variable", setting: models.DBSettings{Params: map[string]string{"sql_mode": "'ANSI'", "wait_timeout": "600"}}},
+		{name: "session variable injection", setting: models.DBSettings{Params: map[string]string{"sql_mode": "'a' OR (SELECT 1)"}}, wantErr: true},
+		{name: "allowAllFiles", setting: models.DBSettings{Params: map[string]string{"allowAllFiles": "true"}}, wantErr: true},
+		{name: "allowCleartextPasswords", setting: models.DBSettings{Params: map[string]string{"allowCleartextPasswords": "true"}}, wantErr: true},
+		{name: "allowOldPasswords", setting: models.DBSettings{Params: map[string]string{"allowOldPasswords": "true"}}, wantErr: true},
+		{name: "reserved", setting: models.DBSettings{Params: map[string]string{"charset": "utf8"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConnectionOptions(&tt.setting)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mysql-backup/config"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	smtpTimeout    = 30 * time.Second
+	digestTopLimit = 5 // 汇总邮件中列出的耗时最长的备份数量
+)
+
+// EmailService 通过 SMTP 发送告警邮件和备份汇总邮件
+type EmailService struct {
+	cfg    config.SMTPConfig
+	from   *mail.Address
+	to     []*mail.Address
+	alerts map[string]bool
+	store  storage.Store
+	wg     sync.WaitGroup // 正在发送的告警邮件
+}
+
+// NewEmailService 校验收发件地址和告警事件类型，并订阅需要立即告警的事件
+func NewEmailService(cfg *config.SMTPConfig, store storage.Store, events *EventBus) (*EmailService, error) {
+	s := &EmailService{cfg: *cfg, store: store, alerts: make(map[string]bool)}
+
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("无效的发件人地址 %q: %v", cfg.From, err)
+	}
+	s.from = from
+	for _, addr := range cfg.To {
+		to, err := mail.ParseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的收件人地址 %q: %v", addr, err)
+		}
+		s.to = append(s.to, to)
+	}
+	for _, eventType := range cfg.Alerts {
+		if !containsString(models.EventTypes, eventType) {
+			return nil, fmt.Errorf("未知的告警事件类型: %s", eventType)
+		}
+		s.alerts[eventType] = true
+	}
+
+	if len(s.alerts) > 0 {
+		events.Subscribe(s.handle)
+	}
+	return s, nil
+}
+
+// handle 异步发送告警邮件，发送失败只记录日志
+func (s *EmailService) handle(event *models.Event) {
+	if !s.alerts[event.Type] {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		subject := eventTitle(event)
+		if event.SettingName != "" || event.Database != "" {
+			subject += fmt.Sprintf(" %s/%s", event.SettingName, event.Database)
+		}
+		if err := s.Send(subject, alertBody(event)); err != nil {
+			slog.Error("发送告警邮件失败", "event", event.Type, "error", err)
+		}
+	}()
+}
+
+// Wait 等待正在发送的告警邮件完成，命令行退出前调用
+func (s *EmailService) Wait() {
+	s.wg.Wait()
+}
+
+func alertBody(event *models.Event) string {
+	var b strings.Builder
+	b.WriteString(event.Message + "\n\n")
+	for _, field := range eventFields(event) {
+		fmt.Fprintf(&b, "%s: %s\n", field.Label, field.Value)
+	}
+	return b.String()
+}
+
+// SendTest 发送测试邮件，用于检查 SMTP 配置
+func (s *EmailService) SendTest() error {
+	body := fmt.Sprintf("这是一封来自 DataSafe 的测试邮件，发送时间 %s。\n", time.Now().Format("2006-01-02 15:04:05"))
+	return s.Send("DataSafe 测试邮件", body)
+}
+
+// Send 连接 SMTP 服务器发送纯文本邮件。starttls 模式下服务器不支持 STARTTLS 时拒绝发送，避免明文传输密码
+func (s *EmailService) Send(subject, body string) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host, MinVersion: tls.VersionTLS12}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	if s.cfg.Security == config.SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("连接邮件服务器失败: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * smtpTimeout))
+
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("连接邮件服务器失败: %v", err)
+	}
+	defer client.Close()
+
+	if s.cfg.Security == config.SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("邮件服务器不支持 STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS 失败: %v", err)
+		}
+	}
+	if s.cfg.Username != "" {
+		auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("邮件服务器认证失败: %v", err)
+		}
+	}
+
+	if err := client.Mail(s.from.Address); err != nil {
+		return fmt.Errorf("设置发件人失败: %v", err)
+	}
+	for _, to := range s.to {
+		if err := client.Rcpt(to.Address); err != nil {
+			return fmt.Errorf("设置收件人 %s 失败: %v", to.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("发送邮件失败: %v", err)
+	}
+	if _, err := w.Write(s.buildMessage(subject, body, time.Now())); err != nil {
+		return fmt.Errorf("发送邮件失败: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("发送邮件失败: %v", err)
+	}
+	return client.Quit()
+}
+
+// buildMessage 生成邮件内容，标题按 RFC 2047 编码，正文使用 base64 编码的 UTF-8 文本
+func (s *EmailService) buildMessage(subject, body string, now time.Time) []byte {
+	to := make([]string, 0, len(s.to))
+	for _, addr := range s.to {
+		to = append(to, addr.String())
+	}
+	id := make([]byte, 12)
+	rand.Read(id)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@datasafe>\r\n", hex.EncodeToString(id))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.Bytes()
+}
+
+// DigestRecord 汇总中列出的单个备份
+type DigestRecord struct {
+	ID          int    `json:"id"`
+	SettingName string `json:"settingName"`
+	Database    string `json:"database"`
+	CreatedAt   string `json:"createdAt"`
+	Duration    int64  `json:"duration"` // 毫秒
+	Size        int64  `json:"size"`
+	Error       string `json:"error,omitempty"`
+}
+
+// StaleDatabase 统计周期内没有成功备份的数据库
+type StaleDatabase struct {
+	SettingID   int    `json:"settingId"`
+	SettingName string `json:"settingName"`
+	Database    string `json:"database"`
+	LastSuccess string `json:"lastSuccess,omitempty"` // 为空表示从未成功
+}
+
+// DigestReport 一个统计周期内所有备份记录的汇总
+type DigestReport struct {
+	Period    string           `json:"period"`
+	From      string           `json:"from"`
+	To        string           `json:"to"`
+	Total     int              `json:"total"`
+	Succeeded int              `json:"succeeded"` // 包括之后被保留策略清理的备份
+	Failed    int              `json:"failed"`
+	Running   int              `json:"running"`
+	TotalSize int64            `json:"totalSize"`
+	Failures  []*DigestRecord  `json:"failures"`
+	Slowest   []*DigestRecord  `json:"slowest"`
+	Stale     []*StaleDatabase `json:"stale"`
+}
+
+// DigestPeriod 配置的汇总周期
+func (s *EmailService) DigestPeriod() string {
+	return s.cfg.DigestPeriod
+}
+
+// periodDuration 汇总周期对应的时间长度
+func periodDuration(period string) time.Duration {
+	if period == config.DigestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// BuildDigest 汇总 now 之前一个周期内的备份记录。定时任务中的数据库和周期内有备份记录的数据库，
+// 如果周期内没有成功的备份则列为过期
+func (s *EmailService) BuildDigest(period string, now time.Time) (*DigestReport, error) {
+	start := now.Add(-periodDuration(period))
+	report := &DigestReport{
+		Period:   period,
+		From:     start.Format("2006-01-02 15:04:05"),
+		To:       now.Format("2006-01-02 15:04:05"),
+		Failures: []*DigestRecord{},
+		Slowest:  []*DigestRecord{},
+		Stale:    []*StaleDatabase{},
+	}
+
+	settings, err := s.store.GetAllSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库配置失败: %v", err)
+	}
+	names := make(map[int]string)
+	for _, setting := range settings {
+		names[setting.ID] = setting.Name
+	}
+	records, err := s.store.GetBackupRecords()
+	if err != nil {
+		return nil, fmt.Errorf("获取备份记录失败: %v", err)
+	}
+	tasks, err := s.store.GetAllSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("获取定时任务失败: %v", err)
+	}
+
+	type dbKey struct {
+		settingID int
+		database  string
+	}
+	watched := make(map[dbKey]bool)
+	for _, task := range tasks {
+		watched[dbKey{task.SettingID, task.Database}] = true
+	}
+	lastSuccess := make(map[dbKey]time.Time)
+
+	var succeeded []*models.BackupRecord
+	for _, record := range records {
+		created, err := parseRecordTime(record)
+		if err != nil || created.After(now) {
+			continue
+		}
+		key := dbKey{record.SettingID, record.DBName}
+		ok := record.Status == models.StatusCompleted || record.Status == models.StatusPruned
+		if ok && created.After(lastSuccess[key]) {
+			lastSuccess[key] = created
+		}
+		if created.Before(start) {
+			continue
+		}
+
+		watched[key] = true
+		report.Total++
+		switch {
+		case ok:
+			report.Succeeded++
+			report.TotalSize += record.Size
+			succeeded = append(succeeded, record)
+		case record.Status == models.StatusFailed:
+			report.Failed++
+			report.Failures = append(report.Failures, toDigestRecord(record, names))
+		default:
+			report.Running++
+		}
+	}
+
+	sort.Slice(succeeded, func(i, j int) bool {
+		return succeeded[i].Duration > succeeded[j].Duration
+	})
+	for i := 0; i < len(succeeded) && i < digestTopLimit; i++ {
+		if succeeded[i].Duration > 0 {
+			report.Slowest = append(report.Slowest, toDigestRecord(succeeded[i], names))
+		}
+	}
+
+	for key := range watched {
+		name, exists := names[key.settingID]
+		if !exists || !lastSuccess[key].Before(start) {
+			continue // 配置已删除，或周期内有成功的备份
+		}
+		stale := &StaleDatabase{SettingID: key.settingID, SettingName: name, Database: key.database}
+		if last := lastSuccess[key]; !last.IsZero() {
+			stale.LastSuccess = last.Format("2006-01-02 15:04:05")
+		}
+		report.Stale = append(report.Stale, stale)
+	}
+	sort.Slice(report.Stale, func(i, j int) bool {
+		a, b := report.Stale[i], report.Stale[j]
+		if a.SettingName != b.SettingName {
+			return a.SettingName < b.SettingName
+		}
+		return a.Database < b.Database
+	})
+	return report, nil
+}
+
+func toDigestRecord(record *models.BackupRecord, names map[int]string) *DigestRecord {
+	return &DigestRecord{
+		ID:          record.ID,
+		SettingName: names[record.SettingID],
+		Database:    record.DBName,
+		CreatedAt:   record.CreatedAt,
+		Duration:    record.Duration,
+		Size:        record.Size,
+		Error:       record.Error,
+	}
+}
+
+// digestBody 汇总邮件的正文
+func digestBody(report *DigestReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "DataSafe 备份汇总（%s 至 %s）\n\n", report.From, report.To)
+	fmt.Fprintf(&b, "备份总数: %d\n成功: %d\n失败: %d\n进行中: %d\n备份总大小: %s\n",
+		report.Total, report.Succeeded, report.Failed, report.Running, formatBytes(report.TotalSize))
+
+	if len(report.Failures) > 0 {
+		b.WriteString("\n失败的备份:\n")
+		for _, record := range report.Failures {
+			fmt.Fprintf(&b, "- %s/%s %s: %s\n", record.SettingName, record.Database, record.CreatedAt, record.Error)
+		}
+	}
+	if len(report.Slowest) > 0 {
+		b.WriteString("\n耗时最长的备份:\n")
+		for _, record := range report.Slowest {
+			fmt.Fprintf(&b, "- %s/%s %s: 耗时 %s，大小 %s\n", record.SettingName, record.Database, record.CreatedAt,
+				formatDuration(record.Duration), formatBytes(record.Size))
+		}
+	}
+	if len(report.Stale) > 0 {
+		b.WriteString("\n周期内没有成功备份的数据库:\n")
+		for _, stale := range report.Stale {
+			last := "从未成功"
+			if stale.LastSuccess != "" {
+				last = "最近一次成功 " + stale.LastSuccess
+			}
+			fmt.Fprintf(&b, "- %s/%s（%s）\n", stale.SettingName, stale.Database, last)
+		}
+	}
+	return b.String()
+}
+
+// SendDigest 汇总配置周期内的备份并发送邮件
+func (s *EmailService) SendDigest() (*DigestReport, error) {
+	report, err := s.BuildDigest(s.cfg.DigestPeriod, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	subject := fmt.Sprintf("DataSafe 备份汇总 %s：成功 %d，失败 %d", report.To[:10], report.Succeeded, report.Failed)
+	if len(report.Stale) > 0 {
+		subject += fmt.Sprintf("，%d 个数据库没有成功备份", len(report.Stale))
+	}
+	return report, s.Send(subject, digestBody(report))
+}
+
+// StartDigestJob 按配置的 Cron 表达式（5字段）定期发送汇总邮件，未配置时不启动
+func (s *EmailService) StartDigestJob(c *cron.Cron) error {
+	if s.cfg.Digest == "" {
+		return nil
+	}
+	_, err := c.AddFunc("0 "+s.cfg.Digest, func() {
+		if _, err := s.SendDigest(); err != nil {
+			slog.Error("发送备份汇总邮件失败", "error", err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("添加汇总邮件任务失败: %v", err)
+	}
+	return nil
+}
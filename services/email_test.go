@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mysql-backup/config"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpSink 只实现发送一封邮件所需命令的 SMTP 服务器，记录收到的命令和邮件内容
+type smtpSink struct {
+	listener net.Listener
+	starttls bool // 是否在 EHLO 响应中声明 STARTTLS
+
+	mu       sync.Mutex
+	commands []string
+	messages []string
+}
+
+func newSMTPSink(t *testing.T, starttls bool) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &smtpSink{listener: listener, starttls: starttls}
+	go sink.serve()
+	t.Cleanup(func() { listener.Close() })
+	return sink
+}
+
+func (s *smtpSink) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, verb)
+		s.mu.Unlock()
+
+		switch verb {
+		case "EHLO":
+			reply("250-sink")
+			if s.starttls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("454 TLS not available due to temporary reason")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 OK")
+		case "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpSink) received() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...), append([]string(nil), s.messages...)
+}
+
+func newTestEmailService(t *testing.T, sink *smtpSink, security, username string) *EmailService {
+	t.Helper()
+	service, err := NewEmailService(&config.SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     sink.port(),
+		Security: security,
+		Username: username,
+		Password: "smtp-pass",
+		From:     "DataSafe <datasafe@example.com>",
+		To:       []string{"dba@example.com", "Ops <ops@example.com>"},
+	}, newTestStore(t), NewEventBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestEmailSendRefusesWithoutSTARTTLS(t *testing.T) {
+	sink := newSMTPSink(t, false)
+	service := newTestEmailService(t, sink, config.SMTPSecurityStartTLS, "datasafe")
+
+	err := service.Send("subject", "body")
+	if err == nil || !strings.Contains(err.Error(), "不支持 STARTTLS") {
+		t.Fatalf("err = %v, want STARTTLS refusal", err)
+	}
+	// 没有发送认证信息和邮件
+	commands, messages := sink.received()
+	for _, command := range commands {
+		if command == "AUTH" || command == "MAIL" {
+			t.Fatalf("sent %s over plaintext: %v", command, commands)
+		}
+	}
+	if len(messages) != 0 {
+		t.Fatalf("message delivered: %q", messages)
+	}
+}
+
+func TestEmailSendStopsWhenSTARTTLSFails(t *testing.T) {
+	sink := newSMTPSink(t, true)
+	service := newTestEmailService(t, sink, config.SMTPSecurityStartTLS, "datasafe")
+
+	err := service.Send("subject", "body")
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS 失败") {
+		t.Fatalf("err = %v, want STARTTLS failure", err)
+	}
+	commands, _ := sink.received()
+	for _, command := range commands {
+		if command == "AUTH" || command == "MAIL" {
+			t.Fatalf("sent %s over plaintext: %v", command, commands)
+		}
+	}
+}
+
+func TestEmailSendMessage(t *testing.T) {
+	sink := newSMTPSink(t, false)
+	service := newTestEmailService(t, sink, config.SMTPSecurityNone, "")
+
+	subject := "备份失败 生产库/shop"
+	body := strings.Repeat("备份 shop 失败：连接超时。\n", 10)
+	if err := service.Send(subject, body); err != nil {
+		t.Fatal(err)
+	}
+
+	commands, messages := sink.received()
+	if len(messages) != 1 {
+		t.Fatalf("messages = %d, commands = %v", len(messages), commands)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(messages[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || decoded != subject {
+		t.Fatalf("subject = %q (%v), want %q", decoded, err, subject)
+	}
+	if to := msg.Header.Get("To"); !strings.Contains(to, "dba@example.com") || !strings.Contains(to, "ops@example.com") {
+		t.Fatalf("to = %q", to)
+	}
+	if got := msg.Header.Get("Content-Transfer-Encoding"); got != "base64" {
+		t.Fatalf("Content-Transfer-Encoding = %q", got)
+	}
+	raw, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(raw), "\r\n"), "\r\n") {
+		if len(line) > 76 {
+			t.Fatalf("body line longer than 76: %d", len(line))
+		}
+	}
+	text, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+	if err != nil || string(text) != body {
+		t.Fatalf("body = %q (%v), want %q", text, err, body)
+	}
+
+	rcpt := 0
+	for _, command := range commands {
+		if command == "RCPT" {
+			rcpt++
+		}
+	}
+	if rcpt != 2 {
+		t.Fatalf("RCPT count = %d, want 2", rcpt)
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mysql-backup/models"
+	"sync"
+	"time"
+)
+
+// EventBus 发布备份过程中的事件，由订阅者（如 Webhook）发送通知
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []func(event *models.Event)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe 注册订阅者。订阅者在发布事件的 goroutine 中同步调用，耗时的操作需要自行异步执行
+func (b *EventBus) Subscribe(fn func(event *models.Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Publish 补全事件 ID 和时间后通知所有订阅者
+func (b *EventBus) Publish(event *models.Event) {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time == "" {
+		event.Time = time.Now().Format("2006-01-02 15:04:05")
+	}
+
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+func newEventID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newBackupEvent 生成与备份记录相关的事件，err 不为空时记录错误信息
+func newBackupEvent(eventType string, setting *models.DBSettings, database string, record *models.BackupRecord, err error) *models.Event {
+	event := &models.Event{
+		Type:        eventType,
+		SettingID:   setting.ID,
+		SettingName: setting.Name,
+		Database:    database,
+	}
+	if record != nil {
+		copied := *record
+		event.Record = &copied
+		event.ScheduleID = record.ScheduleID
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	target := fmt.Sprintf("%s/%s", setting.Name, database)
+	switch eventType {
+	case models.EventBackupStarted:
+		event.Message = "开始备份 " + target
+	case models.EventBackupSucceeded:
+		event.Message = fmt.Sprintf("备份成功 %s，大小 %s，耗时 %s", target, formatBytes(record.Size), formatDuration(record.Duration))
+	case models.EventBackupFailed:
+		event.Message = fmt.Sprintf("备份失败 %s: %s", target, event.Error)
+	case models.EventRetentionPruned:
+		event.Message = fmt.Sprintf("按保留策略删除备份 %s: %s", target, record.FileName)
+	case models.EventVerifyFailed:
+		event.Message = fmt.Sprintf("备份校验失败 %s: %s，%s", target, record.FileName, event.Error)
+	default:
+		event.Message = target
+	}
+	return event
+}
+
+// formatBytes 以合适的单位显示文件大小
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDuration 显示以毫秒为单位的耗时
+func formatDuration(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).Round(time.Millisecond).String()
+}
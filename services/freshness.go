@@ -0,0 +1,297 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// minRPO 恢复点目标的最小值，检查每分钟执行一次，更短的目标没有意义
+const minRPO = time.Minute
+
+// ValidateRPO 检查恢复点目标，格式为 Go 时长（如 26h、90m），为空表示不检查
+func ValidateRPO(rpo string) error {
+	if rpo == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(rpo)
+	if err != nil {
+		return fmt.Errorf("无效的恢复点目标 %q，格式如 26h 或 90m", rpo)
+	}
+	if d < minRPO {
+		return fmt.Errorf("恢复点目标不能小于 %s", minRPO)
+	}
+	return nil
+}
+
+// FreshnessStatus 一个数据库的恢复点目标检查结果
+type FreshnessStatus struct {
+	SettingID     int    `json:"settingId"`
+	SettingName   string `json:"settingName"`
+	Database      string `json:"database"`
+	ScheduleIDs   []int  `json:"scheduleIds"`
+	RPO           string `json:"rpo"`
+	LastSuccess   string `json:"lastSuccess,omitempty"` // 最近一次成功备份完成的时间，为空表示从未成功
+	Age           int64  `json:"age"`                   // 距最近一次成功备份的秒数，从未成功时从定时任务创建时算起
+	Breached      bool   `json:"breached"`
+	Reason        string `json:"reason,omitempty"`
+	BreachedSince string `json:"breachedSince,omitempty"`
+
+	rpo     time.Duration
+	created time.Time // 最早的定时任务的创建时间
+}
+
+// freshnessKey 恢复点目标按数据库配置和数据库区分，同一数据库的多个定时任务取最短的目标
+type freshnessKey struct {
+	SettingID int
+	Database  string
+}
+
+// FreshnessService 定期按备份记录检查每个设置了恢复点目标的数据库，超过目标或恢复时发布事件。
+// 定时任务停止执行（例如没有在调度器中注册）时，最近一次成功备份会逐渐超过目标，从而被发现
+type FreshnessService struct {
+	store     storage.Store
+	schedules *ScheduleService
+	events    *EventBus
+
+	mu            sync.Mutex
+	statuses      []*FreshnessStatus
+	checkedAt     time.Time
+	breachedSince map[freshnessKey]time.Time
+}
+
+func NewFreshnessService(store storage.Store, schedules *ScheduleService, events *EventBus) *FreshnessService {
+	return &FreshnessService{
+		store:         store,
+		schedules:     schedules,
+		events:        events,
+		breachedSince: make(map[freshnessKey]time.Time),
+	}
+}
+
+// StartChecker 立即检查一次，之后每分钟检查一次
+func (s *FreshnessService) StartChecker(c *cron.Cron) error {
+	check := func() {
+		if _, err := s.Check(time.Now()); err != nil {
+			slog.Error("检查恢复点目标失败", "error", err)
+		}
+	}
+	if _, err := c.AddFunc("0 * * * * *", check); err != nil {
+		return fmt.Errorf("添加恢复点目标检查任务失败: %v", err)
+	}
+	check()
+	return nil
+}
+
+// Check 按备份记录重新计算所有恢复点目标的状态，状态变化时发布 rpo.breached 或 rpo.recovered 事件
+func (s *FreshnessService) Check(now time.Time) ([]*FreshnessStatus, error) {
+	// 整个检查持有锁，避免并发的检查按不同时刻的记录重复发布事件
+	s.mu.Lock()
+	statuses, err := s.objectives()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	lastSuccess, err := s.lastSuccessTimes()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	var events []*models.Event
+	seen := make(map[freshnessKey]bool)
+	for _, status := range statuses {
+		key := freshnessKey{status.SettingID, status.Database}
+		seen[key] = true
+
+		since, ok := lastSuccess[key]
+		if ok {
+			status.LastSuccess = since.Format("2006-01-02 15:04:05")
+		} else if !status.created.IsZero() {
+			since = status.created
+		} else {
+			since = now
+		}
+		status.Age = int64(now.Sub(since).Seconds())
+
+		if status.Reason == "" && now.Sub(since) > status.rpo {
+			status.Breached = true
+			if ok {
+				status.Reason = fmt.Sprintf("最近一次成功备份在 %s，已超过 %s", status.LastSuccess, status.RPO)
+			} else {
+				status.Reason = fmt.Sprintf("%s 内没有成功的备份", status.RPO)
+			}
+		}
+
+		breachedSince, wasBreached := s.breachedSince[key]
+		switch {
+		case status.Breached && !wasBreached:
+			breachedSince = now
+			s.breachedSince[key] = now
+			events = append(events, freshnessEvent(models.EventRPOBreached, status))
+		case !status.Breached && wasBreached:
+			delete(s.breachedSince, key)
+			events = append(events, freshnessEvent(models.EventRPORecovered, status))
+		}
+		if status.Breached {
+			status.BreachedSince = breachedSince.Format("2006-01-02 15:04:05")
+		}
+	}
+	// 删除的恢复点目标不再跟踪
+	for key := range s.breachedSince {
+		if !seen[key] {
+			delete(s.breachedSince, key)
+		}
+	}
+	s.statuses = statuses
+	s.checkedAt = now
+	s.mu.Unlock()
+
+	for _, event := range events {
+		s.events.Publish(event)
+	}
+	return statuses, nil
+}
+
+// Statuses 返回最近一次检查的结果和检查时间
+func (s *FreshnessService) Statuses() ([]*FreshnessStatus, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statuses, s.checkedAt
+}
+
+// objectives 从定时任务中收集恢复点目标，同一数据库取最短的目标。
+// 保存的定时任务没有在调度器中注册时，直接视为超过目标
+func (s *FreshnessService) objectives() ([]*FreshnessStatus, error) {
+	tasks, err := s.store.GetAllSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("获取定时任务失败: %v", err)
+	}
+	settings, err := s.store.GetAllSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库配置失败: %v", err)
+	}
+	names := make(map[int]string, len(settings))
+	for _, setting := range settings {
+		names[setting.ID] = setting.Name
+	}
+	registered := make(map[int]bool)
+	for _, task := range s.schedules.ListTasks() {
+		registered[task.ID] = true
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	byKey := make(map[freshnessKey]*FreshnessStatus)
+	var statuses []*FreshnessStatus
+	for _, task := range tasks {
+		if task.RPO == "" {
+			continue
+		}
+		rpo, err := time.ParseDuration(task.RPO)
+		if err != nil {
+			slog.Warn("定时任务的恢复点目标无效", "schedule", task.ID, "rpo", task.RPO, "error", err)
+			continue
+		}
+
+		key := freshnessKey{task.SettingID, task.Database}
+		status, ok := byKey[key]
+		if !ok {
+			status = &FreshnessStatus{
+				SettingID:   task.SettingID,
+				SettingName: names[task.SettingID],
+				Database:    task.Database,
+				RPO:         task.RPO,
+				rpo:         rpo,
+			}
+			byKey[key] = status
+			statuses = append(statuses, status)
+		}
+		status.ScheduleIDs = append(status.ScheduleIDs, task.ID)
+		if created, err := time.ParseInLocation("2006-01-02 15:04:05", task.CreatedAt, time.Local); err == nil {
+			if status.created.IsZero() || created.Before(status.created) {
+				status.created = created
+			}
+		}
+		if rpo < status.rpo {
+			status.rpo = rpo
+			status.RPO = task.RPO
+		}
+
+		var problems []string
+		if status.Reason != "" {
+			problems = append(problems, status.Reason)
+		}
+		switch {
+		case status.SettingName == "":
+			problems = append(problems, fmt.Sprintf("定时任务 #%d 的数据库配置不存在", task.ID))
+		case !registered[task.ID]:
+			problems = append(problems, fmt.Sprintf("定时任务 #%d 没有在调度器中注册，不会执行", task.ID))
+		}
+		if len(problems) > 0 {
+			status.Breached = true
+			status.Reason = strings.Join(problems, "；")
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].SettingName != statuses[j].SettingName {
+			return statuses[i].SettingName < statuses[j].SettingName
+		}
+		return statuses[i].Database < statuses[j].Database
+	})
+	return statuses, nil
+}
+
+// lastSuccessTimes 每个数据库最近一次成功备份完成的时间，已按保留策略清理的备份同样计入
+func (s *FreshnessService) lastSuccessTimes() (map[freshnessKey]time.Time, error) {
+	records, err := s.store.GetBackupRecords()
+	if err != nil {
+		return nil, fmt.Errorf("获取备份记录失败: %v", err)
+	}
+	times := make(map[freshnessKey]time.Time)
+	for _, record := range records {
+		if record.Status != models.StatusCompleted && record.Status != models.StatusPruned {
+			continue
+		}
+		created, err := parseRecordTime(record)
+		if err != nil {
+			continue
+		}
+		finished := created.Add(time.Duration(record.Duration) * time.Millisecond)
+		key := freshnessKey{record.SettingID, record.DBName}
+		if finished.After(times[key]) {
+			times[key] = finished
+		}
+	}
+	return times, nil
+}
+
+func freshnessEvent(eventType string, status *FreshnessStatus) *models.Event {
+	event := &models.Event{
+		Type:        eventType,
+		SettingID:   status.SettingID,
+		SettingName: status.SettingName,
+		Database:    status.Database,
+		RPO:         status.RPO,
+		LastSuccess: status.LastSuccess,
+	}
+	if len(status.ScheduleIDs) > 0 {
+		event.ScheduleID = status.ScheduleIDs[0]
+	}
+
+	target := fmt.Sprintf("%s/%s", status.SettingName, status.Database)
+	if eventType == models.EventRPOBreached {
+		event.Error = status.Reason
+		event.Message = fmt.Sprintf("备份超过恢复点目标 %s: %s", target, status.Reason)
+	} else {
+		event.Message = fmt.Sprintf("备份已恢复到恢复点目标内 %s，最近一次成功备份在 %s", target, status.LastSuccess)
+	}
+	return event
+}
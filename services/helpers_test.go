@@ -0,0 +1,34 @@
+package services
+
+import (
+	"mysql-backup/config"
+	"mysql-backup/storage"
+	"path/filepath"
+	"testing"
+
+	"github.com/robfig/cron/v3"
+)
+
+// newTestStore 在临时目录中创建 BoltDB 存储
+func newTestStore(t *testing.T) *storage.BoltStore {
+	t.Helper()
+	cipher, err := storage.NewSecretCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "data.db"), cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// newTestScheduleService 创建使用默认配置的备份服务和与 main 相同的秒级调度器，测试结束时停止调度
+func newTestScheduleService(t *testing.T, store storage.Store) (*BackupService, *ScheduleService) {
+	t.Helper()
+	backup := NewBackupService(config.Default())
+	schedule := NewScheduleService(cron.New(cron.WithSeconds()), backup, store, NewAuditService(store))
+	t.Cleanup(func() { <-schedule.Stop().Done() })
+	return backup, schedule
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxJobLogSize 单个备份任务保存的日志上限，超过后不再追加
+const maxJobLogSize = 256 << 10
+
+// jobLog 保存一个备份任务执行期间的日志，任务执行中也可以读取
+type jobLog struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.truncated {
+		return len(p), nil
+	}
+	if l.buf.Len()+len(p) > maxJobLogSize {
+		l.truncated = true
+		l.buf.WriteString("... 日志超过上限，后面的内容未保存\n")
+		return len(p), nil
+	}
+	return l.buf.Write(p)
+}
+
+func (l *jobLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// jobLogHandler 把日志按 "时间 级别 消息 key=value" 的格式逐行写入任务日志。
+// 任务日志本身就属于某个备份记录，With 添加的上下文属性不再重复写入
+type jobLogHandler struct {
+	log *jobLog
+}
+
+func (h *jobLogHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *jobLogHandler) Handle(_ context.Context, r slog.Record) error {
+	var line strings.Builder
+	line.WriteString(r.Time.Format("2006-01-02 15:04:05"))
+	line.WriteByte(' ')
+	line.WriteString(r.Level.String())
+	line.WriteByte(' ')
+	line.WriteString(r.Message)
+	r.Attrs(func(attr slog.Attr) bool {
+		fmt.Fprintf(&line, " %s=%s", attr.Key, jobLogValue(attr.Value.Resolve().String()))
+		return true
+	})
+	line.WriteByte('\n')
+	_, err := h.log.Write([]byte(line.String()))
+	return err
+}
+
+func (h *jobLogHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *jobLogHandler) WithGroup(string) slog.Handler { return h }
+
+// jobLogValue 值中包含空格、引号、等号或换行时加引号，保证每条日志只占一行
+func jobLogValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \"=\n\r\t") {
+		return fmt.Sprintf("%q", value)
+	}
+	return value
+}
+
+// teeHandler 把同一条日志同时交给多个 handler
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// newJobLogger 返回的 logger 同时写入服务日志和任务日志，attrs 只出现在服务日志中
+func newJobLogger(l *jobLog, attrs ...any) *slog.Logger {
+	return slog.New(teeHandler{slog.Default().Handler(), &jobLogHandler{log: l}}).With(attrs...)
+}
+
+// formatJobDuration 任务日志中的耗时保留到毫秒
+func formatJobDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// backupDurationBuckets 备份耗时直方图的分桶（秒）
+	backupDurationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200, 14400}
+	// backupSizeBuckets 备份文件大小直方图的分桶（字节）
+	backupSizeBuckets = []float64{1 << 20, 10 << 20, 100 << 20, 1 << 30, 10 << 30, 100 << 30}
+)
+
+// seriesKey 按连接名称和数据库区分的指标
+type seriesKey struct {
+	Setting  string
+	Database string
+}
+
+// histogram Prometheus 直方图，counts 为累计计数，与 buckets 一一对应
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// MetricsService 汇总备份事件、任务队列、定时任务和存储状态，以 Prometheus 文本格式输出
+type MetricsService struct {
+	store     storage.Store
+	backup    *BackupService
+	schedules *ScheduleService
+	freshness *FreshnessService
+
+	mu          sync.Mutex
+	backups     map[seriesKey]map[string]float64 // 按状态（succeeded、failed）统计的备份次数
+	durations   map[seriesKey]*histogram
+	sizes       map[seriesKey]*histogram
+	bytes       map[seriesKey]float64
+	rows        map[seriesKey]float64
+	lastSuccess map[seriesKey]time.Time
+	pruned      map[seriesKey]float64
+	verifyFails map[seriesKey]float64
+}
+
+// NewMetricsService 订阅备份事件，并从备份记录中恢复每个数据库最近一次成功备份的时间，
+// 重启后不会因为该指标缺失而误报
+func NewMetricsService(store storage.Store, backup *BackupService, schedules *ScheduleService, freshness *FreshnessService) *MetricsService {
+	s := &MetricsService{
+		store:       store,
+		backup:      backup,
+		schedules:   schedules,
+		freshness:   freshness,
+		backups:     make(map[seriesKey]map[string]float64),
+		durations:   make(map[seriesKey]*histogram),
+		sizes:       make(map[seriesKey]*histogram),
+		bytes:       make(map[seriesKey]float64),
+		rows:        make(map[seriesKey]float64),
+		lastSuccess: make(map[seriesKey]time.Time),
+		pruned:      make(map[seriesKey]float64),
+		verifyFails: make(map[seriesKey]float64),
+	}
+	if err := s.loadLastSuccess(); err != nil {
+		slog.Error("读取最近成功备份时间失败", "error", err)
+	}
+	backup.Events().Subscribe(s.handle)
+	return s
+}
+
+func (s *MetricsService) loadLastSuccess() error {
+	settings, err := s.store.GetAllSettings()
+	if err != nil {
+		return err
+	}
+	names := make(map[int]string, len(settings))
+	for _, setting := range settings {
+		names[setting.ID] = setting.Name
+	}
+
+	records, err := s.store.GetBackupRecords()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		name, ok := names[record.SettingID]
+		if !ok || (record.Status != models.StatusCompleted && record.Status != models.StatusPruned) {
+			continue
+		}
+		created, err := parseRecordTime(record)
+		if err != nil {
+			continue
+		}
+		finished := created.Add(time.Duration(record.Duration) * time.Millisecond)
+		key := seriesKey{name, record.DBName}
+		if finished.After(s.lastSuccess[key]) {
+			s.lastSuccess[key] = finished
+		}
+	}
+	return nil
+}
+
+// handle 根据备份事件更新计数、直方图和最近成功时间
+func (s *MetricsService) handle(event *models.Event) {
+	key := seriesKey{event.SettingName, event.Database}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch event.Type {
+	case models.EventBackupSucceeded:
+		s.countBackup(key, "succeeded")
+		s.lastSuccess[key] = time.Now()
+		if record := event.Record; record != nil {
+			s.observe(key, record)
+			s.bytes[key] += float64(record.Size)
+			s.rows[key] += float64(record.Rows)
+		}
+	case models.EventBackupFailed:
+		s.countBackup(key, "failed")
+		if record := event.Record; record != nil && record.Duration > 0 {
+			s.histogram(s.durations, key, backupDurationBuckets).observe(float64(record.Duration) / 1000)
+		}
+	case models.EventRetentionPruned:
+		s.pruned[key]++
+	case models.EventVerifyFailed:
+		s.verifyFails[key]++
+	}
+}
+
+func (s *MetricsService) countBackup(key seriesKey, status string) {
+	if s.backups[key] == nil {
+		s.backups[key] = make(map[string]float64)
+	}
+	s.backups[key][status]++
+}
+
+func (s *MetricsService) observe(key seriesKey, record *models.BackupRecord) {
+	s.histogram(s.durations, key, backupDurationBuckets).observe(float64(record.Duration) / 1000)
+	s.histogram(s.sizes, key, backupSizeBuckets).observe(float64(record.Size))
+}
+
+func (s *MetricsService) histogram(m map[seriesKey]*histogram, key seriesKey, buckets []float64) *histogram {
+	h, ok := m[key]
+	if !ok {
+		h = newHistogram(buckets)
+		m[key] = h
+	}
+	return h
+}
+
+// Render 生成 Prometheus 文本格式（0.0.4）的指标
+func (s *MetricsService) Render() ([]byte, error) {
+	w := &metricWriter{}
+	s.writeBackupMetrics(w)
+
+	queued, running := s.backup.JobStats()
+	w.family("datasafe_jobs_queued", "gauge", "等待执行的备份和恢复任务数量")
+	w.sample("datasafe_jobs_queued", float64(queued))
+	w.family("datasafe_jobs_running", "gauge", "正在执行的备份和恢复任务数量")
+	w.sample("datasafe_jobs_running", float64(running))
+
+	if err := s.writeRecordMetrics(w); err != nil {
+		return nil, err
+	}
+	if err := s.writeScheduleMetrics(w); err != nil {
+		return nil, err
+	}
+	s.writeFreshnessMetrics(w)
+	if err := s.writeStoreMetrics(w); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// writeBackupMetrics 输出由备份事件累计的指标
+func (s *MetricsService) writeBackupMetrics(w *metricWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.family("datasafe_backups_total", "counter", "启动以来按结果统计的备份次数")
+	for _, key := range sortedKeys(s.backups) {
+		statuses := make([]string, 0, len(s.backups[key]))
+		for status := range s.backups[key] {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			w.sample("datasafe_backups_total", s.backups[key][status], "setting", key.Setting, "database", key.Database, "status", status)
+		}
+	}
+
+	w.writeHistograms("datasafe_backup_duration_seconds", "备份耗时（秒）", s.durations)
+	w.writeHistograms("datasafe_backup_size_bytes", "成功备份的文件大小（字节）", s.sizes)
+	w.writeCounters("datasafe_backup_bytes_total", "启动以来成功备份写入的字节数", s.bytes)
+	w.writeCounters("datasafe_backup_rows_total", "启动以来成功备份导出的数据行数", s.rows)
+
+	w.family("datasafe_backup_last_success_timestamp_seconds", "gauge", "最近一次成功备份完成的时间（Unix 时间戳）")
+	for _, key := range sortedKeys(s.lastSuccess) {
+		w.sample("datasafe_backup_last_success_timestamp_seconds", float64(s.lastSuccess[key].Unix()), "setting", key.Setting, "database", key.Database)
+	}
+
+	w.writeCounters("datasafe_backups_pruned_total", "启动以来按保留策略删除的备份数量", s.pruned)
+	w.writeCounters("datasafe_verify_failures_total", "启动以来校验未通过的备份数量", s.verifyFails)
+}
+
+// writeRecordMetrics 按状态统计存储中的备份记录
+func (s *MetricsService) writeRecordMetrics(w *metricWriter) error {
+	records, err := s.store.GetBackupRecords()
+	if err != nil {
+		return fmt.Errorf("获取备份记录失败: %v", err)
+	}
+	counts := map[string]int{
+		models.StatusCompleted:  0,
+		models.StatusFailed:     0,
+		models.StatusInProgress: 0,
+		models.StatusPruned:     0,
+	}
+	for _, record := range records {
+		counts[record.Status]++
+	}
+
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	w.family("datasafe_backup_records", "gauge", "按状态统计的备份记录数量")
+	for _, status := range statuses {
+		w.sample("datasafe_backup_records", float64(counts[status]), "status", status)
+	}
+	return nil
+}
+
+// writeScheduleMetrics 输出每个定时任务下一次执行的时间
+func (s *MetricsService) writeScheduleMetrics(w *metricWriter) error {
+	settings, err := s.store.GetAllSettings()
+	if err != nil {
+		return fmt.Errorf("获取数据库配置失败: %v", err)
+	}
+	names := make(map[int]string, len(settings))
+	for _, setting := range settings {
+		names[setting.ID] = setting.Name
+	}
+
+	tasks := s.schedules.ListTasks()
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	w.family("datasafe_schedules", "gauge", "已注册的定时任务数量")
+	w.sample("datasafe_schedules", float64(len(tasks)))
+	w.family("datasafe_schedule_next_run_timestamp_seconds", "gauge", "定时任务下一次执行的时间（Unix 时间戳）")
+	for _, task := range tasks {
+		next := s.schedules.NextRun(task)
+		if next.IsZero() {
+			continue
+		}
+		w.sample("datasafe_schedule_next_run_timestamp_seconds", float64(next.Unix()),
+			"schedule_id", strconv.Itoa(task.ID), "setting", names[task.SettingID], "database", task.Database)
+	}
+	return nil
+}
+
+// writeFreshnessMetrics 输出最近一次恢复点目标检查的结果
+func (s *MetricsService) writeFreshnessMetrics(w *metricWriter) {
+	statuses, _ := s.freshness.Statuses()
+	w.family("datasafe_rpo_seconds", "gauge", "数据库的恢复点目标（秒）")
+	for _, status := range statuses {
+		w.sample("datasafe_rpo_seconds", status.rpo.Seconds(), "setting", status.SettingName, "database", status.Database)
+	}
+	w.family("datasafe_backup_age_seconds", "gauge", "距最近一次成功备份的秒数，只包含设置了恢复点目标的数据库")
+	for _, status := range statuses {
+		w.sample("datasafe_backup_age_seconds", float64(status.Age), "setting", status.SettingName, "database", status.Database)
+	}
+	w.family("datasafe_rpo_breached", "gauge", "是否超过恢复点目标（1 表示超过）")
+	for _, status := range statuses {
+		breached := 0.0
+		if status.Breached {
+			breached = 1
+		}
+		w.sample("datasafe_rpo_breached", breached, "setting", status.SettingName, "database", status.Database)
+	}
+}
+
+// writeStoreMetrics 输出 BoltDB 的文件大小、记录数和事务统计
+func (s *MetricsService) writeStoreMetrics(w *metricWriter) error {
+	stats, err := s.store.Stats()
+	if err != nil {
+		return fmt.Errorf("获取存储状态失败: %v", err)
+	}
+
+	w.family("datasafe_store_size_bytes", "gauge", "BoltDB 数据文件大小（字节）")
+	w.sample("datasafe_store_size_bytes", float64(stats.Size))
+	buckets := make([]string, 0, len(stats.Keys))
+	for name := range stats.Keys {
+		buckets = append(buckets, name)
+	}
+	sort.Strings(buckets)
+	w.family("datasafe_store_keys", "gauge", "BoltDB 各 bucket 的记录数")
+	for _, name := range buckets {
+		w.sample("datasafe_store_keys", float64(stats.Keys[name]), "bucket", name)
+	}
+	w.family("datasafe_store_free_pages", "gauge", "BoltDB 空闲页数")
+	w.sample("datasafe_store_free_pages", float64(stats.FreePages))
+	w.family("datasafe_store_pending_pages", "gauge", "BoltDB 等待释放的页数")
+	w.sample("datasafe_store_pending_pages", float64(stats.PendingPages))
+	w.family("datasafe_store_read_transactions_total", "counter", "BoltDB 已开始的只读事务数")
+	w.sample("datasafe_store_read_transactions_total", float64(stats.ReadTx))
+	w.family("datasafe_store_open_read_transactions", "gauge", "BoltDB 当前打开的只读事务数")
+	w.sample("datasafe_store_open_read_transactions", float64(stats.OpenReadTx))
+	w.family("datasafe_store_page_writes_total", "counter", "BoltDB 写入页的次数")
+	w.sample("datasafe_store_page_writes_total", float64(stats.Writes))
+	w.family("datasafe_store_page_write_seconds_total", "counter", "BoltDB 写入页的总耗时（秒）")
+	w.sample("datasafe_store_page_write_seconds_total", stats.WriteSeconds)
+	return nil
+}
+
+// metricWriter 按 Prometheus 文本格式写入指标
+type metricWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *metricWriter) family(name, typ, help string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample 写入一个样本，labels 为标签名和值交替排列
+func (w *metricWriter) sample(name string, value float64, labels ...string) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.buf.WriteByte('\n')
+}
+
+// labelEscaper 标签值中的反斜杠、双引号和换行需要转义
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (w *metricWriter) writeCounters(name, help string, values map[seriesKey]float64) {
+	w.family(name, "counter", help)
+	for _, key := range sortedKeys(values) {
+		w.sample(name, values[key], "setting", key.Setting, "database", key.Database)
+	}
+}
+
+func (w *metricWriter) writeHistograms(name, help string, values map[seriesKey]*histogram) {
+	w.family(name, "histogram", help)
+	for _, key := range sortedKeys(values) {
+		h := values[key]
+		for i, bound := range h.buckets {
+			w.sample(name+"_bucket", float64(h.counts[i]), "setting", key.Setting, "database", key.Database, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		w.sample(name+"_bucket", float64(h.count), "setting", key.Setting, "database", key.Database, "le", "+Inf")
+		w.sample(name+"_sum", h.sum, "setting", key.Setting, "database", key.Database)
+		w.sample(name+"_count", float64(h.count), "setting", key.Setting, "database", key.Database)
+	}
+}
+
+// sortedKeys 按连接名称和数据库排序，使每次输出的顺序一致
+func sortedKeys[V any](m map[seriesKey]V) []seriesKey {
+	keys := make([]seriesKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Setting != keys[j].Setting {
+			return keys[i].Setting < keys[j].Setting
+		}
+		return keys[i].Database < keys[j].Database
+	})
+	return keys
+}
@@ -0,0 +1,486 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"mysql-backup/config"
+	"mysql-backup/models"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcDiscovery 身份提供方的 OpenID 配置
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCLoginTTL 发起单点登录后完成回调的时限
+const OIDCLoginTTL = 10 * time.Minute
+
+// oidcPending 尚未完成回调的登录请求
+type oidcPending struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// OIDCService OpenID Connect 依赖方，完成授权码流程并将身份映射为本地用户
+type OIDCService struct {
+	config *config.OIDCConfig
+	auth   *AuthService
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	pending   map[string]*oidcPending
+}
+
+func NewOIDCService(cfg *config.OIDCConfig, auth *AuthService) (*OIDCService, error) {
+	if cfg.GroupClaim == "" {
+		cfg.GroupClaim = "groups"
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email", "groups"}
+	}
+	if cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("单点登录缺少回调地址")
+	}
+	if cfg.DefaultRole != "" && !ValidRole(cfg.DefaultRole) {
+		return nil, fmt.Errorf("无效的默认角色: %s", cfg.DefaultRole)
+	}
+	for group, mapping := range cfg.GroupMapping {
+		if !ValidRole(mapping.Role) {
+			return nil, fmt.Errorf("用户组 %s 的角色无效: %s", group, mapping.Role)
+		}
+	}
+
+	return &OIDCService{
+		config:  cfg,
+		auth:    auth,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		keys:    make(map[string]crypto.PublicKey),
+		pending: make(map[string]*oidcPending),
+	}, nil
+}
+
+// getDiscovery 获取并缓存身份提供方的 OpenID 配置
+func (s *OIDCService) getDiscovery() (*oidcDiscovery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.discovery != nil {
+		return s.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	wellKnown := strings.TrimSuffix(s.config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	if err := s.getJSON(wellKnown, &discovery); err != nil {
+		return nil, fmt.Errorf("获取 OpenID 配置失败: %v", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(s.config.IssuerURL, "/") {
+		return nil, fmt.Errorf("OpenID 配置中的 issuer 不匹配: %s", discovery.Issuer)
+	}
+
+	s.discovery = &discovery
+	return s.discovery, nil
+}
+
+func (s *OIDCService) getJSON(u string, v interface{}) error {
+	resp, err := s.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回 %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// AuthCodeURL 生成跳转到身份提供方的授权地址，使用 state、nonce 和 PKCE。
+// 返回的 state 需要保存在发起登录的浏览器中，回调时核对，防止登录 CSRF
+func (s *OIDCService) AuthCodeURL() (string, string, error) {
+	discovery, err := s.getDiscovery()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	for key, pending := range s.pending {
+		if now.After(pending.expiresAt) {
+			delete(s.pending, key)
+		}
+	}
+	s.pending[state] = &oidcPending{nonce: nonce, verifier: verifier, expiresAt: now.Add(OIDCLoginTTL)}
+	s.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.config.ClientID},
+		"redirect_uri":          {s.config.RedirectURL},
+		"scope":                 {strings.Join(s.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// HandleCallback 用授权码换取 ID 令牌，校验后创建或更新本地用户并返回会话令牌
+func (s *OIDCService) HandleCallback(state, code, ip, userAgent string) (string, *models.User, error) {
+	s.mu.Lock()
+	pending, ok := s.pending[state]
+	delete(s.pending, state)
+	s.mu.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) {
+		return "", nil, fmt.Errorf("登录请求无效或已过期，请重新登录")
+	}
+
+	discovery, err := s.getDiscovery()
+	if err != nil {
+		return "", nil, err
+	}
+
+	rawIDToken, err := s.exchangeCode(discovery, code, pending.verifier)
+	if err != nil {
+		return "", nil, err
+	}
+
+	claims, err := s.verifyIDToken(discovery, rawIDToken, pending.nonce)
+	if err != nil {
+		return "", nil, fmt.Errorf("ID 令牌校验失败: %v", err)
+	}
+
+	user, err := s.provisionUser(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := s.auth.CreateSession(user, ip, userAgent)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, user, nil
+}
+
+// exchangeCode 在令牌端点用授权码换取 ID 令牌
+func (s *OIDCService) exchangeCode(discovery *oidcDiscovery, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("请求令牌端点失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("解析令牌响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return "", fmt.Errorf("换取令牌失败: %s %s", result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return "", fmt.Errorf("令牌响应中缺少 id_token")
+	}
+	return result.IDToken, nil
+}
+
+// verifyIDToken 校验 ID 令牌的签名、issuer、audience、有效期和 nonce，返回其中的声明
+func (s *OIDCService) verifyIDToken(discovery *oidcDiscovery, rawIDToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("格式错误")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("签名格式错误")
+	}
+	key, err := s.publicKey(discovery, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); iss != discovery.Issuer {
+		return nil, fmt.Errorf("issuer 不匹配")
+	}
+	if !audienceContains(claims["aud"], s.config.ClientID) {
+		return nil, fmt.Errorf("audience 不匹配")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, fmt.Errorf("令牌已过期")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("nonce 不匹配")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("缺少 sub")
+	}
+
+	return claims, nil
+}
+
+// publicKey 按 kid 获取签名公钥，找不到时重新拉取 JWKS 以支持密钥轮换
+func (s *OIDCService) publicKey(discovery *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := s.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("获取 JWKS 失败: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		// 身份提供方只有一个密钥且令牌未指定 kid 时直接使用
+		if kid == "" && len(keys) == 1 {
+			for _, k := range keys {
+				return k, nil
+			}
+		}
+		return nil, fmt.Errorf("找不到签名密钥: %s", kid)
+	}
+	return key, nil
+}
+
+// provisionUser 根据 ID 令牌声明创建或更新单点登录用户，每次登录都按用户组刷新角色和授权
+func (s *OIDCService) provisionUser(claims map[string]interface{}) (*models.User, error) {
+	subject, _ := claims["sub"].(string)
+	username, _ := claims["preferred_username"].(string)
+	if username == "" {
+		username, _ = claims["email"].(string)
+	}
+	if username == "" {
+		username = subject
+	}
+
+	role, settingIDs := s.mapGroups(claimStrings(claims[s.config.GroupClaim]))
+	if role == "" {
+		return nil, fmt.Errorf("用户 %s 不属于任何已授权的用户组", username)
+	}
+
+	user, err := s.auth.store.GetUserByUsername(username)
+	if err != nil {
+		user = &models.User{
+			Username:  username,
+			Provider:  "oidc",
+			Subject:   subject,
+			CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
+		}
+	} else if user.Provider != "oidc" || user.Subject != subject {
+		return nil, fmt.Errorf("用户名 %s 已被其他账号使用", username)
+	}
+
+	user.Role = role
+	user.SettingIDs = settingIDs
+	if err := s.auth.store.SaveUser(user); err != nil {
+		return nil, fmt.Errorf("保存用户失败: %v", err)
+	}
+	return user, nil
+}
+
+// mapGroups 将用户组映射为角色（取最高角色）和数据库配置授权（取并集）
+func (s *OIDCService) mapGroups(groups []string) (string, []int) {
+	rank := map[string]int{models.RoleViewer: 1, models.RoleOperator: 2, models.RoleAdmin: 3}
+
+	role := ""
+	seen := make(map[int]bool)
+	var settingIDs []int
+	for _, group := range groups {
+		mapping, ok := s.config.GroupMapping[group]
+		if !ok {
+			continue
+		}
+		if rank[mapping.Role] > rank[role] {
+			role = mapping.Role
+		}
+		for _, id := range mapping.SettingIDs {
+			if !seen[id] {
+				seen[id] = true
+				settingIDs = append(settingIDs, id)
+			}
+		}
+	}
+
+	if role == "" {
+		role = s.config.DefaultRole
+	}
+	return role, settingIDs
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("格式错误")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("格式错误")
+	}
+	return nil
+}
+
+// verifyJWTSignature 校验 RS256 或 ES256 签名
+func verifyJWTSignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("密钥类型与算法不匹配")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("签名无效")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return fmt.Errorf("密钥类型与算法不匹配")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		sig := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, sig) {
+			return fmt.Errorf("签名无效")
+		}
+	default:
+		return fmt.Errorf("不支持的签名算法: %s", alg)
+	}
+	return nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	for _, a := range claimStrings(aud) {
+		if a == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// claimStrings 将字符串或字符串数组类型的声明转换为字符串切片
+func claimStrings(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		var result []string
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"mysql-backup/config"
+	"mysql-backup/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssuer 最小的 OpenID 身份提供方：记录最近一次授权请求的 PKCE challenge 和 nonce，
+// 令牌端点核对 code_verifier 后返回用 key 签名的 ID 令牌
+type fakeIssuer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey // 发布在 JWKS 中的密钥
+	signKey *rsa.PrivateKey // 签名 ID 令牌使用的密钥，默认与 key 相同
+
+	mu        sync.Mutex
+	challenge string
+	nonce     string
+	claims    map[string]interface{} // 覆盖 ID 令牌中的声明
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &fakeIssuer{key: key, signKey: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != issuer.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":                issuer.URL,
+			"aud":                "datasafe",
+			"sub":                "u-1",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              issuer.nonce,
+			"preferred_username": "alice",
+			"groups":             []string{"dba"},
+		}
+		for name, value := range issuer.claims {
+			claims[name] = value
+		}
+		token, err := signTestJWT(issuer.signKey, claims)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": token})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// authorize 模拟浏览器访问授权地址，返回 state
+func (f *fakeIssuer) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q", query.Get("code_challenge_method"))
+	}
+	f.mu.Lock()
+	f.challenge = query.Get("code_challenge")
+	f.nonce = query.Get("nonce")
+	f.mu.Unlock()
+	return query.Get("state")
+}
+
+func signTestJWT(key *rsa.PrivateKey, claims map[string]interface{}) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func newTestOIDCService(t *testing.T, issuer *fakeIssuer) *OIDCService {
+	t.Helper()
+	service, err := NewOIDCService(&config.OIDCConfig{
+		IssuerURL:    issuer.URL,
+		ClientID:     "datasafe",
+		ClientSecret: "secret",
+		RedirectURL:  "https://datasafe.example.com/auth/oidc/callback",
+		GroupMapping: map[string]config.OIDCGroupMapping{"dba": {Role: models.RoleOperator, SettingIDs: []int{1}}},
+	}, NewAuthService(newTestStore(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	service := newTestOIDCService(t, issuer)
+
+	authURL, state, err := service.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := issuer.authorize(t, authURL); got != state {
+		t.Fatalf("state in URL = %q, returned %q", got, state)
+	}
+
+	token, user, err := service.HandleCallback(state, "code", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" {
+		t.Fatal("empty session token")
+	}
+	if user.Username != "alice" || user.Provider != "oidc" || user.Role != models.RoleOperator {
+		t.Fatalf("user = %+v", user)
+	}
+
+	// state 只能使用一次
+	if _, _, err := service.HandleCallback(state, "code", "127.0.0.1", "test"); err == nil {
+		t.Fatal("state reused")
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(f *fakeIssuer)
+		claims map[string]interface{}
+		want   string
+	}{
+		{name: "nonce", claims: map[string]interface{}{"nonce": "other"}, want: "nonce 不匹配"},
+		{name: "audience", claims: map[string]interface{}{"aud": "other"}, want: "audience 不匹配"},
+		{name: "issuer", claims: map[string]interface{}{"iss": "https://evil.example.com"}, want: "issuer 不匹配"},
+		{name: "expired", claims: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}, want: "令牌已过期"},
+		{name: "signature", setup: func(f *fakeIssuer) { f.signKey = other }, want: "签名无效"},
+		{name: "group", claims: map[string]interface{}{"groups": []string{"guest"}}, want: "不属于任何已授权的用户组"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			issuer.claims = tt.claims
+			if tt.setup != nil {
+				tt.setup(issuer)
+			}
+			service := newTestOIDCService(t, issuer)
+
+			authURL, state, err := service.AuthCodeURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			issuer.authorize(t, authURL)
+			_, _, err = service.HandleCallback(state, "code", "127.0.0.1", "test")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackUnknownState(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	service := newTestOIDCService(t, issuer)
+
+	if _, _, err := service.HandleCallback("unknown", "code", "127.0.0.1", "test"); err == nil {
+		t.Fatal("unknown state accepted")
+	}
+}
+
+func TestOIDCCallbackPKCE(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	service := newTestOIDCService(t, issuer)
+
+	// 身份提供方记录的是第二次登录的 challenge，用第一次登录的 state 回调时 code_verifier 不匹配
+	first, state, err := service.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.authorize(t, first)
+	second, _, err := service.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.authorize(t, second)
+
+	_, _, err = service.HandleCallback(state, "code", "127.0.0.1", "test")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("err = %v, want invalid_grant", err)
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"time"
+)
+
+// IsPinned 判断备份当前是否处于固定状态，保留截止时间已过的固定视为已释放
+func IsPinned(record *models.BackupRecord, now time.Time) bool {
+	if !record.Pinned {
+		return false
+	}
+	if record.HoldUntil == "" {
+		return true
+	}
+	holdUntil, err := time.ParseInLocation("2006-01-02 15:04:05", record.HoldUntil, time.Local)
+	if err != nil {
+		// 无法解析的截止时间按一直保留处理
+		return true
+	}
+	return now.Before(holdUntil)
+}
+
+// parseHoldUntil 解析保留截止时间，只有日期时保留到当天结束
+func parseHoldUntil(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+		return t.Format("2006-01-02 15:04:05"), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return "", fmt.Errorf("无效的保留截止时间: %s", value)
+	}
+	return t.Add(24*time.Hour - time.Second).Format("2006-01-02 15:04:05"), nil
+}
+
+// PinBackup 固定备份，使其不受保留策略清理和手动删除影响
+func (s *BackupService) PinBackup(store storage.Store, id int, by string, req models.PinRequest) (*models.BackupRecord, error) {
+	holdUntil, err := parseHoldUntil(req.HoldUntil)
+	if err != nil {
+		return nil, err
+	}
+	if req.Reason == "" {
+		return nil, fmt.Errorf("固定备份必须填写原因")
+	}
+
+	record, err := store.GetBackupRecordByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != models.StatusCompleted {
+		return nil, fmt.Errorf("只能固定已完成的备份")
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	record.Pinned = true
+	record.PinReason = req.Reason
+	record.PinnedBy = by
+	record.PinnedAt = now
+	record.HoldUntil = holdUntil
+	record.PinHistory = append(record.PinHistory, models.PinEvent{
+		Action:    "pin",
+		By:        by,
+		At:        now,
+		Reason:    req.Reason,
+		HoldUntil: holdUntil,
+	})
+
+	if err := store.UpdateBackupRecord(record); err != nil {
+		return nil, fmt.Errorf("更新备份记录失败: %v", err)
+	}
+	return record, nil
+}
+
+// UnpinBackup 取消固定（释放保留），之后备份重新受保留策略管理
+func (s *BackupService) UnpinBackup(store storage.Store, id int, by, reason string) (*models.BackupRecord, error) {
+	record, err := store.GetBackupRecordByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !record.Pinned {
+		return record, nil
+	}
+
+	record.Pinned = false
+	record.PinReason = ""
+	record.PinnedBy = ""
+	record.PinnedAt = ""
+	record.HoldUntil = ""
+	record.PinHistory = append(record.PinHistory, models.PinEvent{
+		Action: "unpin",
+		By:     by,
+		At:     time.Now().Format("2006-01-02 15:04:05"),
+		Reason: reason,
+	})
+
+	if err := store.UpdateBackupRecord(record); err != nil {
+		return nil, fmt.Errorf("更新备份记录失败: %v", err)
+	}
+	return record, nil
+}
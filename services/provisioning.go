@@ -0,0 +1,438 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// AuditActorProvisioning 按声明式配置文件执行的修改使用的操作人
+const AuditActorProvisioning = "provisioning"
+
+// 声明式配置变更类型
+const (
+	ProvisionCreate = "create"
+	ProvisionUpdate = "update"
+	ProvisionDelete = "delete"
+)
+
+// ProvisioningFile 声明式配置文件，字段名与 API 中的数据库配置一致
+type ProvisioningFile struct {
+	Connections []ProvisionedConnection `json:"connections"`
+}
+
+// ProvisionedConnection 文件中的一个数据库连接，按名称与已保存的配置对应。
+// backupDir 为备份目标目录，retention 为保留策略
+type ProvisionedConnection struct {
+	models.DBSettings
+	Schedules []ProvisionedSchedule `json:"schedules,omitempty"`
+}
+
+// ProvisionedSchedule 连接下的定时备份任务
+type ProvisionedSchedule struct {
+	Database string `json:"database"`
+	Schedule string `json:"schedule"`      // 5字段 Cron 表达式
+	RPO      string `json:"rpo,omitempty"` // 恢复点目标，如 26h
+}
+
+// ProvisionChange 配置文件与已保存配置之间的一处差异
+type ProvisionChange struct {
+	Kind       string               `json:"kind"`   // "connection" 或 "schedule"
+	Action     string               `json:"action"` // "create"、"update" 或 "delete"
+	Name       string               `json:"name"`   // 连接名称，定时任务为 "连接名称/数据库 (表达式)"
+	SettingID  int                  `json:"settingId,omitempty"`
+	ScheduleID int                  `json:"scheduleId,omitempty"`
+	Changes    []models.AuditChange `json:"changes,omitempty"`
+
+	setting     *models.DBSettings
+	schedule    *ProvisionedSchedule
+	settingName string
+	takeover    bool // 接管未受管的定时任务，而不只是修改恢复点目标
+}
+
+// ProvisioningStatus 声明式配置的状态和当前差异
+type ProvisioningStatus struct {
+	Path          string             `json:"path"`
+	LastAppliedAt string             `json:"lastAppliedAt,omitempty"`
+	LastError     string             `json:"lastError,omitempty"`
+	InSync        bool               `json:"inSync"`
+	Drift         []*ProvisionChange `json:"drift"`
+}
+
+// ProvisioningService 按声明式配置文件维护数据库配置和定时任务。
+// 受管配置只能通过修改文件变更，文件中删除的受管配置和定时任务会被删除，未受管的配置不受影响
+type ProvisioningService struct {
+	path     string
+	store    storage.Store
+	backup   *BackupService
+	schedule *ScheduleService
+	audit    *AuditService
+
+	mu            sync.Mutex
+	lastAppliedAt string
+	lastError     string
+}
+
+func NewProvisioningService(path string, store storage.Store, backup *BackupService, schedule *ScheduleService, audit *AuditService) *ProvisioningService {
+	return &ProvisioningService{
+		path:     path,
+		store:    store,
+		backup:   backup,
+		schedule: schedule,
+		audit:    audit,
+	}
+}
+
+// LoadProvisioningFile 读取并校验声明式配置文件，支持 YAML、TOML 和 JSON
+func (s *ProvisioningService) LoadProvisioningFile() (*ProvisioningFile, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("读取声明式配置文件失败: %v", err)
+	}
+
+	// 先解析为通用结构再按 JSON 字段名解码，文件中的字段名与 API 保持一致
+	var raw interface{}
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("解析声明式配置文件失败: %v", err)
+		}
+	case ".toml":
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("解析声明式配置文件失败: %v", err)
+		}
+		raw = doc
+	case ".json":
+		raw = json.RawMessage(data)
+	default:
+		return nil, fmt.Errorf("不支持的声明式配置文件 %s，请使用 .yaml、.yml、.toml 或 .json", s.path)
+	}
+	// 空文件视为错误，避免误删所有受管配置；需要删除全部时写 connections: []
+	if raw == nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("声明式配置文件 %s 为空", s.path)
+	}
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("解析声明式配置文件失败: %v", err)
+	}
+
+	file := &ProvisioningFile{}
+	decoder := json.NewDecoder(bytes.NewReader(normalized))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(file); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("解析声明式配置文件失败: %v", err)
+	}
+	if err := s.validate(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// validate 校验文件中的连接和定时任务，并补全默认的备份目录
+func (s *ProvisioningService) validate(file *ProvisioningFile) error {
+	names := make(map[string]bool)
+	for i := range file.Connections {
+		conn := &file.Connections[i]
+		setting := &conn.DBSettings
+		if setting.Name == "" {
+			return fmt.Errorf("第 %d 个连接缺少名称", i+1)
+		}
+		if names[setting.Name] {
+			return fmt.Errorf("连接名称 %q 重复", setting.Name)
+		}
+		names[setting.Name] = true
+		if setting.ID != 0 || setting.Managed {
+			return fmt.Errorf("连接 %q: 不能设置 id 和 managed", setting.Name)
+		}
+
+		if setting.BackupDir == "" {
+			setting.BackupDir = s.backup.DefaultBackupDir(setting)
+		}
+		if setting.PasswordRef != "" {
+			if setting.Password != "" {
+				return fmt.Errorf("连接 %q: password 和 passwordRef 只能设置一个", setting.Name)
+			}
+			if err := ValidateSecretRef(setting.PasswordRef); err != nil {
+				return fmt.Errorf("连接 %q: %v", setting.Name, err)
+			}
+		}
+		if err := s.backup.ValidateSettings(setting); err != nil {
+			return fmt.Errorf("连接 %q: %v", setting.Name, err)
+		}
+		if err := ValidateTLSSettings(setting.TLS); err != nil {
+			return fmt.Errorf("连接 %q: %v", setting.Name, err)
+		}
+		if err := ValidateSSHTunnel(setting.SSH); err != nil {
+			return fmt.Errorf("连接 %q: %v", setting.Name, err)
+		}
+
+		schedules := make(map[ProvisionedSchedule]bool)
+		for _, schedule := range conn.Schedules {
+			if schedule.Database == "" || schedule.Schedule == "" {
+				return fmt.Errorf("连接 %q: 定时任务需要设置 database 和 schedule", setting.Name)
+			}
+			if _, err := cron.ParseStandard(schedule.Schedule); err != nil {
+				return fmt.Errorf("连接 %q: 无效的 Cron 表达式 %q: %v", setting.Name, schedule.Schedule, err)
+			}
+			if err := ValidateRPO(schedule.RPO); err != nil {
+				return fmt.Errorf("连接 %q: 定时任务 %s (%s): %v", setting.Name, schedule.Database, schedule.Schedule, err)
+			}
+			key := ProvisionedSchedule{Database: schedule.Database, Schedule: schedule.Schedule}
+			if schedules[key] {
+				return fmt.Errorf("连接 %q: 定时任务 %s (%s) 重复", setting.Name, schedule.Database, schedule.Schedule)
+			}
+			schedules[key] = true
+		}
+	}
+	return nil
+}
+
+// Plan 计算按文件调整需要执行的变更，不修改任何数据
+func (s *ProvisioningService) Plan(file *ProvisioningFile) ([]*ProvisionChange, error) {
+	settings, err := s.store.GetAllSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取配置失败: %v", err)
+	}
+	tasks, err := s.store.GetAllSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("获取定时任务失败: %v", err)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+
+	byName := make(map[string]*models.DBSettings)
+	for i := range settings {
+		byName[settings[i].Name] = &settings[i]
+	}
+	tasksBySetting := make(map[int][]*models.ScheduledTask)
+	for _, task := range tasks {
+		tasksBySetting[task.SettingID] = append(tasksBySetting[task.SettingID], task)
+	}
+
+	var changes []*ProvisionChange
+	wanted := make(map[string]bool)
+	for i := range file.Connections {
+		conn := &file.Connections[i]
+		desired := conn.DBSettings
+		desired.Managed = true
+		wanted[desired.Name] = true
+
+		existing := byName[desired.Name]
+		if existing == nil {
+			change := &ProvisionChange{Kind: "connection", Action: ProvisionCreate, Name: desired.Name, setting: &desired}
+			change.Changes = DiffChanges(nil, &desired)
+			changes = append(changes, change)
+			for j := range conn.Schedules {
+				changes = append(changes, scheduleChange(ProvisionCreate, desired.Name, 0, 0, &conn.Schedules[j]))
+			}
+			continue
+		}
+
+		// 同名的未受管配置会被接管
+		desired.ID = existing.ID
+		if diff := DiffChanges(existing, &desired); len(diff) > 0 {
+			changes = append(changes, &ProvisionChange{
+				Kind: "connection", Action: ProvisionUpdate, Name: desired.Name,
+				SettingID: existing.ID, Changes: diff, setting: &desired,
+			})
+		}
+
+		// 已存在的相同任务保留（未受管时接管），文件中删除的受管任务需要删除
+		matched := make(map[int]bool)
+		for j := range conn.Schedules {
+			schedule := &conn.Schedules[j]
+			var found *models.ScheduledTask
+			for _, task := range tasksBySetting[existing.ID] {
+				if !matched[task.ID] && task.Database == schedule.Database && task.Schedule == schedule.Schedule {
+					found = task
+					break
+				}
+			}
+			switch {
+			case found == nil:
+				changes = append(changes, scheduleChange(ProvisionCreate, desired.Name, existing.ID, 0, schedule))
+			case !found.Managed:
+				matched[found.ID] = true
+				change := scheduleChange(ProvisionUpdate, desired.Name, existing.ID, found.ID, schedule)
+				change.Changes = []models.AuditChange{{Field: "managed", Before: false, After: true}}
+				if found.RPO != schedule.RPO {
+					change.Changes = append(change.Changes, models.AuditChange{Field: "rpo", Before: found.RPO, After: schedule.RPO})
+				}
+				change.takeover = true
+				changes = append(changes, change)
+			case found.RPO != schedule.RPO:
+				matched[found.ID] = true
+				change := scheduleChange(ProvisionUpdate, desired.Name, existing.ID, found.ID, schedule)
+				change.Changes = []models.AuditChange{{Field: "rpo", Before: found.RPO, After: schedule.RPO}}
+				changes = append(changes, change)
+			default:
+				matched[found.ID] = true
+			}
+		}
+		for _, task := range tasksBySetting[existing.ID] {
+			if task.Managed && !matched[task.ID] {
+				changes = append(changes, scheduleChange(ProvisionDelete, desired.Name, existing.ID, task.ID,
+					&ProvisionedSchedule{Database: task.Database, Schedule: task.Schedule}))
+			}
+		}
+	}
+
+	// 文件中已删除的受管配置连同其定时任务一起删除
+	for i := range settings {
+		setting := &settings[i]
+		if !setting.Managed || wanted[setting.Name] {
+			continue
+		}
+		for _, task := range tasksBySetting[setting.ID] {
+			changes = append(changes, scheduleChange(ProvisionDelete, setting.Name, setting.ID, task.ID,
+				&ProvisionedSchedule{Database: task.Database, Schedule: task.Schedule}))
+		}
+		changes = append(changes, &ProvisionChange{
+			Kind: "connection", Action: ProvisionDelete, Name: setting.Name,
+			SettingID: setting.ID, Changes: DiffChanges(setting, nil), setting: setting,
+		})
+	}
+
+	return changes, nil
+}
+
+func scheduleChange(action, settingName string, settingID, scheduleID int, schedule *ProvisionedSchedule) *ProvisionChange {
+	return &ProvisionChange{
+		Kind:        "schedule",
+		Action:      action,
+		Name:        fmt.Sprintf("%s/%s (%s)", settingName, schedule.Database, schedule.Schedule),
+		SettingID:   settingID,
+		ScheduleID:  scheduleID,
+		schedule:    schedule,
+		settingName: settingName,
+	}
+}
+
+// Apply 读取配置文件并执行变更，返回已执行的变更。文件无效时不做任何修改
+func (s *ProvisioningService) Apply() ([]*ProvisionChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes, err := s.apply()
+	if err != nil {
+		s.lastError = err.Error()
+		return changes, err
+	}
+	s.lastError = ""
+	s.lastAppliedAt = time.Now().Format("2006-01-02 15:04:05")
+	return changes, nil
+}
+
+func (s *ProvisioningService) apply() ([]*ProvisionChange, error) {
+	file, err := s.LoadProvisioningFile()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := s.Plan(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// 新建的连接在保存后才有 ID，供后面的定时任务使用
+	createdIDs := make(map[string]int)
+	for i, change := range changes {
+		if err := s.applyChange(change, createdIDs); err != nil {
+			return changes[:i], fmt.Errorf("%s %s 失败: %v", change.Action, change.Name, err)
+		}
+	}
+	if len(changes) > 0 {
+		slog.Info("已按声明式配置文件执行变更", "path", s.path, "changes", len(changes))
+	}
+	return changes, nil
+}
+
+func (s *ProvisioningService) applyChange(change *ProvisionChange, createdIDs map[string]int) error {
+	if change.Kind == "connection" {
+		setting := change.setting
+		var err error
+		if change.Action == ProvisionDelete {
+			err = s.store.DeleteSettings(setting.ID)
+		} else {
+			err = s.store.SaveSettings(setting)
+			createdIDs[setting.Name] = setting.ID
+			change.SettingID = setting.ID
+		}
+		s.audit.Record(AuditActorProvisioning, "", "settings."+change.Action, fmt.Sprintf("setting:%d", setting.ID), setting.ID, change.Changes, err)
+		return err
+	}
+
+	if change.SettingID == 0 {
+		change.SettingID = createdIDs[change.settingName]
+	}
+	schedule := change.schedule
+	var err error
+	switch change.Action {
+	case ProvisionDelete:
+		err = s.schedule.RemoveTask(change.ScheduleID)
+	case ProvisionUpdate:
+		if !change.takeover {
+			err = s.schedule.SetRPO(change.ScheduleID, schedule.RPO)
+			break
+		}
+		// 接管未受管的任务：删除后重新添加为受管任务
+		if err = s.schedule.RemoveTask(change.ScheduleID); err == nil {
+			err = s.addManagedTask(change)
+		}
+	default:
+		err = s.addManagedTask(change)
+	}
+	record := models.ScheduledTask{ID: change.ScheduleID, SettingID: change.SettingID, Database: schedule.Database, Schedule: schedule.Schedule, Managed: true, RPO: schedule.RPO}
+	auditChanges := DiffChanges(nil, record)
+	switch {
+	case change.Action == ProvisionDelete:
+		auditChanges = DiffChanges(record, nil)
+	case change.Action == ProvisionUpdate && !change.takeover:
+		auditChanges = change.Changes
+	}
+	s.audit.Record(AuditActorProvisioning, "", "schedule."+change.Action, fmt.Sprintf("schedule:%d", change.ScheduleID), change.SettingID, auditChanges, err)
+	return err
+}
+
+func (s *ProvisioningService) addManagedTask(change *ProvisionChange) error {
+	setting, err := s.store.GetSettingByID(change.SettingID)
+	if err != nil {
+		return err
+	}
+	id, err := s.schedule.AddManagedTask(setting, change.schedule.Database, change.schedule.Schedule, change.schedule.RPO)
+	change.ScheduleID = id
+	return err
+}
+
+// Status 重新读取配置文件并返回与已保存配置之间的差异
+func (s *ProvisioningService) Status() *ProvisioningStatus {
+	s.mu.Lock()
+	status := &ProvisioningStatus{Path: s.path, LastAppliedAt: s.lastAppliedAt, LastError: s.lastError}
+	s.mu.Unlock()
+
+	file, err := s.LoadProvisioningFile()
+	if err == nil {
+		status.Drift, err = s.Plan(file)
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	if status.Drift == nil {
+		status.Drift = []*ProvisionChange{}
+	}
+	status.InSync = err == nil && len(status.Drift) == 0
+	return status
+}
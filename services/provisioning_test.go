@@ -0,0 +1,192 @@
+package services
+
+import (
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const provisioningProd = `
+connections:
+  - name: prod
+    host: 10.0.0.5
+    port: 3306
+    user: backup
+    backupDir: /var/backups/prod
+    schedules:
+      - database: shop
+        schedule: "0 2 * * *"
+        rpo: 26h
+`
+
+// newTestProvisioning 创建读取临时目录中 provisioning.yaml 的服务，返回写入文件内容的函数
+func newTestProvisioning(t *testing.T, store storage.Store) (*ProvisioningService, func(string)) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "provisioning.yaml")
+	backup, schedule := newTestScheduleService(t, store)
+	service := NewProvisioningService(path, store, backup, schedule, NewAuditService(store))
+	return service, func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// planSummary 返回变更列表，格式为 "动作 类型 名称"
+func planSummary(changes []*ProvisionChange) []string {
+	var summary []string
+	for _, change := range changes {
+		summary = append(summary, change.Action+" "+change.Kind+" "+change.Name)
+	}
+	return summary
+}
+
+func applyFile(t *testing.T, service *ProvisioningService, write func(string), content string) []string {
+	t.Helper()
+	write(content)
+	changes, err := service.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return planSummary(changes)
+}
+
+// assertInSync 检查执行后文件与已保存配置一致
+func assertInSync(t *testing.T, service *ProvisioningService) {
+	t.Helper()
+	if status := service.Status(); !status.InSync {
+		t.Fatalf("drift after apply: %v (%s)", planSummary(status.Drift), status.LastError)
+	}
+}
+
+func scheduleSummary(t *testing.T, store storage.Store) []string {
+	t.Helper()
+	tasks, err := store.GetAllSchedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary []string
+	for _, task := range tasks {
+		managed := "unmanaged"
+		if task.Managed {
+			managed = "managed"
+		}
+		summary = append(summary, strings.Join([]string{task.Database, task.Schedule, task.RPO, managed}, "|"))
+	}
+	sort.Strings(summary)
+	return summary
+}
+
+func assertStrings(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("%s:\n got %q\nwant %q", what, got, want)
+	}
+}
+
+func TestProvisioningCreateUpdateDelete(t *testing.T) {
+	store := newTestStore(t)
+	unmanaged := saveTestSetting(t, store, &models.DBSettings{Name: "local"}, "0 4 * * *")
+	service, write := newTestProvisioning(t, store)
+
+	// 创建
+	got := applyFile(t, service, write, provisioningProd)
+	assertStrings(t, "create", got, []string{"create connection prod", "create schedule prod/shop (0 2 * * *)"})
+	assertInSync(t, service)
+	prod := settingsByName(t, store)["prod"]
+	if !prod.Managed || prod.Host != "10.0.0.5" {
+		t.Fatalf("prod = %+v", prod)
+	}
+	assertStrings(t, "schedules after create", scheduleSummary(t, store), []string{"shop|0 2 * * *|26h|managed", "shop|0 4 * * *||unmanaged"})
+
+	// 修改连接和恢复点目标
+	got = applyFile(t, service, write, strings.NewReplacer("10.0.0.5", "10.0.0.6", "26h", "12h").Replace(provisioningProd))
+	assertStrings(t, "update", got, []string{"update connection prod", "update schedule prod/shop (0 2 * * *)"})
+	assertInSync(t, service)
+	if updated := settingsByName(t, store)["prod"]; updated.ID != prod.ID || updated.Host != "10.0.0.6" {
+		t.Fatalf("prod after update = %+v", updated)
+	}
+	assertStrings(t, "schedules after update", scheduleSummary(t, store), []string{"shop|0 2 * * *|12h|managed", "shop|0 4 * * *||unmanaged"})
+
+	// 未修改时没有变更
+	if got := applyFile(t, service, write, strings.NewReplacer("10.0.0.5", "10.0.0.6", "26h", "12h").Replace(provisioningProd)); len(got) != 0 {
+		t.Fatalf("unchanged file applied %v", got)
+	}
+
+	// 删除连接时一并删除其定时任务，未受管的配置不受影响
+	got = applyFile(t, service, write, "connections: []\n")
+	assertStrings(t, "delete", got, []string{"delete schedule prod/shop (0 2 * * *)", "delete connection prod"})
+	assertInSync(t, service)
+	settings := settingsByName(t, store)
+	if _, ok := settings["prod"]; ok || settings["local"].ID != unmanaged.ID {
+		t.Fatalf("settings after delete = %v", settings)
+	}
+	assertStrings(t, "schedules after delete", scheduleSummary(t, store), []string{"shop|0 4 * * *||unmanaged"})
+}
+
+func TestProvisioningTakeover(t *testing.T) {
+	store := newTestStore(t)
+	// 在界面中创建的同名配置，有一个与文件相同的任务和一个文件中没有的任务
+	existing := saveTestSetting(t, store, &models.DBSettings{Name: "prod", Host: "10.0.0.5", Port: 3306, User: "backup", BackupDir: "/var/backups/prod"},
+		"0 2 * * *", "0 5 * * *")
+	service, write := newTestProvisioning(t, store)
+
+	got := applyFile(t, service, write, provisioningProd)
+	assertStrings(t, "takeover", got, []string{"update connection prod", "update schedule prod/shop (0 2 * * *)"})
+	assertInSync(t, service)
+
+	prod := settingsByName(t, store)["prod"]
+	if prod.ID != existing.ID || !prod.Managed {
+		t.Fatalf("prod = %+v, want existing setting marked managed", prod)
+	}
+	assertStrings(t, "schedules", scheduleSummary(t, store), []string{"shop|0 2 * * *|26h|managed", "shop|0 5 * * *||unmanaged"})
+
+	// 文件中删除任务时只删除受管任务
+	got = applyFile(t, service, write, strings.Split(provisioningProd, "    schedules:")[0])
+	assertStrings(t, "remove schedule", got, []string{"delete schedule prod/shop (0 2 * * *)"})
+	assertStrings(t, "schedules after removal", scheduleSummary(t, store), []string{"shop|0 5 * * *||unmanaged"})
+}
+
+func TestProvisioningRejectsInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "empty", content: "", wantErr: "为空"},
+		{name: "whitespace", content: "\n  \n", wantErr: "为空"},
+		{name: "comment only", content: "# connections removed\n", wantErr: "为空"},
+		{name: "unknown field", content: "connections:\n  - name: prod\n    hots: 10.0.0.5\n", wantErr: "hots"},
+		{name: "duplicate name", content: provisioningProd + strings.SplitN(provisioningProd, "connections:\n", 2)[1], wantErr: "重复"},
+		{name: "invalid cron", content: strings.Replace(provisioningProd, "0 2 * * *", "every day", 1), wantErr: "无效的 Cron 表达式"},
+		{name: "invalid setting", content: strings.Replace(provisioningProd, "user: backup", "user: \"\"", 1), wantErr: "用户名不能为空"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			service, write := newTestProvisioning(t, store)
+			applyFile(t, service, write, provisioningProd)
+
+			write(tt.content)
+			changes, err := service.Apply()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if len(changes) != 0 {
+				t.Fatalf("invalid file applied %v", planSummary(changes))
+			}
+			if prod := settingsByName(t, store)["prod"]; !prod.Managed {
+				t.Fatal("managed setting removed by invalid file")
+			}
+			assertStrings(t, "schedules", scheduleSummary(t, store), []string{"shop|0 2 * * *|26h|managed"})
+			if status := service.Status(); status.InSync || !strings.Contains(status.LastError, tt.wantErr) {
+				t.Fatalf("status = %+v", status)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"fmt"
+	"mysql-backup/models"
+)
+
+// rolePermissions 各角色拥有的权限
+var rolePermissions = map[string][]string{
+	models.RoleViewer:   {models.PermView},
+	models.RoleOperator: {models.PermView, models.PermBackup, models.PermDownload},
+	models.RoleAdmin:    {models.PermView, models.PermBackup, models.PermDownload, models.PermManage, models.PermRestore},
+}
+
+// Principal 当前请求的身份及其权限
+type Principal struct {
+	Username    string
+	Role        string
+	Permissions []string
+	AllSettings bool  // 可访问所有数据库配置
+	SettingIDs  []int // AllSettings 为 false 时可访问的数据库配置
+}
+
+// ValidRole 判断角色是否有效
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// userRole 返回用户角色，早期版本创建的用户没有角色，按管理员处理
+func userRole(user *models.User) string {
+	if user.Role == "" {
+		return models.RoleAdmin
+	}
+	return user.Role
+}
+
+// PrincipalForUser 根据用户的角色和授权生成身份
+func PrincipalForUser(user *models.User) *Principal {
+	role := userRole(user)
+	return &Principal{
+		Username:    user.Username,
+		Role:        role,
+		Permissions: rolePermissions[role],
+		AllSettings: role == models.RoleAdmin,
+		SettingIDs:  user.SettingIDs,
+	}
+}
+
+// HasPermission 判断是否拥有权限（不考虑数据库配置范围）
+func (p *Principal) HasPermission(perm string) bool {
+	for _, granted := range p.Permissions {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessSetting 判断是否可以访问数据库配置
+func (p *Principal) CanAccessSetting(settingID int) bool {
+	if p.AllSettings {
+		return true
+	}
+	for _, id := range p.SettingIDs {
+		if id == settingID {
+			return true
+		}
+	}
+	return false
+}
+
+// Can 判断是否可以对数据库配置执行操作
+func (p *Principal) Can(perm string, settingID int) bool {
+	return p.HasPermission(perm) && p.CanAccessSetting(settingID)
+}
+
+// validateUserRequest 校验用户角色和授权
+func validateUserRequest(role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("无效的角色: %s", role)
+	}
+	return nil
+}
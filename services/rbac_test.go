@@ -0,0 +1,70 @@
+package services
+
+import (
+	"mysql-backup/models"
+	"testing"
+)
+
+func TestPrincipalCan(t *testing.T) {
+	const granted, other = 1, 2
+	allPerms := []string{models.PermView, models.PermBackup, models.PermDownload, models.PermManage, models.PermRestore}
+	want := map[string][]string{
+		models.RoleViewer:   {models.PermView},
+		models.RoleOperator: {models.PermView, models.PermBackup, models.PermDownload},
+		models.RoleAdmin:    allPerms,
+	}
+
+	for _, role := range []string{models.RoleViewer, models.RoleOperator, models.RoleAdmin} {
+		for _, scoped := range []bool{false, true} {
+			name := role + "/all"
+			principal := &Principal{Role: role, Permissions: rolePermissions[role], AllSettings: true}
+			if scoped {
+				name = role + "/scoped"
+				principal = &Principal{Role: role, Permissions: rolePermissions[role], SettingIDs: []int{granted}}
+			}
+			t.Run(name, func(t *testing.T) {
+				for _, perm := range allPerms {
+					has := containsString(want[role], perm)
+					if got := principal.Can(perm, granted); got != has {
+						t.Errorf("Can(%s, granted) = %v, want %v", perm, got, has)
+					}
+					if got := principal.Can(perm, other); got != (has && !scoped) {
+						t.Errorf("Can(%s, other) = %v, want %v", perm, got, has && !scoped)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPrincipalForUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		user        *models.User
+		role        string
+		allSettings bool
+	}{
+		{name: "legacy user is admin", user: &models.User{Username: "root"}, role: models.RoleAdmin, allSettings: true},
+		{name: "admin sees all settings", user: &models.User{Role: models.RoleAdmin, SettingIDs: []int{1}}, role: models.RoleAdmin, allSettings: true},
+		{name: "operator is scoped", user: &models.User{Role: models.RoleOperator, SettingIDs: []int{1}}, role: models.RoleOperator},
+		{name: "viewer without settings", user: &models.User{Role: models.RoleViewer}, role: models.RoleViewer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal := PrincipalForUser(tt.user)
+			if principal.Role != tt.role || principal.AllSettings != tt.allSettings {
+				t.Fatalf("principal = %+v", principal)
+			}
+			if !tt.allSettings && principal.CanAccessSetting(2) {
+				t.Fatal("scoped user can access an unassigned setting")
+			}
+		})
+	}
+}
+
+func TestEmptyPrincipalCannotDoAnything(t *testing.T) {
+	principal := &Principal{}
+	if principal.Can(models.PermView, 1) || principal.HasPermission(models.PermView) {
+		t.Fatal("empty principal has permissions")
+	}
+}
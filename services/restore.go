@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"mysql-backup/models"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// createTablePattern 匹配备份文件中的建表语句，用于恢复前删除同名表
+var createTablePattern = regexp.MustCompile("^CREATE TABLE `([^`]+)`")
+
+// maxDatabaseNameLen MySQL 数据库名的最大长度
+const maxDatabaseNameLen = 64
+
+// ValidateDatabaseName 检查恢复目标数据库名，名称会放在反引号中拼接到 SQL 语句
+func ValidateDatabaseName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("数据库名不能为空")
+	}
+	if len([]rune(name)) > maxDatabaseNameLen {
+		return fmt.Errorf("数据库名不能超过 %d 个字符", maxDatabaseNameLen)
+	}
+	if strings.ContainsAny(name, "`\x00") {
+		return fmt.Errorf("数据库名不能包含反引号或空字符")
+	}
+	return nil
+}
+
+// RestoreBackup 将备份文件恢复到数据库，targetDB 为空时恢复到原数据库。
+// 恢复属于管理员操作，调用方需要先校验 restore 权限
+func (s *BackupService) RestoreBackup(setting *models.DBSettings, record *models.BackupRecord, targetDB string, dropExisting bool) error {
+	if record.Status != models.StatusCompleted {
+		return fmt.Errorf("只能恢复已完成的备份")
+	}
+	if targetDB == "" {
+		targetDB = record.DBName
+	}
+	if err := ValidateDatabaseName(targetDB); err != nil {
+		return err
+	}
+
+	done, err := s.startJob()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	file, err := os.Open(filepath.Join(setting.BackupDir, record.FileName))
+	if err != nil {
+		return fmt.Errorf("打开备份文件失败: %v", err)
+	}
+	defer file.Close()
+
+	// 与备份任务共用任务数量限制
+	release, err := s.acquireWorker()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	db, err := openDB(setting, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// 使用同一个连接执行，保证 USE 和会话变量生效。服务停止时取消，恢复到一半的数据库需要重新恢复
+	ctx := s.ctx
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("连接数据库失败: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS=0"); err != nil {
+		return fmt.Errorf("设置会话变量失败: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", targetDB)); err != nil {
+		return fmt.Errorf("创建数据库失败: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("USE `%s`", targetDB)); err != nil {
+		return fmt.Errorf("切换数据库失败: %v", err)
+	}
+
+	// 备份文件中每条语句以行尾分号结束，建表语句可能跨多行
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	var stmt strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if stmt.Len() == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+		stmt.WriteString(line)
+		stmt.WriteString("\n")
+		if !strings.HasSuffix(strings.TrimSpace(line), ";") {
+			continue
+		}
+
+		query := strings.TrimSpace(stmt.String())
+		stmt.Reset()
+
+		// 数据库由 targetDB 决定，跳过备份文件中的建库和切换语句
+		if strings.HasPrefix(query, "CREATE DATABASE ") || strings.HasPrefix(query, "USE ") {
+			continue
+		}
+		if dropExisting {
+			if m := createTablePattern.FindStringSubmatch(query); m != nil {
+				if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS `%s`", m[1])); err != nil {
+					return fmt.Errorf("删除表 %s 失败: %v", m[1], err)
+				}
+			}
+		}
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("执行恢复语句失败: %v", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取备份文件失败: %v", err)
+	}
+
+	return nil
+}
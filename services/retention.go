@@ -0,0 +1,194 @@
+package services
+
+import (
+	"fmt"
+	"mysql-backup/models"
+	"sort"
+	"time"
+)
+
+// RetentionDecision 单个备份在保留策略下的处理结果
+type RetentionDecision struct {
+	Record  *models.BackupRecord `json:"record"`
+	Keep    bool                 `json:"keep"`
+	Reasons []string             `json:"reasons"` // 保留或删除的原因
+}
+
+// effectivePolicy 返回配置实际生效的保留策略，未设置策略时退化为 MaxBackups
+func effectivePolicy(setting *models.DBSettings) *models.RetentionPolicy {
+	if setting.Retention != nil {
+		return setting.Retention
+	}
+	if setting.MaxBackups > 0 {
+		return &models.RetentionPolicy{KeepLast: setting.MaxBackups}
+	}
+	return nil
+}
+
+// hasKeepRules 判断策略中是否配置了任何 keep-* 规则
+func hasKeepRules(policy *models.RetentionPolicy) bool {
+	return policy.KeepLast > 0 || policy.KeepDaily > 0 || policy.KeepWeekly > 0 ||
+		policy.KeepMonthly > 0 || policy.KeepYearly > 0
+}
+
+// parseRecordTime 解析备份记录的创建时间
+func parseRecordTime(record *models.BackupRecord) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", record.CreatedAt, time.Local)
+}
+
+// EvaluateRetention 按保留策略计算一组备份记录（同一配置、同一数据库）中哪些需要保留。
+// 只有已完成的备份参与计算；最新的一份备份始终保留；固定的备份始终保留且不占用 keep 规则的名额。
+func EvaluateRetention(policy *models.RetentionPolicy, records []*models.BackupRecord, now time.Time) []*RetentionDecision {
+	type item struct {
+		decision *RetentionDecision
+		created  time.Time
+	}
+
+	var items []item
+	var pinned []*RetentionDecision
+	for _, record := range records {
+		if record.Status != models.StatusCompleted {
+			continue
+		}
+		if IsPinned(record, now) {
+			pinned = append(pinned, &RetentionDecision{Record: record, Keep: true, Reasons: []string{"已固定"}})
+			continue
+		}
+		created, err := parseRecordTime(record)
+		if err != nil {
+			// 无法解析时间的记录不参与清理
+			continue
+		}
+		items = append(items, item{decision: &RetentionDecision{Record: record}, created: created})
+	}
+
+	// 按时间倒序排序，最新的在前
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].created.After(items[j].created)
+	})
+
+	if policy == nil {
+		for _, it := range items {
+			it.decision.Keep = true
+			it.decision.Reasons = append(it.decision.Reasons, "未设置保留策略")
+		}
+	} else {
+		keep := func(it item, reason string) {
+			it.decision.Keep = true
+			it.decision.Reasons = append(it.decision.Reasons, reason)
+		}
+
+		if !hasKeepRules(policy) {
+			for _, it := range items {
+				keep(it, "未设置 keep 规则")
+			}
+		}
+
+		for i, it := range items {
+			if i < policy.KeepLast {
+				keep(it, "keep-last")
+			}
+		}
+
+		buckets := []struct {
+			name  string
+			count int
+			key   func(t time.Time) string
+		}{
+			{"keep-daily", policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+			{"keep-weekly", policy.KeepWeekly, func(t time.Time) string {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%d-%02d", year, week)
+			}},
+			{"keep-monthly", policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+			{"keep-yearly", policy.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+		}
+		for _, bucket := range buckets {
+			if bucket.count <= 0 {
+				continue
+			}
+			last := ""
+			kept := 0
+			for _, it := range items {
+				if kept >= bucket.count {
+					break
+				}
+				key := bucket.key(it.created)
+				if key == last {
+					continue
+				}
+				last = key
+				kept++
+				keep(it, bucket.name)
+			}
+		}
+
+		// 超过最大保留天数的备份删除
+		if policy.MaxAgeDays > 0 {
+			cutoff := now.AddDate(0, 0, -policy.MaxAgeDays)
+			for _, it := range items {
+				if it.decision.Keep && it.created.Before(cutoff) {
+					it.decision.Keep = false
+					it.decision.Reasons = append(it.decision.Reasons, fmt.Sprintf("超过最大保留天数 %d", policy.MaxAgeDays))
+				}
+			}
+		}
+
+		// 保留的备份总大小超过上限时，从最旧的开始删除
+		if policy.MaxTotalSizeMB > 0 {
+			limit := policy.MaxTotalSizeMB * 1024 * 1024
+			var total int64
+			for _, it := range items {
+				if !it.decision.Keep {
+					continue
+				}
+				total += it.decision.Record.Size
+				if total > limit {
+					it.decision.Keep = false
+					it.decision.Reasons = append(it.decision.Reasons, fmt.Sprintf("超过总大小上限 %dMB", policy.MaxTotalSizeMB))
+				}
+			}
+		}
+	}
+
+	// 最新的一份备份始终保留
+	if len(items) > 0 && !items[0].decision.Keep {
+		items[0].decision.Keep = true
+		items[0].decision.Reasons = append(items[0].decision.Reasons, "最新备份")
+	}
+
+	decisions := make([]*RetentionDecision, 0, len(items)+len(pinned))
+	decisions = append(decisions, pinned...)
+	for _, it := range items {
+		if !it.decision.Keep && len(it.decision.Reasons) == 0 {
+			it.decision.Reasons = append(it.decision.Reasons, "不匹配任何 keep 规则")
+		}
+		decisions = append(decisions, it.decision)
+	}
+	return decisions
+}
+
+// PreviewRetention 预览保留策略，返回每个数据库的备份处理结果。
+// database 为空时预览该配置下的所有数据库；policy 为空时使用配置中保存的策略。
+func (s *BackupService) PreviewRetention(setting *models.DBSettings, database string, policy *models.RetentionPolicy, records []*models.BackupRecord) map[string][]*RetentionDecision {
+	if policy == nil {
+		policy = effectivePolicy(setting)
+	}
+
+	grouped := make(map[string][]*models.BackupRecord)
+	for _, record := range records {
+		if record.SettingID != setting.ID {
+			continue
+		}
+		if database != "" && record.DBName != database {
+			continue
+		}
+		grouped[record.DBName] = append(grouped[record.DBName], record)
+	}
+
+	result := make(map[string][]*RetentionDecision)
+	for dbName, dbRecords := range grouped {
+		result[dbName] = EvaluateRetention(policy, dbRecords, time.Now())
+	}
+	return result
+}
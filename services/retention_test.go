@@ -0,0 +1,239 @@
+package services
+
+import (
+	"mysql-backup/models"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMB = 1024 * 1024
+
+// retentionRecord 已完成的备份记录，created 格式为 2006-01-02 15:04
+func retentionRecord(id int, created string, size int64) *models.BackupRecord {
+	return &models.BackupRecord{ID: id, DBName: "shop", CreatedAt: created + ":00", Status: models.StatusCompleted, Size: size}
+}
+
+func pinnedRecord(id int, created, holdUntil string) *models.BackupRecord {
+	record := retentionRecord(id, created, 0)
+	record.Pinned = true
+	record.HoldUntil = holdUntil
+	return record
+}
+
+func keptIDs(decisions []*RetentionDecision) []int {
+	ids := []int{}
+	for _, decision := range decisions {
+		if decision.Keep {
+			ids = append(ids, decision.Record.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestEvaluateRetention(t *testing.T) {
+	now, _ := time.ParseInLocation("2006-01-02 15:04:05", "2025-01-10 12:00:00", time.Local)
+
+	tests := []struct {
+		name    string
+		policy  *models.RetentionPolicy
+		records []*models.BackupRecord
+		want    []int
+	}{
+		{
+			name:   "no policy keeps everything",
+			policy: nil,
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2024-01-01 00:00", 0),
+				retentionRecord(2, "2025-01-01 00:00", 0),
+			},
+			want: []int{1, 2},
+		},
+		{
+			name:   "keep-last",
+			policy: &models.RetentionPolicy{KeepLast: 2},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2025-01-06 02:00", 0),
+				retentionRecord(4, "2025-01-09 02:00", 0),
+				retentionRecord(2, "2025-01-07 02:00", 0),
+				retentionRecord(3, "2025-01-08 02:00", 0),
+			},
+			want: []int{3, 4},
+		},
+		{
+			name:   "keep-daily keeps the newest of each day",
+			policy: &models.RetentionPolicy{KeepDaily: 2},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2025-01-08 10:00", 0),
+				retentionRecord(2, "2025-01-09 09:00", 0),
+				retentionRecord(3, "2025-01-09 18:00", 0),
+				retentionRecord(4, "2025-01-10 08:00", 0),
+			},
+			want: []int{3, 4},
+		},
+		{
+			// 2024-12-30 属于 ISO 周 2025-W01，2024-12-29 属于 2024-W52
+			name:   "keep-weekly across ISO year boundary",
+			policy: &models.RetentionPolicy{KeepWeekly: 2},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2024-12-29 12:00", 0),
+				retentionRecord(2, "2024-12-30 12:00", 0),
+				retentionRecord(3, "2025-01-05 12:00", 0),
+			},
+			want: []int{1, 3},
+		},
+		{
+			name:   "keep-monthly",
+			policy: &models.RetentionPolicy{KeepMonthly: 2},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2024-11-15 02:00", 0),
+				retentionRecord(2, "2024-12-01 02:00", 0),
+				retentionRecord(3, "2024-12-20 02:00", 0),
+				retentionRecord(4, "2025-01-05 02:00", 0),
+			},
+			want: []int{3, 4},
+		},
+		{
+			name:   "keep-yearly",
+			policy: &models.RetentionPolicy{KeepYearly: 2},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2023-06-01 02:00", 0),
+				retentionRecord(2, "2024-03-01 02:00", 0),
+				retentionRecord(3, "2024-12-31 02:00", 0),
+				retentionRecord(4, "2025-01-02 02:00", 0),
+			},
+			want: []int{3, 4},
+		},
+		{
+			name:   "rules are combined",
+			policy: &models.RetentionPolicy{KeepLast: 1, KeepMonthly: 2},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2024-12-10 02:00", 0),
+				retentionRecord(2, "2024-12-20 02:00", 0),
+				retentionRecord(3, "2025-01-08 02:00", 0),
+				retentionRecord(4, "2025-01-09 02:00", 0),
+			},
+			want: []int{2, 4},
+		},
+		{
+			name:   "max age overrides keep rules",
+			policy: &models.RetentionPolicy{KeepLast: 3, MaxAgeDays: 5},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2025-01-01 02:00", 0),
+				retentionRecord(2, "2025-01-03 02:00", 0),
+				retentionRecord(3, "2025-01-08 02:00", 0),
+			},
+			want: []int{3},
+		},
+		{
+			name:   "max total size drops the oldest",
+			policy: &models.RetentionPolicy{KeepLast: 4, MaxTotalSizeMB: 2},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2025-01-06 02:00", testMB),
+				retentionRecord(2, "2025-01-07 02:00", testMB),
+				retentionRecord(3, "2025-01-08 02:00", testMB),
+				retentionRecord(4, "2025-01-09 02:00", testMB),
+			},
+			want: []int{3, 4},
+		},
+		{
+			name:   "pinned records do not consume keep slots",
+			policy: &models.RetentionPolicy{KeepLast: 1},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2025-01-06 02:00", 0),
+				retentionRecord(2, "2025-01-07 02:00", 0),
+				pinnedRecord(3, "2025-01-08 02:00", ""),
+				pinnedRecord(4, "2025-01-09 02:00", ""),
+			},
+			want: []int{2, 3, 4},
+		},
+		{
+			name:   "expired hold is pruned like any other backup",
+			policy: &models.RetentionPolicy{KeepLast: 1},
+			records: []*models.BackupRecord{
+				pinnedRecord(1, "2025-01-06 02:00", "2025-01-09 00:00:00"),
+				pinnedRecord(2, "2025-01-07 02:00", "2025-02-01 00:00:00"),
+				retentionRecord(3, "2025-01-08 02:00", 0),
+				retentionRecord(4, "2025-01-09 02:00", 0),
+			},
+			want: []int{2, 4},
+		},
+		{
+			name:   "newest backup is always kept",
+			policy: &models.RetentionPolicy{MaxAgeDays: 1},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2024-12-01 02:00", 0),
+				retentionRecord(2, "2024-12-02 02:00", 0),
+			},
+			want: []int{2},
+		},
+		{
+			name:   "unfinished backups are ignored",
+			policy: &models.RetentionPolicy{KeepLast: 1},
+			records: []*models.BackupRecord{
+				retentionRecord(1, "2025-01-08 02:00", 0),
+				{ID: 2, CreatedAt: "2025-01-09 02:00:00", Status: models.StatusFailed},
+				{ID: 3, CreatedAt: "2025-01-10 02:00:00", Status: models.StatusInProgress},
+			},
+			want: []int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := EvaluateRetention(tt.policy, tt.records, now)
+			if got := keptIDs(decisions); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("kept = %v, want %v", got, tt.want)
+			}
+			for _, decision := range decisions {
+				if decision.Record.Status != models.StatusCompleted {
+					t.Fatalf("decision for %s record %d", decision.Record.Status, decision.Record.ID)
+				}
+				if len(decision.Reasons) == 0 {
+					t.Fatalf("record %d has no reason", decision.Record.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateRetentionReasons(t *testing.T) {
+	now, _ := time.ParseInLocation("2006-01-02 15:04:05", "2025-01-10 12:00:00", time.Local)
+	decisions := EvaluateRetention(&models.RetentionPolicy{MaxAgeDays: 1}, []*models.BackupRecord{
+		retentionRecord(1, "2024-12-01 02:00", 0),
+		retentionRecord(2, "2024-12-02 02:00", 0),
+	}, now)
+
+	reasons := make(map[int]string)
+	for _, decision := range decisions {
+		reasons[decision.Record.ID] = strings.Join(decision.Reasons, ",")
+	}
+	if !strings.Contains(reasons[2], "最新备份") {
+		t.Errorf("newest reasons = %q", reasons[2])
+	}
+	if !strings.Contains(reasons[1], "超过最大保留天数 1") {
+		t.Errorf("oldest reasons = %q", reasons[1])
+	}
+}
+
+func TestEffectivePolicy(t *testing.T) {
+	policy := &models.RetentionPolicy{KeepDaily: 7}
+	tests := []struct {
+		name    string
+		setting models.DBSettings
+		want    *models.RetentionPolicy
+	}{
+		{name: "retention wins", setting: models.DBSettings{MaxBackups: 3, Retention: policy}, want: policy},
+		{name: "max backups fallback", setting: models.DBSettings{MaxBackups: 3}, want: &models.RetentionPolicy{KeepLast: 3}},
+		{name: "unlimited", setting: models.DBSettings{}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := effectivePolicy(&tt.setting); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("policy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"mysql-backup/models"
 	"mysql-backup/storage"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/robfig/cron/v3"
@@ -16,6 +18,9 @@ type ScheduledTask struct {
 	SettingID int    `json:"settingId"`
 	Database  string `json:"database"`
 	Schedule  string `json:"schedule"`
+	Managed   bool   `json:"managed"`
+	RPO       string `json:"rpo,omitempty"`
+	CreatedAt string `json:"createdAt,omitempty"`
 	EntryID   cron.EntryID
 }
 
@@ -24,29 +29,49 @@ type ScheduleService struct {
 	tasks     map[int]*ScheduledTask
 	backup    *BackupService
 	store     storage.Store
+	audit     *AuditService
 	lastID    int
 	taskMutex sync.RWMutex
+	running   atomic.Bool // 调度器是否在运行，用于就绪检查
 }
 
-func NewScheduleService(c *cron.Cron, backup *BackupService, store storage.Store) *ScheduleService {
+func NewScheduleService(c *cron.Cron, backup *BackupService, store storage.Store, audit *AuditService) *ScheduleService {
 	s := &ScheduleService{
 		cron:   c,
 		tasks:  make(map[int]*ScheduledTask),
 		backup: backup,
 		store:  store,
+		audit:  audit,
 	}
 
 	// 从存储中恢复定时任务
 	if err := s.restoreSchedules(); err != nil {
-		log.Printf("恢复定时任务失败: %v", err)
+		slog.Error("恢复定时任务失败", "error", err)
 	}
 
 	// 确保 cron 已经启动
-	s.cron.Start()
+	s.Start()
 
 	return s
 }
 
+// Start 启动调度器，已经启动时不会重复启动
+func (s *ScheduleService) Start() {
+	s.cron.Start()
+	s.running.Store(true)
+}
+
+// Stop 停止调度器，不再触发新的任务。返回的 context 在正在执行的任务结束后关闭
+func (s *ScheduleService) Stop() context.Context {
+	s.running.Store(false)
+	return s.cron.Stop()
+}
+
+// Running 调度器是否在运行
+func (s *ScheduleService) Running() bool {
+	return s.running.Load()
+}
+
 // 添加恢复定时任务的方法
 func (s *ScheduleService) restoreSchedules() error {
 	tasks, err := s.store.GetAllSchedules()
@@ -55,35 +80,26 @@ func (s *ScheduleService) restoreSchedules() error {
 	}
 
 	for _, task := range tasks {
-		setting, err := s.store.GetSettingByID(task.SettingID)
-		if err != nil {
-			log.Printf("获取任务 %d 的数据库配置失败: %v", task.ID, err)
+		if _, err := s.store.GetSettingByID(task.SettingID); err != nil {
+			slog.Error("获取定时任务的数据库配置失败", "schedule", task.ID, "error", err)
 			continue
 		}
+		// 早期版本保存的定时任务没有创建时间，以第一次恢复的时间为准并保存
+		if task.CreatedAt == "" {
+			task.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+			if err := s.store.SaveSchedule(task); err != nil {
+				slog.Warn("保存定时任务创建时间失败", "schedule", task.ID, "error", err)
+			}
+		}
 
 		// 恢复时也需要添加秒字段
 		cronExpr := "0 " + task.Schedule
 		entryID, err := s.cron.AddFunc(cronExpr, func() {
-			if err := s.backup.BackupDatabaseWithConfig(setting, task.Database, s.store); err != nil {
-				log.Printf("定时备份失败 [%s]: %v\n", task.Database, err)
-				return
-			}
-
-			record := &models.BackupRecord{
-				SettingID: setting.ID,
-				DBName:    task.Database,
-				FileName:  fmt.Sprintf("%s_%s.sql", task.Database, time.Now().Format("20060102150405")),
-				CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
-				Status:    "completed",
-			}
-			if err := s.store.SaveBackupRecord(record); err != nil {
-				log.Printf("保存备份记录失败: %v", err)
-			}
-			log.Printf("定时备份完成: %s", task.Database)
+			s.runScheduledBackup(task.ID, task.SettingID, task.Database)
 		})
 
 		if err != nil {
-			log.Printf("恢复任务 %d 失败: %v", task.ID, err)
+			slog.Error("恢复定时任务失败", "schedule", task.ID, "error", err)
 			continue
 		}
 
@@ -92,15 +108,40 @@ func (s *ScheduleService) restoreSchedules() error {
 			SettingID: task.SettingID,
 			Database:  task.Database,
 			Schedule:  task.Schedule,
+			Managed:   task.Managed,
+			RPO:       task.RPO,
+			CreatedAt: task.CreatedAt,
 			EntryID:   entryID,
 		}
-		log.Printf("成功恢复定时任务: [ID=%d] %s (%s)", task.ID, task.Database, task.Schedule)
+		slog.Info("成功恢复定时任务", "schedule", task.ID, "database", task.Database, "cron", task.Schedule)
 	}
 
 	return nil
 }
 
-func (s *ScheduleService) AddTaskWithConfig(setting *models.DBSettings, database, schedule string) (int, error) {
+// Reload 按存储中的定时任务重新注册所有任务，用于导入配置等直接修改存储之后
+func (s *ScheduleService) Reload() error {
+	s.taskMutex.Lock()
+	defer s.taskMutex.Unlock()
+
+	for id, task := range s.tasks {
+		s.cron.Remove(task.EntryID)
+		delete(s.tasks, id)
+	}
+	return s.restoreSchedules()
+}
+
+// AddTaskWithConfig 添加定时任务，rpo 为恢复点目标，为空表示不检查
+func (s *ScheduleService) AddTaskWithConfig(setting *models.DBSettings, database, schedule, rpo string) (int, error) {
+	return s.addTask(setting, database, schedule, rpo, false)
+}
+
+// AddManagedTask 添加由声明式配置文件维护的定时任务
+func (s *ScheduleService) AddManagedTask(setting *models.DBSettings, database, schedule, rpo string) (int, error) {
+	return s.addTask(setting, database, schedule, rpo, true)
+}
+
+func (s *ScheduleService) addTask(setting *models.DBSettings, database, schedule, rpo string, managed bool) (int, error) {
 	s.taskMutex.Lock()
 	defer s.taskMutex.Unlock()
 
@@ -108,12 +149,18 @@ func (s *ScheduleService) AddTaskWithConfig(setting *models.DBSettings, database
 	if _, err := cron.ParseStandard(schedule); err != nil {
 		return 0, fmt.Errorf("无效的 Cron 表达式: %v", err)
 	}
+	if err := ValidateRPO(rpo); err != nil {
+		return 0, err
+	}
 
 	// 创建任务记录 (存储时使用5字段格式)
 	task := &models.ScheduledTask{
 		SettingID: setting.ID,
 		Database:  database,
 		Schedule:  schedule,
+		Managed:   managed,
+		RPO:       rpo,
+		CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
 	}
 
 	// 保存到存储
@@ -124,24 +171,7 @@ func (s *ScheduleService) AddTaskWithConfig(setting *models.DBSettings, database
 	// 添加到 cron (添加秒字段)
 	cronExpr := "0 " + schedule // 添加秒字段
 	entryID, err := s.cron.AddFunc(cronExpr, func() {
-		log.Printf("开始执行定时备份任务: %s", database)
-		if err := s.backup.BackupDatabaseWithConfig(setting, database, s.store); err != nil {
-			log.Printf("定时备份失败 [%s]: %v\n", database, err)
-			return
-		}
-
-		// 记录备份历史
-		record := &models.BackupRecord{
-			SettingID: setting.ID,
-			DBName:    database,
-			FileName:  fmt.Sprintf("%s_%s.sql", database, time.Now().Format("20060102150405")),
-			CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
-			Status:    "completed",
-		}
-		if err := s.store.SaveBackupRecord(record); err != nil {
-			log.Printf("保存备份记录失败: %v", err)
-		}
-		log.Printf("定时备份完成: %s", database)
+		s.runScheduledBackup(task.ID, setting.ID, database)
 	})
 
 	if err != nil {
@@ -156,13 +186,62 @@ func (s *ScheduleService) AddTaskWithConfig(setting *models.DBSettings, database
 		SettingID: setting.ID,
 		Database:  database,
 		Schedule:  schedule,
+		Managed:   managed,
+		RPO:       rpo,
+		CreatedAt: task.CreatedAt,
 		EntryID:   entryID,
 	}
 
-	log.Printf("成功添加定时任务: [ID=%d] %s (%s)", task.ID, database, schedule)
+	slog.Info("成功添加定时任务", "schedule", task.ID, "database", database, "cron", schedule)
 	return task.ID, nil
 }
 
+// runScheduledBackup 执行定时备份并记录审计日志。每次执行时重新读取数据库配置，
+// 配置修改后不需要重新添加定时任务
+func (s *ScheduleService) runScheduledBackup(taskID, settingID int, database string) {
+	slog.Info("开始执行定时备份任务", "schedule", taskID, "database", database)
+	setting, err := s.store.GetSettingByID(settingID)
+	if err != nil {
+		s.audit.Record(AuditActorScheduler, "", "backup.create", fmt.Sprintf("schedule:%d/database:%s", taskID, database), settingID, nil, err)
+		slog.Error("定时备份失败：获取数据库配置失败", "schedule", taskID, "database", database, "error", err)
+		s.backup.Events().Publish(&models.Event{
+			Type:       models.EventBackupFailed,
+			SettingID:  settingID,
+			Database:   database,
+			ScheduleID: taskID,
+			Error:      err.Error(),
+			Message:    fmt.Sprintf("定时备份失败 %s: 获取数据库配置失败", database),
+		})
+		return
+	}
+	// 备份记录由 BackupScheduled 负责保存
+	_, err = s.backup.BackupScheduled(setting, database, s.store, taskID)
+	s.audit.Record(AuditActorScheduler, "", "backup.create", fmt.Sprintf("schedule:%d/database:%s", taskID, database), setting.ID, nil, err)
+	if err != nil {
+		slog.Error("定时备份失败", "schedule", taskID, "database", database, "error", err)
+		return
+	}
+	slog.Info("定时备份完成", "schedule", taskID, "database", database)
+}
+
+// StartReconcileJob 按 cron 表达式（5字段）定期对账备份目录与备份记录，只报告不修改
+func (s *ScheduleService) StartReconcileJob(schedule string) error {
+	_, err := s.cron.AddFunc("0 "+schedule, func() {
+		report, err := s.backup.Reconcile(s.store, ReconcileOptions{})
+		if err != nil {
+			slog.Error("备份对账失败", "error", err)
+			return
+		}
+		if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+			slog.Warn("备份对账发现不一致", "orphanFiles", len(report.OrphanFiles), "missingFiles", len(report.MissingFiles))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("添加对账任务失败: %v", err)
+	}
+	return nil
+}
+
 func (s *ScheduleService) RemoveTask(id int) error {
 	s.taskMutex.Lock()
 	defer s.taskMutex.Unlock()
@@ -179,6 +258,34 @@ func (s *ScheduleService) RemoveTask(id int) error {
 	return s.store.DeleteSchedule(id)
 }
 
+// SetRPO 修改定时任务的恢复点目标，为空表示不检查
+func (s *ScheduleService) SetRPO(id int, rpo string) error {
+	if err := ValidateRPO(rpo); err != nil {
+		return err
+	}
+
+	s.taskMutex.Lock()
+	defer s.taskMutex.Unlock()
+
+	task, exists := s.tasks[id]
+	if !exists {
+		return fmt.Errorf("task not found: %d", id)
+	}
+	if err := s.store.SaveSchedule(&models.ScheduledTask{
+		ID:        task.ID,
+		SettingID: task.SettingID,
+		Database:  task.Database,
+		Schedule:  task.Schedule,
+		Managed:   task.Managed,
+		RPO:       rpo,
+		CreatedAt: task.CreatedAt,
+	}); err != nil {
+		return fmt.Errorf("保存定时任务失败: %v", err)
+	}
+	task.RPO = rpo
+	return nil
+}
+
 func (s *ScheduleService) ListTasks() []ScheduledTask {
 	s.taskMutex.RLock()
 	defer s.taskMutex.RUnlock()
@@ -190,6 +297,11 @@ func (s *ScheduleService) ListTasks() []ScheduledTask {
 	return tasks
 }
 
+// NextRun 返回定时任务下一次执行的时间，cron 尚未启动时为零值
+func (s *ScheduleService) NextRun(task ScheduledTask) time.Time {
+	return s.cron.Entry(task.EntryID).Next
+}
+
 // ListTasksWithPage 分页获取定时任务列表
 func (s *ScheduleService) ListTasksWithPage(page, pageSize int) (int, []ScheduledTask) {
 	s.taskMutex.RLock()
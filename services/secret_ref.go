@@ -0,0 +1,152 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"mysql-backup/models"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 密码引用的类型
+const (
+	secretRefEnv   = "env:"   // env:MYSQL_PROD_PW
+	secretRefFile  = "file:"  // file:/run/secrets/prod
+	secretRefVault = "vault:" // vault:secret/data/mysql/prod#password
+)
+
+// secretHTTPClient 访问密钥管理服务使用的 HTTP 客户端
+var secretHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// secretFileDirs 允许通过 file: 引用读取的目录，可通过 DATASAFE_SECRET_DIRS（逗号分隔）修改
+func secretFileDirs() []string {
+	dirs := os.Getenv("DATASAFE_SECRET_DIRS")
+	if dirs == "" {
+		return []string{"/run/secrets"}
+	}
+	var result []string
+	for _, dir := range strings.Split(dirs, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			result = append(result, filepath.Clean(dir))
+		}
+	}
+	return result
+}
+
+// ValidateSecretRef 校验密码引用格式，并拒绝读取程序自身的密钥或允许目录之外的文件
+func ValidateSecretRef(ref string) error {
+	switch {
+	case strings.HasPrefix(ref, secretRefEnv):
+		name := strings.TrimPrefix(ref, secretRefEnv)
+		if name == "" {
+			return fmt.Errorf("环境变量名不能为空")
+		}
+		// 防止通过数据库连接把主密钥等内部配置发送给任意主机
+		if strings.HasPrefix(name, "DATASAFE_") || strings.HasPrefix(name, "VAULT_") {
+			return fmt.Errorf("不允许引用环境变量 %s", name)
+		}
+		return nil
+
+	case strings.HasPrefix(ref, secretRefFile):
+		path := strings.TrimPrefix(ref, secretRefFile)
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("密钥文件必须使用绝对路径")
+		}
+		path = filepath.Clean(path)
+		for _, dir := range secretFileDirs() {
+			if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				return nil
+			}
+		}
+		return fmt.Errorf("密钥文件必须位于 %s 下", strings.Join(secretFileDirs(), ", "))
+
+	case strings.HasPrefix(ref, secretRefVault):
+		path, key, ok := strings.Cut(strings.TrimPrefix(ref, secretRefVault), "#")
+		if !ok || path == "" || key == "" {
+			return fmt.Errorf("密钥引用格式应为 vault:<路径>#<字段>")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("不支持的密码引用: %s，应以 env:、file: 或 vault: 开头", ref)
+}
+
+// ResolvePassword 返回连接数据库使用的密码，配置了密码引用时在连接时读取
+func ResolvePassword(setting *models.DBSettings) (string, error) {
+	ref := setting.PasswordRef
+	if ref == "" {
+		return setting.Password, nil
+	}
+	if err := ValidateSecretRef(ref); err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.HasPrefix(ref, secretRefEnv):
+		name := strings.TrimPrefix(ref, secretRefEnv)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("环境变量 %s 未设置", name)
+		}
+		return value, nil
+
+	case strings.HasPrefix(ref, secretRefFile):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, secretRefFile))
+		if err != nil {
+			return "", fmt.Errorf("读取密钥文件失败: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	default:
+		path, key, _ := strings.Cut(strings.TrimPrefix(ref, secretRefVault), "#")
+		return readVaultSecret(path, key)
+	}
+}
+
+// readVaultSecret 从 Vault（或兼容 KV 接口的服务）读取密钥，地址和令牌来自 VAULT_ADDR、VAULT_TOKEN。
+// 同时兼容 KV v2（data.data）和 KV v1（data）的响应格式
+func readVaultSecret(path, key string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("未设置 VAULT_ADDR")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("创建密钥请求失败: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := secretHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("请求密钥服务失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("读取密钥 %s 失败: %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("解析密钥服务响应失败: %v", err)
+	}
+
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("密钥 %s 中不存在字段 %s", path, key)
+	}
+	return value, nil
+}
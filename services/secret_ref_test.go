@@ -0,0 +1,93 @@
+package services
+
+import (
+	"encoding/json"
+	"mysql-backup/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeVault 按路径返回 KV 响应，要求请求带有 token 和 namespace
+func newFakeVault(t *testing.T, responses map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.test" || r.Header.Get("X-Vault-Namespace") != "ops" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("VAULT_ADDR", server.URL+"/")
+	t.Setenv("VAULT_TOKEN", "s.test")
+	t.Setenv("VAULT_NAMESPACE", "ops")
+	return server
+}
+
+func TestResolvePasswordVault(t *testing.T) {
+	newFakeVault(t, map[string]interface{}{
+		"/v1/secret/data/mysql/prod": map[string]interface{}{
+			"data": map[string]interface{}{"data": map[string]interface{}{"password": "kv2-pass"}, "metadata": map[string]interface{}{"version": 3}},
+		},
+		"/v1/kv/mysql/legacy": map[string]interface{}{
+			"data": map[string]interface{}{"password": "kv1-pass"},
+		},
+	})
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{ref: "vault:secret/data/mysql/prod#password", want: "kv2-pass"},
+		{ref: "vault:/kv/mysql/legacy#password", want: "kv1-pass"},
+		{ref: "vault:secret/data/mysql/prod#user", wantErr: "不存在字段 user"},
+		{ref: "vault:secret/data/mysql/missing#password", wantErr: "404"},
+		{ref: "vault:secret/data/mysql/prod", wantErr: "vault:<路径>#<字段>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := ResolvePassword(&models.DBSettings{Password: "stored", PasswordRef: tt.ref})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("password = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePasswordVaultToken(t *testing.T) {
+	newFakeVault(t, map[string]interface{}{})
+	t.Setenv("VAULT_TOKEN", "s.wrong")
+
+	_, err := ResolvePassword(&models.DBSettings{PasswordRef: "vault:secret/data/mysql/prod#password"})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("err = %v, want 403", err)
+	}
+}
+
+func TestValidateSecretRefEnv(t *testing.T) {
+	for _, ref := range []string{"env:VAULT_TOKEN", "env:DATASAFE_MASTER_KEY", "env:"} {
+		if err := ValidateSecretRef(ref); err == nil {
+			t.Errorf("%s accepted", ref)
+		}
+	}
+	if err := ValidateSecretRef("env:MYSQL_PROD_PW"); err != nil {
+		t.Errorf("env:MYSQL_PROD_PW rejected: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// certCheckInterval 检查证书文件是否变化的最短间隔
+const certCheckInterval = 10 * time.Second
+
+// CertReloader 为 HTTPS 服务提供证书，证书或私钥文件变化后自动重新加载，不需要重启服务
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time // 证书和私钥文件中较新的修改时间
+	checkedAt time.Time
+}
+
+// NewCertReloader 加载证书，文件不存在或无法解析时返回错误
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载 HTTPS 证书失败: %v", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("读取 HTTPS 证书失败: %v", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate 用于 tls.Config.GetCertificate。新证书加载失败时继续使用原证书，
+// 避免证书和私钥只替换了一个时中断服务
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) >= certCheckInterval {
+		r.checkedAt = time.Now()
+		if modTime, err := r.latestModTime(); err != nil {
+			slog.Warn("检查 HTTPS 证书失败", "error", err)
+		} else if !modTime.Equal(r.modTime) {
+			if err := r.load(); err != nil {
+				slog.Error("重新加载 HTTPS 证书失败，继续使用原证书", "error", err)
+			} else {
+				slog.Info("已重新加载 HTTPS 证书", "cert", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// EnsureSelfSignedCert 证书文件不存在或自签名证书即将过期时生成新的自签名证书，
+// 证书包含 localhost、本机主机名和回环地址
+func EnsureSelfSignedCert(certFile, keyFile string) error {
+	if data, err := os.ReadFile(certFile); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("证书文件 %s 格式无效", certFile)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("解析证书 %s 失败: %v", certFile, err)
+		}
+		// 只续期自己生成的证书，不覆盖用户提供的证书
+		if !isSelfSigned(cert) || time.Until(cert.NotAfter) > 30*24*time.Hour {
+			return nil
+		}
+		slog.Info("自签名证书即将过期，重新生成", "notAfter", cert.NotAfter.Format("2006-01-02"))
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("读取证书 %s 失败: %v", certFile, err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("生成私钥失败: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("生成证书序列号失败: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "DataSafe", Organization: []string{"DataSafe self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("生成自签名证书失败: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("编码私钥失败: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return fmt.Errorf("创建证书目录失败: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return fmt.Errorf("创建证书目录失败: %v", err)
+	}
+	// 先写私钥，证书重新加载时以两个文件的修改时间为准
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("写入私钥失败: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("写入证书失败: %v", err)
+	}
+	slog.Warn("已生成自签名证书，浏览器会提示证书不受信任，生产环境请使用正式证书", "cert", certFile)
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		len(cert.Subject.Organization) == 1 && cert.Subject.Organization[0] == "DataSafe self-signed"
+}
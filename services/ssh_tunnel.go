@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mysql-backup/models"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshClients 按跳板机配置复用的 SSH 连接，同一跳板机上的多个数据库连接共用一个 SSH 连接。
+// 全局锁只保护映射，建立连接时只持有对应跳板机的锁，一个跳板机无法访问不会阻塞其他跳板机
+var (
+	sshClients     = make(map[string]*sshClientEntry)
+	sshSettingKeys = make(map[int]string) // 数据库配置当前使用的跳板机，配置修改后关闭旧的 SSH 连接
+	sshClientsMu   sync.Mutex
+)
+
+// sshClientEntry 一个跳板机配置的 SSH 连接，mu 保证同一跳板机同时只建立一个连接
+type sshClientEntry struct {
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// ValidateSSHTunnel 校验 SSH 跳板机配置
+func ValidateSSHTunnel(tunnel *models.SSHTunnel) error {
+	if tunnel == nil {
+		return nil
+	}
+	if tunnel.Host == "" {
+		return fmt.Errorf("跳板机地址不能为空")
+	}
+	if tunnel.Port < 0 || tunnel.Port > 65535 {
+		return fmt.Errorf("无效的跳板机端口")
+	}
+	if tunnel.User == "" {
+		return fmt.Errorf("跳板机用户名不能为空")
+	}
+	if tunnel.Password == "" && tunnel.PrivateKey == "" && !tunnel.UseAgent {
+		return fmt.Errorf("跳板机需要配置密码、私钥或 ssh-agent")
+	}
+	if tunnel.HostKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(tunnel.HostKey)); err != nil {
+			return fmt.Errorf("无效的跳板机公钥: %v", err)
+		}
+	}
+	return nil
+}
+
+// registerSSHTunnel 向 MySQL 驱动注册通过跳板机连接的网络类型，返回 DSN 中使用的网络名。
+// 未配置跳板机时返回 tcp。track 为 true 时记录配置使用的跳板机，只能用于已保存的配置
+func registerSSHTunnel(setting *models.DBSettings, track bool) (string, error) {
+	if setting.SSH == nil {
+		if track {
+			trackSSHKey(setting.ID, "")
+		}
+		return "tcp", nil
+	}
+	if err := ValidateSSHTunnel(setting.SSH); err != nil {
+		return "", err
+	}
+
+	tunnel := *setting.SSH
+	data, err := json.Marshal(&tunnel)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	name := "datasafe-ssh-" + hex.EncodeToString(sum[:8])
+	if track {
+		trackSSHKey(setting.ID, name)
+	}
+
+	mysql.RegisterDialContext(name, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialThroughSSH(ctx, name, &tunnel, addr)
+	})
+	return name, nil
+}
+
+// dialThroughSSH 通过跳板机连接数据库地址，SSH 连接断开时重新建立一次
+func dialThroughSSH(ctx context.Context, key string, tunnel *models.SSHTunnel, addr string) (net.Conn, error) {
+	client, err := getSSHClient(ctx, key, tunnel)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, "tcp", addr)
+	if err == nil {
+		return conn, nil
+	}
+
+	// SSH 连接仍然可用时是数据库地址无法访问，否则重新建立 SSH 连接
+	if _, _, keepaliveErr := client.SendRequest("keepalive@openssh.com", true, nil); keepaliveErr == nil {
+		return nil, fmt.Errorf("通过跳板机连接 %s 失败: %v", addr, err)
+	}
+	dropSSHClient(key, client)
+	client, err = getSSHClient(ctx, key, tunnel)
+	if err != nil {
+		return nil, err
+	}
+	conn, err = client.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("通过跳板机连接 %s 失败: %v", addr, err)
+	}
+	return conn, nil
+}
+
+// trackSSHKey 记录数据库配置使用的跳板机。配置的跳板机变化后，没有其他配置使用的旧 SSH 连接会被关闭。
+// 未保存的配置（ID 为 0）不记录
+func trackSSHKey(settingID int, key string) {
+	if settingID == 0 {
+		return
+	}
+	sshClientsMu.Lock()
+	old := sshSettingKeys[settingID]
+	if key == "" {
+		delete(sshSettingKeys, settingID)
+	} else {
+		sshSettingKeys[settingID] = key
+	}
+	if old == "" || old == key {
+		sshClientsMu.Unlock()
+		return
+	}
+	for _, k := range sshSettingKeys {
+		if k == old {
+			sshClientsMu.Unlock()
+			return
+		}
+	}
+	entry := sshClients[old]
+	delete(sshClients, old)
+	sshClientsMu.Unlock()
+
+	if entry != nil {
+		entry.mu.Lock()
+		if entry.client != nil {
+			entry.client.Close()
+			entry.client = nil
+		}
+		entry.mu.Unlock()
+	}
+}
+
+// getSSHClient 返回跳板机的 SSH 连接，没有可用连接时使用 ctx 建立新连接
+func getSSHClient(ctx context.Context, key string, tunnel *models.SSHTunnel) (*ssh.Client, error) {
+	sshClientsMu.Lock()
+	entry, ok := sshClients[key]
+	if !ok {
+		entry = &sshClientEntry{}
+		sshClients[key] = entry
+	}
+	sshClientsMu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.client != nil {
+		return entry.client, nil
+	}
+
+	client, err := dialSSH(ctx, tunnel)
+	if err != nil {
+		return nil, err
+	}
+	entry.client = client
+	go func() {
+		// SSH 连接断开后从缓存中移除
+		client.Wait()
+		dropSSHClient(key, client)
+	}()
+	slog.Info("已连接跳板机", "user", tunnel.User, "host", tunnel.Host)
+	return client, nil
+}
+
+// dialSSH 连接跳板机并完成握手，ctx 取消或超时时中止
+func dialSSH(ctx context.Context, tunnel *models.SSHTunnel) (*ssh.Client, error) {
+	config, closeAgent, err := sshClientConfig(tunnel)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	port := tunnel.Port
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(tunnel.Host, strconv.Itoa(port))
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接跳板机失败: %v", err)
+	}
+
+	// 握手不支持 ctx，ctx 取消时关闭连接，并设置超时
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	deadline := time.Now().Add(config.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("连接跳板机失败: %v", err)
+	}
+	if !stop() {
+		sshConn.Close()
+		return nil, fmt.Errorf("连接跳板机失败: %v", ctx.Err())
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+func dropSSHClient(key string, client *ssh.Client) {
+	sshClientsMu.Lock()
+	entry := sshClients[key]
+	sshClientsMu.Unlock()
+	if entry != nil {
+		entry.mu.Lock()
+		if entry.client == client {
+			entry.client = nil
+		}
+		entry.mu.Unlock()
+	}
+	client.Close()
+}
+
+// sshClientConfig 生成 SSH 客户端配置，返回的 closeAgent 在认证完成后关闭 ssh-agent 连接
+func sshClientConfig(tunnel *models.SSHTunnel) (*ssh.ClientConfig, func(), error) {
+	closeAgent := func() {}
+	var auths []ssh.AuthMethod
+
+	if tunnel.PrivateKey != "" {
+		pem, err := readPEM(tunnel.PrivateKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("读取跳板机私钥失败: %v", err)
+		}
+		var signer ssh.Signer
+		if tunnel.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(tunnel.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("解析跳板机私钥失败: %v", err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+
+	if tunnel.UseAgent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, nil, fmt.Errorf("未设置 SSH_AUTH_SOCK，无法使用 ssh-agent")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("连接 ssh-agent 失败: %v", err)
+		}
+		closeAgent = func() { conn.Close() }
+		auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if tunnel.Password != "" {
+		auths = append(auths, ssh.Password(tunnel.Password))
+	}
+
+	hostKeyCallback, err := sshHostKeyCallback(tunnel)
+	if err != nil {
+		closeAgent()
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            tunnel.User,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	}, closeAgent, nil
+}
+
+// sshHostKeyCallback 校验跳板机公钥，不支持跳过校验
+func sshHostKeyCallback(tunnel *models.SSHTunnel) (ssh.HostKeyCallback, error) {
+	if tunnel.HostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(tunnel.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("无效的跳板机公钥: %v", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	}
+
+	path := tunnel.KnownHosts
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("未配置跳板机公钥或 known_hosts 文件")
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 known_hosts 失败: %v", err)
+	}
+	return callback, nil
+}
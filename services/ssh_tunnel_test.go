@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"mysql-backup/config"
+	"mysql-backup/models"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newSSHServer 在本机启动只接受密码认证的 SSH 服务，返回地址和主机公钥
+func newSSHServer(t *testing.T) (string, int, ssh.PublicKey) {
+	t.Helper()
+	hostKey := newSSHSigner(t)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "tunnel" && string(password) == "tunnel-pass" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer sconn.Close()
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, hostKey.PublicKey()
+}
+
+func newSSHSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// writeKnownHosts 写入只包含一条记录的 known_hosts 文件
+func writeKnownHosts(t *testing.T, host string, port int, key ssh.PublicKey) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))}, key)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newHungListener 接受 TCP 连接但不进行 SSH 握手，模拟无响应的跳板机
+func newHungListener(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestDialSSHHostKey(t *testing.T) {
+	host, port, hostKey := newSSHServer(t)
+	otherKey := newSSHSigner(t).PublicKey()
+
+	tests := []struct {
+		name    string
+		tunnel  func(*models.SSHTunnel)
+		wantErr string // 为空表示连接成功
+	}{
+		{name: "pinned", tunnel: func(tun *models.SSHTunnel) { tun.HostKey = string(ssh.MarshalAuthorizedKey(hostKey)) }},
+		{name: "pinned mismatch", tunnel: func(tun *models.SSHTunnel) { tun.HostKey = string(ssh.MarshalAuthorizedKey(otherKey)) }, wantErr: "host key mismatch"},
+		{name: "known_hosts", tunnel: func(tun *models.SSHTunnel) { tun.KnownHosts = writeKnownHosts(t, host, port, hostKey) }},
+		{name: "known_hosts mismatch", tunnel: func(tun *models.SSHTunnel) { tun.KnownHosts = writeKnownHosts(t, host, port, otherKey) }, wantErr: "key mismatch"},
+		{name: "known_hosts unknown", tunnel: func(tun *models.SSHTunnel) { tun.KnownHosts = writeKnownHosts(t, "10.0.0.1", 22, hostKey) }, wantErr: "key is unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tunnel := &models.SSHTunnel{Host: host, Port: port, User: "tunnel", Password: "tunnel-pass"}
+			tt.tunnel(tunnel)
+
+			client, err := dialSSH(context.Background(), tunnel)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.Close()
+				return
+			}
+			if err == nil {
+				client.Close()
+				t.Fatal("connected with an untrusted host key")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDialSSHContextCanceled(t *testing.T) {
+	addr := newHungListener(t)
+	tunnel := &models.SSHTunnel{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		User:     "tunnel",
+		Password: "tunnel-pass",
+		HostKey:  string(ssh.MarshalAuthorizedKey(newSSHSigner(t).PublicKey())),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	if _, err := dialSSH(ctx, tunnel); err == nil {
+		t.Fatal("handshake succeeded")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("dial took %s, ctx deadline ignored", elapsed)
+	}
+}
+
+func TestGetSSHClientDoesNotBlockOtherTunnels(t *testing.T) {
+	hungAddr := newHungListener(t)
+	hung := &models.SSHTunnel{Host: hungAddr.IP.String(), Port: hungAddr.Port, User: "tunnel", Password: "tunnel-pass",
+		HostKey: string(ssh.MarshalAuthorizedKey(newSSHSigner(t).PublicKey()))}
+
+	host, port, hostKey := newSSHServer(t)
+	ok := &models.SSHTunnel{Host: host, Port: port, User: "tunnel", Password: "tunnel-pass",
+		HostKey: string(ssh.MarshalAuthorizedKey(hostKey))}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		getSSHClient(ctx, "test-hung", hung)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	started := time.Now()
+	client, err := getSSHClient(context.Background(), "test-ok", ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropSSHClient("test-ok", client)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("dial waited %s for another tunnel", elapsed)
+	}
+	cancel()
+	<-done
+}
+
+func TestTestConnectionKeepsSavedTunnel(t *testing.T) {
+	host, port, hostKey := newSSHServer(t)
+	saved := &models.DBSettings{ID: 4201, Host: "10.0.0.5", Port: 3306, User: "backup",
+		SSH: &models.SSHTunnel{Host: host, Port: port, User: "tunnel", Password: "tunnel-pass", HostKey: string(ssh.MarshalAuthorizedKey(hostKey))}}
+
+	// 已保存配置的跳板机连接，模拟正在执行的备份
+	key, err := registerSSHTunnel(saved, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := getSSHClient(context.Background(), key, saved.SSH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		trackSSHKey(saved.ID, "")
+		client.Close()
+	})
+
+	// 编辑页面测试连接：同一配置 ID，跳板机改为另一台
+	otherHost, otherPort, otherKey := newSSHServer(t)
+	edited := *saved
+	edited.SSH = &models.SSHTunnel{Host: otherHost, Port: otherPort, User: "tunnel", Password: "tunnel-pass", HostKey: string(ssh.MarshalAuthorizedKey(otherKey))}
+	edited.ConnectTimeout = 1
+	if _, err := NewBackupService(config.Default()).TestConnection(&edited); err == nil {
+		t.Fatal("test connection succeeded without a database")
+	}
+
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Fatalf("saved tunnel closed by test connection: %v", err)
+	}
+	if got, err := getSSHClient(context.Background(), key, saved.SSH); err != nil || got != client {
+		t.Fatalf("saved tunnel replaced: %v", err)
+	}
+}
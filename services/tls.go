@@ -0,0 +1,146 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mysql-backup/models"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// readPEM 读取证书或私钥，值以 -----BEGIN 开头时视为 PEM 内容，否则视为文件路径
+func readPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
+
+// ValidateTLSSettings 校验 TLS 配置
+func ValidateTLSSettings(settings *models.TLSSettings) error {
+	if settings == nil {
+		return nil
+	}
+	switch settings.Mode {
+	case "", models.TLSModeDisabled, models.TLSModePreferred, models.TLSModeRequired,
+		models.TLSModeVerifyCA, models.TLSModeVerifyIdentity:
+	default:
+		return fmt.Errorf("无效的 TLS 模式: %s", settings.Mode)
+	}
+	if (settings.ClientCert == "") != (settings.ClientKey == "") {
+		return fmt.Errorf("客户端证书和私钥必须同时设置")
+	}
+	if settings.Mode == models.TLSModeVerifyCA && settings.CA == "" {
+		return fmt.Errorf("verify-ca 模式需要设置 CA 证书")
+	}
+	return nil
+}
+
+// buildTLSConfig 按 TLS 模式生成 tls.Config
+func buildTLSConfig(setting *models.DBSettings) (*tls.Config, error) {
+	settings := setting.TLS
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if settings.CA != "" {
+		pem, err := readPEM(settings.CA)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 证书中没有有效的证书")
+		}
+		config.RootCAs = pool
+	}
+
+	if settings.ClientCert != "" {
+		certPEM, err := readPEM(settings.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书失败: %v", err)
+		}
+		keyPEM, err := readPEM(settings.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端私钥失败: %v", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	switch settings.Mode {
+	case models.TLSModeRequired:
+		config.InsecureSkipVerify = true
+	case models.TLSModeVerifyCA:
+		// 只校验证书链，不校验主机名
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+			if len(certs) == 0 {
+				return fmt.Errorf("服务器没有提供证书")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(x509.VerifyOptions{Roots: config.RootCAs, Intermediates: intermediates})
+			return err
+		}
+	case models.TLSModeVerifyIdentity:
+		config.ServerName = settings.ServerName
+		if config.ServerName == "" {
+			config.ServerName = setting.Host
+		}
+	}
+
+	return config, nil
+}
+
+// registerTLS 向 MySQL 驱动注册 TLS 配置，返回 DSN 中 tls 参数的值，为空表示不使用 TLS。
+// 配置名由 TLS 配置内容决定，相同配置复用同一个名称
+func registerTLS(setting *models.DBSettings) (string, error) {
+	settings := setting.TLS
+	if settings == nil || settings.Mode == "" || settings.Mode == models.TLSModeDisabled {
+		return "", nil
+	}
+	if err := ValidateTLSSettings(settings); err != nil {
+		return "", err
+	}
+	if settings.Mode == models.TLSModePreferred {
+		return "preferred", nil
+	}
+
+	config, err := buildTLSConfig(setting)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(struct {
+		Host string
+		TLS  *models.TLSSettings
+	}{setting.Host, settings})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	name := "datasafe-" + hex.EncodeToString(sum[:8])
+
+	if err := mysql.RegisterTLSConfig(name, config); err != nil {
+		return "", fmt.Errorf("注册 TLS 配置失败: %v", err)
+	}
+	return name, nil
+}
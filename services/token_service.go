@@ -0,0 +1,134 @@
+package services
+
+import (
+	"fmt"
+	"mysql-backup/models"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix API 令牌的固定前缀
+const apiTokenPrefix = "dst_"
+
+// tokenTouchInterval 同一 IP 使用令牌时，最后使用时间最多每分钟更新一次，避免每个请求都写入存储
+const tokenTouchInterval = time.Minute
+
+// validPermissions 可授予 API 令牌的权限
+var validPermissions = map[string]bool{
+	models.PermView:     true,
+	models.PermBackup:   true,
+	models.PermDownload: true,
+	models.PermManage:   true,
+	models.PermRestore:  true,
+}
+
+// CreateToken 创建 API 令牌，返回的明文令牌只在创建时出现一次。
+// SettingIDs 为空的令牌可以访问所有数据库配置，包括以后新增的配置
+func (s *AuthService) CreateToken(req models.TokenRequest, createdBy string) (string, *models.APIToken, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return "", nil, fmt.Errorf("令牌名称不能为空")
+	}
+	if len(req.Permissions) == 0 {
+		return "", nil, fmt.Errorf("令牌至少需要一个权限")
+	}
+	for _, perm := range req.Permissions {
+		if !validPermissions[perm] {
+			return "", nil, fmt.Errorf("无效的权限: %s", perm)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		return "", nil, fmt.Errorf("无效的有效期")
+	}
+
+	random, err := randomToken(20)
+	if err != nil {
+		return "", nil, fmt.Errorf("生成令牌失败: %v", err)
+	}
+	plaintext := apiTokenPrefix + random
+
+	now := time.Now()
+	token := &models.APIToken{
+		Name:        name,
+		Prefix:      plaintext[:len(apiTokenPrefix)+6],
+		TokenHash:   hashToken(plaintext),
+		Permissions: req.Permissions,
+		SettingIDs:  req.SettingIDs,
+		CreatedBy:   createdBy,
+		CreatedAt:   now.Format("2006-01-02 15:04:05"),
+	}
+	if req.ExpiresInDays > 0 {
+		token.ExpiresAt = now.AddDate(0, 0, req.ExpiresInDays).Format("2006-01-02 15:04:05")
+	}
+
+	if err := s.store.SaveAPIToken(token); err != nil {
+		return "", nil, fmt.Errorf("保存令牌失败: %v", err)
+	}
+	return plaintext, token, nil
+}
+
+// ListTokens 获取所有 API 令牌
+func (s *AuthService) ListTokens() ([]*models.APIToken, error) {
+	return s.store.GetAllAPITokens()
+}
+
+// RevokeToken 吊销 API 令牌
+func (s *AuthService) RevokeToken(id int) (*models.APIToken, error) {
+	token, err := s.store.GetAPITokenByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if token.Revoked {
+		return token, nil
+	}
+
+	token.Revoked = true
+	token.RevokedAt = time.Now().Format("2006-01-02 15:04:05")
+	if err := s.store.SaveAPIToken(token); err != nil {
+		return nil, fmt.Errorf("保存令牌失败: %v", err)
+	}
+	return token, nil
+}
+
+// AuthenticateToken 校验 API 令牌并记录最后使用时间
+func (s *AuthService) AuthenticateToken(plaintext, ip string) (*models.APIToken, error) {
+	if !strings.HasPrefix(plaintext, apiTokenPrefix) {
+		return nil, fmt.Errorf("令牌无效")
+	}
+
+	token, err := s.store.GetAPITokenByHash(hashToken(plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("令牌无效")
+	}
+	if token.Revoked {
+		return nil, fmt.Errorf("令牌已吊销")
+	}
+	if token.ExpiresAt != "" {
+		expiresAt, err := time.ParseInLocation("2006-01-02 15:04:05", token.ExpiresAt, time.Local)
+		if err != nil || time.Now().After(expiresAt) {
+			return nil, fmt.Errorf("令牌已过期")
+		}
+	}
+
+	now := time.Now()
+	lastUsed, err := time.ParseInLocation("2006-01-02 15:04:05", token.LastUsedAt, time.Local)
+	if err != nil || token.LastUsedIP != ip || now.Sub(lastUsed) >= tokenTouchInterval {
+		token.LastUsedAt = now.Format("2006-01-02 15:04:05")
+		token.LastUsedIP = ip
+		if err := s.store.TouchAPIToken(token.ID, token.LastUsedAt, ip); err != nil {
+			return nil, fmt.Errorf("保存令牌失败: %v", err)
+		}
+	}
+	return token, nil
+}
+
+// PrincipalForToken 根据 API 令牌的权限和授权范围生成身份，未限定数据库配置的令牌可访问全部配置
+func PrincipalForToken(token *models.APIToken) *Principal {
+	return &Principal{
+		Username:    "token:" + token.Name,
+		Role:        "token",
+		Permissions: token.Permissions,
+		AllSettings: len(token.SettingIDs) == 0,
+		SettingIDs:  token.SettingIDs,
+	}
+}
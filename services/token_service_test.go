@@ -0,0 +1,157 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mysql-backup/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateTokenValidation(t *testing.T) {
+	auth := NewAuthService(newTestStore(t))
+	tests := []struct {
+		name    string
+		req     models.TokenRequest
+		wantErr string
+	}{
+		{name: "empty name", req: models.TokenRequest{Name: " ", Permissions: []string{models.PermView}}, wantErr: "名称不能为空"},
+		{name: "no permissions", req: models.TokenRequest{Name: "ci"}, wantErr: "至少需要一个权限"},
+		{name: "unknown permission", req: models.TokenRequest{Name: "ci", Permissions: []string{"root"}}, wantErr: "无效的权限: root"},
+		{name: "negative expiry", req: models.TokenRequest{Name: "ci", Permissions: []string{models.PermView}, ExpiresInDays: -1}, wantErr: "无效的有效期"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := auth.CreateToken(tt.req, "admin"); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateTokenStoresHashOnly(t *testing.T) {
+	auth := NewAuthService(newTestStore(t))
+	plaintext, token, err := auth.CreateToken(models.TokenRequest{Name: "ci", Permissions: []string{models.PermBackup}, ExpiresInDays: 30}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(plaintext, "dst_") || !strings.HasPrefix(plaintext, token.Prefix) {
+		t.Fatalf("plaintext = %q, prefix = %q", plaintext, token.Prefix)
+	}
+	sum := sha256.Sum256([]byte(plaintext))
+	if token.TokenHash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("token hash = %s, want SHA-256 of the plaintext", token.TokenHash)
+	}
+	if token.ExpiresAt == "" {
+		t.Fatal("expiry not set")
+	}
+
+	got, err := auth.AuthenticateToken(plaintext, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != token.ID || got.LastUsedIP != "10.0.0.1" || got.LastUsedAt == "" {
+		t.Fatalf("authenticated token = %+v", got)
+	}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	store := newTestStore(t)
+	auth := NewAuthService(store)
+	plaintext, token, err := auth.CreateToken(models.TokenRequest{Name: "ci", Permissions: []string{models.PermView}}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, expiredToken, err := auth.CreateToken(models.TokenRequest{Name: "old", Permissions: []string{models.PermView}, ExpiresInDays: 1}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredToken.ExpiresAt = time.Now().Add(-time.Minute).Format("2006-01-02 15:04:05")
+	if err := store.SaveAPIToken(expiredToken); err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedToken, err := auth.CreateToken(models.TokenRequest{Name: "gone", Permissions: []string{models.PermView}}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.RevokeToken(revokedToken.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		plaintext string
+		wantErr   string // 为空表示校验通过
+	}{
+		{name: "valid", plaintext: plaintext},
+		{name: "missing prefix", plaintext: strings.TrimPrefix(plaintext, "dst_"), wantErr: "令牌无效"},
+		{name: "wrong prefix", plaintext: "dsx_" + strings.TrimPrefix(plaintext, "dst_"), wantErr: "令牌无效"},
+		{name: "tampered", plaintext: plaintext[:len(plaintext)-1] + "x", wantErr: "令牌无效"},
+		{name: "expired", plaintext: expired, wantErr: "令牌已过期"},
+		{name: "revoked", plaintext: revoked, wantErr: "令牌已吊销"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := auth.AuthenticateToken(tt.plaintext, "10.0.0.1")
+			if tt.wantErr == "" {
+				if err != nil || got.ID != token.ID {
+					t.Fatalf("token = %+v, err = %v", got, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevokeTokenKeepsFirstRevokedAt(t *testing.T) {
+	auth := NewAuthService(newTestStore(t))
+	_, token, err := auth.CreateToken(models.TokenRequest{Name: "ci", Permissions: []string{models.PermView}}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := auth.RevokeToken(token.ID)
+	if err != nil || !first.Revoked || first.RevokedAt == "" {
+		t.Fatalf("revoked = %+v, err = %v", first, err)
+	}
+	second, err := auth.RevokeToken(token.ID)
+	if err != nil || second.RevokedAt != first.RevokedAt {
+		t.Fatalf("second revoke = %+v, err = %v", second, err)
+	}
+}
+
+func TestPrincipalForTokenScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		settingIDs []int
+		allowed    []int
+		denied     []int
+	}{
+		{name: "empty scope means all settings", settingIDs: nil, allowed: []int{1, 2, 99}},
+		{name: "scoped", settingIDs: []int{1, 3}, allowed: []int{1, 3}, denied: []int{2, 99}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal := PrincipalForToken(&models.APIToken{Name: "ci", Permissions: []string{models.PermBackup}, SettingIDs: tt.settingIDs})
+			if principal.AllSettings != (len(tt.settingIDs) == 0) {
+				t.Fatalf("AllSettings = %v", principal.AllSettings)
+			}
+			for _, id := range tt.allowed {
+				if !principal.Can(models.PermBackup, id) {
+					t.Errorf("denied backup on setting %d", id)
+				}
+				if principal.Can(models.PermManage, id) {
+					t.Errorf("allowed manage on setting %d without the permission", id)
+				}
+			}
+			for _, id := range tt.denied {
+				if principal.Can(models.PermBackup, id) {
+					t.Errorf("allowed backup on setting %d outside the scope", id)
+				}
+			}
+		})
+	}
+}
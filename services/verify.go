@@ -0,0 +1,102 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mysql-backup/models"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// 备份文件校验结果
+const (
+	VerifyOK               = "ok"
+	VerifyMissing          = "missing"           // 备份文件不存在
+	VerifySizeMismatch     = "size_mismatch"     // 文件大小与记录不一致
+	VerifyChecksumMismatch = "checksum_mismatch" // 校验和与记录不一致
+	VerifyNoChecksum       = "no_checksum"       // 旧版本的备份记录没有保存校验和
+)
+
+// VerifyResult 备份文件校验结果
+type VerifyResult struct {
+	RecordID int    `json:"recordId"`
+	DBName   string `json:"dbName"`
+	FileName string `json:"fileName"`
+	Status   string `json:"status"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// OK 文件完整，没有校验和的旧备份只要文件存在且大小一致也视为通过
+func (r *VerifyResult) OK() bool {
+	return r.Status == VerifyOK || r.Status == VerifyNoChecksum
+}
+
+// VerifyBackup 校验已完成备份的文件是否存在、大小和 SHA-256 是否与备份记录一致，校验未通过时发布事件
+func (s *BackupService) VerifyBackup(setting *models.DBSettings, record *models.BackupRecord) *VerifyResult {
+	result := verifyBackup(setting, record)
+	if !result.OK() {
+		reason := result.Status
+		if result.Error != "" {
+			reason += ": " + result.Error
+		}
+		s.events.Publish(newBackupEvent(models.EventVerifyFailed, setting, record.DBName, record, errors.New(reason)))
+	}
+	return result
+}
+
+func verifyBackup(setting *models.DBSettings, record *models.BackupRecord) *VerifyResult {
+	result := &VerifyResult{RecordID: record.ID, DBName: record.DBName, FileName: record.FileName}
+	path := filepath.Join(setting.BackupDir, record.FileName)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		result.Status = VerifyMissing
+		result.Error = err.Error()
+		return result
+	}
+	if record.Size > 0 && info.Size() != record.Size {
+		result.Status = VerifySizeMismatch
+		result.Expected = strconv.FormatInt(record.Size, 10)
+		result.Actual = strconv.FormatInt(info.Size(), 10)
+		return result
+	}
+
+	checksum, err := fileSHA256(path)
+	if err != nil {
+		result.Status = VerifyMissing
+		result.Error = err.Error()
+		return result
+	}
+	result.Actual = checksum
+	switch {
+	case record.Checksum == "":
+		result.Status = VerifyNoChecksum
+	case record.Checksum != checksum:
+		result.Status = VerifyChecksumMismatch
+		result.Expected = record.Checksum
+	default:
+		result.Status = VerifyOK
+		result.Expected = record.Checksum
+	}
+	return result
+}
+
+// fileSHA256 计算文件的 SHA-256
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mysql-backup/models"
+	"mysql-backup/storage"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	webhookTimeout       = 10 * time.Second
+	webhookRetryDelay    = 2 * time.Second // 第一次重试的等待时间，之后依次翻倍
+	maxWebhookRetries    = 10
+	webhookResponseLimit = 1024 // 投递记录中保留的响应内容长度
+
+	// WebhookSignatureHeader 请求体的 HMAC-SHA256 签名，格式为 sha256=<十六进制>
+	WebhookSignatureHeader = "X-DataSafe-Signature"
+	webhookEventHeader     = "X-DataSafe-Event"
+	webhookDeliveryHeader  = "X-DataSafe-Delivery"
+)
+
+// WebhookService 订阅备份事件，向匹配的 Webhook 和群机器人发送通知，失败时按间隔翻倍重试
+type WebhookService struct {
+	store      storage.Store
+	client     *http.Client
+	retryDelay time.Duration
+	wg         sync.WaitGroup // 正在进行的投递
+
+	ctx    context.Context // 取消后停止重试并中止正在发送的请求
+	cancel context.CancelFunc
+}
+
+func NewWebhookService(store storage.Store, events *EventBus) *WebhookService {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &WebhookService{
+		store:      store,
+		client:     &http.Client{Timeout: webhookTimeout},
+		retryDelay: webhookRetryDelay,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	events.Subscribe(s.handle)
+	return s
+}
+
+// handle 为每个订阅了该事件的 Webhook 启动一次异步投递
+func (s *WebhookService) handle(event *models.Event) {
+	hooks, err := s.store.GetAllWebhooks()
+	if err != nil {
+		slog.Error("读取 Webhook 失败，事件未发送", "event", event.Type, "error", err)
+		return
+	}
+	for _, hook := range hooks {
+		if !hook.Enabled || !webhookMatches(hook, event) {
+			continue
+		}
+		s.wg.Add(1)
+		go func(hook *models.Webhook) {
+			defer s.wg.Done()
+			s.deliver(s.ctx, hook, event)
+		}(hook)
+	}
+}
+
+// Shutdown 等待正在进行的投递（包括重试）完成，ctx 到期后取消剩余的投递并等待其退出。
+// 命令行退出和服务停止时在关闭存储之前调用
+func (s *WebhookService) Shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	slog.Warn("等待 Webhook 投递超时，取消剩余的投递")
+	s.cancel()
+	<-done
+}
+
+// webhookMatches 判断 Webhook 是否订阅了事件类型，以及事件是否属于指定的数据库配置和定时任务
+func webhookMatches(hook *models.Webhook, event *models.Event) bool {
+	if len(hook.Events) > 0 && !containsString(hook.Events, event.Type) {
+		return false
+	}
+	if len(hook.SettingIDs) > 0 && !containsInt(hook.SettingIDs, event.SettingID) {
+		return false
+	}
+	if len(hook.ScheduleIDs) > 0 && !containsInt(hook.ScheduleIDs, event.ScheduleID) {
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver 发送事件，失败时重试 MaxRetries 次，每次请求都记录到投递日志，ctx 取消后不再重试。
+// 每次重试重新生成请求，群机器人的签名带有时间戳，过期后会被拒绝
+func (s *WebhookService) deliver(ctx context.Context, hook *models.Webhook, event *models.Event) {
+	delay := s.retryDelay
+	for attempt := 1; ; attempt++ {
+		delivery, retry := s.attempt(ctx, hook, event, attempt)
+		if delivery.Status == models.DeliverySucceeded || !retry || attempt > hook.MaxRetries {
+			if delivery.Status != models.DeliverySucceeded {
+				slog.Warn("Webhook 发送失败", "webhook", hook.Name, "attempt", attempt, "error", delivery.Error)
+			}
+			return
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			slog.Warn("Webhook 投递已取消", "webhook", hook.Name, "attempt", attempt, "error", delivery.Error)
+			return
+		}
+		delay *= 2
+	}
+}
+
+// attempt 生成并发送一次请求，结果记录到投递日志。请求体无法生成，或者接收方返回
+// 408、429 以外的 4xx 状态码时不需要重试
+func (s *WebhookService) attempt(ctx context.Context, hook *models.Webhook, event *models.Event, attempt int) (*models.WebhookDelivery, bool) {
+	req, err := newWebhookRequest(hook, event, time.Now())
+	if err != nil {
+		delivery := &models.WebhookDelivery{
+			WebhookID: hook.ID,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Attempt:   attempt,
+			Status:    models.DeliveryFailed,
+			Error:     err.Error(),
+			Time:      time.Now().Format("2006-01-02 15:04:05"),
+		}
+		s.record(delivery)
+		return delivery, false
+	}
+
+	delivery := s.send(hook, event, req.WithContext(ctx), attempt)
+	s.record(delivery)
+	return delivery, retryableStatus(delivery.StatusCode)
+}
+
+// retryableStatus 没有收到响应、服务端错误、请求超时和限流时重试，其他 4xx 表示请求本身有问题，重试也不会成功
+func retryableStatus(code int) bool {
+	if code >= 400 && code < 500 {
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// Test 立即发送一条测试事件，不重试，结果同样记录到投递日志
+func (s *WebhookService) Test(hook *models.Webhook) *models.WebhookDelivery {
+	event := &models.Event{
+		ID:      newEventID(),
+		Type:    models.EventTest,
+		Time:    time.Now().Format("2006-01-02 15:04:05"),
+		Message: fmt.Sprintf("DataSafe Webhook %s 测试消息", hook.Name),
+	}
+	delivery, _ := s.attempt(s.ctx, hook, event, 1)
+	return delivery
+}
+
+// newWebhookRequest 生成请求。通用 Webhook 在请求头中带上 HMAC 签名；
+// 钉钉和飞书机器人开启加签时分别在 URL 和请求体中带上签名
+func newWebhookRequest(hook *models.Webhook, event *models.Event, now time.Time) (*http.Request, error) {
+	body, err := renderWebhookBody(hook, event, now)
+	if err != nil {
+		return nil, err
+	}
+
+	target := hook.URL
+	if hook.Type == models.ChannelDingTalk && hook.Secret != "" {
+		if target, err = dingTalkSignedURL(hook.URL, hook.Secret, now); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DataSafe-Webhook")
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(webhookEventHeader, event.Type)
+	req.Header.Set(webhookDeliveryHeader, event.ID)
+	if isGenericWebhook(hook) && hook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookBody(hook.Secret, body))
+	}
+	return req, nil
+}
+
+func isGenericWebhook(hook *models.Webhook) bool {
+	return hook.Type == "" || hook.Type == models.ChannelWebhook
+}
+
+// send 发送一次请求，2xx 视为成功；群机器人还要求响应中的错误码为 0
+func (s *WebhookService) send(hook *models.Webhook, event *models.Event, req *http.Request, attempt int) *models.WebhookDelivery {
+	delivery := &models.WebhookDelivery{
+		WebhookID: hook.ID,
+		EventID:   event.ID,
+		EventType: event.Type,
+		Attempt:   attempt,
+		Status:    models.DeliveryFailed,
+		Time:      time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	started := time.Now()
+	resp, err := s.client.Do(req)
+	delivery.Duration = time.Since(started).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	delivery.StatusCode = resp.StatusCode
+	delivery.Response = string(response)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		delivery.Error = fmt.Sprintf("HTTP 状态码 %d", resp.StatusCode)
+		return delivery
+	}
+	if !isGenericWebhook(hook) {
+		if err := checkBotResponse(hook.Type, response); err != nil {
+			delivery.Error = err.Error()
+			return delivery
+		}
+	}
+	delivery.Status = models.DeliverySucceeded
+	return delivery
+}
+
+func (s *WebhookService) record(delivery *models.WebhookDelivery) {
+	if err := s.store.AppendWebhookDelivery(delivery); err != nil {
+		slog.Error("保存 Webhook 投递记录失败", "error", err)
+	}
+}
+
+// webhookTemplateFuncs 请求体模板中可以使用的函数
+var webhookTemplateFuncs = template.FuncMap{
+	// json 将值编码为 JSON，字符串会带上引号并转义，便于拼接 JSON 请求体
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"bytes":    formatBytes,
+	"duration": formatDuration,
+}
+
+// renderWebhookBody 按 Webhook 的模板生成请求体，未设置模板时通用 Webhook 使用事件的 JSON，群机器人使用消息卡片
+func renderWebhookBody(hook *models.Webhook, event *models.Event, now time.Time) ([]byte, error) {
+	if hook.BodyTemplate == "" {
+		if !isGenericWebhook(hook) {
+			return botPayload(hook, event, now)
+		}
+		return json.Marshal(event)
+	}
+	tmpl, err := template.New("body").Funcs(webhookTemplateFuncs).Parse(hook.BodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("解析请求体模板失败: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("生成请求体失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// SignWebhookBody 计算请求体的 HMAC-SHA256 签名，接收方使用相同的密钥计算后比较
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateWebhook 检查 Webhook 的渠道类型、地址、事件类型、重试次数和请求体模板
+func ValidateWebhook(hook *models.Webhook) error {
+	if strings.TrimSpace(hook.Name) == "" {
+		return fmt.Errorf("名称不能为空")
+	}
+	switch hook.Type {
+	case "", models.ChannelWebhook, models.ChannelDingTalk, models.ChannelFeishu:
+	case models.ChannelWeCom:
+		if hook.Secret != "" {
+			return fmt.Errorf("企业微信机器人不支持加签密钥")
+		}
+	default:
+		return fmt.Errorf("未知的通知渠道: %s", hook.Type)
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("无效的 Webhook 地址: %s", hook.URL)
+	}
+	for _, eventType := range hook.Events {
+		if !containsString(models.EventTypes, eventType) {
+			return fmt.Errorf("未知的事件类型: %s", eventType)
+		}
+	}
+	if hook.MaxRetries < 0 || hook.MaxRetries > maxWebhookRetries {
+		return fmt.Errorf("重试次数必须在 0 到 %d 之间", maxWebhookRetries)
+	}
+	for name := range hook.Headers {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("无效的请求头: %q", name)
+		}
+	}
+	if hook.BodyTemplate != "" {
+		if _, err := template.New("body").Funcs(webhookTemplateFuncs).Parse(hook.BodyTemplate); err != nil {
+			return fmt.Errorf("请求体模板无效: %v", err)
+		}
+	}
+	return nil
+}
@@ -11,16 +11,24 @@ import (
 )
 
 type BoltStore struct {
-	db *bbolt.DB
+	db     *bbolt.DB
+	cipher *SecretCipher // 加密配置中的数据库密码
 }
 
 var (
-	settingsBucket  = []byte("settings")
-	backupsBucket   = []byte("backups")
-	schedulesBucket = []byte("schedules")
+	settingsBucket   = []byte("settings")
+	backupsBucket    = []byte("backups")
+	schedulesBucket  = []byte("schedules")
+	usersBucket      = []byte("users")
+	sessionsBucket   = []byte("sessions")
+	tokensBucket     = []byte("tokens")
+	auditBucket      = []byte("audit")
+	webhooksBucket   = []byte("webhooks")
+	deliveryBucket   = []byte("webhook_deliveries")
+	backupLogsBucket = []byte("backup_logs")
 )
 
-func NewBoltStore(dbPath string) (*BoltStore, error) {
+func NewBoltStore(dbPath string, cipher *SecretCipher) (*BoltStore, error) {
 	db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return nil, fmt.Errorf("open bolt db: %v", err)
@@ -28,7 +36,7 @@ func NewBoltStore(dbPath string) (*BoltStore, error) {
 
 	// 创建 buckets
 	err = db.Update(func(tx *bbolt.Tx) error {
-		for _, bucket := range [][]byte{settingsBucket, backupsBucket, schedulesBucket} {
+		for _, bucket := range [][]byte{settingsBucket, backupsBucket, schedulesBucket, usersBucket, sessionsBucket, tokensBucket, auditBucket, webhooksBucket, deliveryBucket, backupLogsBucket} {
 			_, err := tx.CreateBucketIfNotExists(bucket)
 			if err != nil {
 				return fmt.Errorf("create bucket %s: %v", bucket, err)
@@ -40,7 +48,7 @@ func NewBoltStore(dbPath string) (*BoltStore, error) {
 		return nil, fmt.Errorf("create buckets: %v", err)
 	}
 
-	return &BoltStore{db: db}, nil
+	return &BoltStore{db: db, cipher: cipher}, nil
 }
 
 func (s *BoltStore) SaveSettings(settings *models.DBSettings) error {
@@ -53,9 +61,9 @@ func (s *BoltStore) SaveSettings(settings *models.DBSettings) error {
 		}
 
 		key := []byte(fmt.Sprintf("%d", settings.ID))
-		value, err := json.Marshal(settings)
+		value, err := s.encodeSettings(settings, s.cipher)
 		if err != nil {
-			return fmt.Errorf("marshal settings: %v", err)
+			return err
 		}
 
 		return b.Put(key, value)
@@ -68,11 +76,11 @@ func (s *BoltStore) GetAllSettings() ([]models.DBSettings, error) {
 	err := s.db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(settingsBucket)
 		return b.ForEach(func(k, v []byte) error {
-			var setting models.DBSettings
-			if err := json.Unmarshal(v, &setting); err != nil {
-				return fmt.Errorf("unmarshal settings: %v", err)
+			setting, err := s.decodeSettings(v)
+			if err != nil {
+				return err
 			}
-			settings = append(settings, setting)
+			settings = append(settings, *setting)
 			return nil
 		})
 	})
@@ -156,8 +164,81 @@ func (s *BoltStore) GetBackupRecordsBySettingID(settingID int) ([]*models.Backup
 
 func (s *BoltStore) DeleteBackupRecord(id int) error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
+		key := []byte(fmt.Sprintf("%d", id))
+		if err := tx.Bucket(backupLogsBucket).Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(backupsBucket).Delete(key)
+	})
+}
+
+// SaveBackupLog 保存备份任务的日志，同一记录再次保存时覆盖
+func (s *BoltStore) SaveBackupLog(id int, content string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(backupLogsBucket).Put([]byte(fmt.Sprintf("%d", id)), []byte(content))
+	})
+}
+
+// GetBackupLog 返回备份任务的日志，没有保存日志时返回空字符串
+func (s *BoltStore) GetBackupLog(id int) (string, error) {
+	var content string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		content = string(tx.Bucket(backupLogsBucket).Get([]byte(fmt.Sprintf("%d", id))))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("get backup log: %v", err)
+	}
+	return content, nil
+}
+
+func (s *BoltStore) GetBackupRecordByID(id int) (*models.BackupRecord, error) {
+	var record *models.BackupRecord
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(backupsBucket)
-		return b.Delete([]byte(fmt.Sprintf("%d", id)))
+		v := b.Get([]byte(fmt.Sprintf("%d", id)))
+		if v == nil {
+			return fmt.Errorf("backup record not found: %d", id)
+		}
+
+		record = &models.BackupRecord{}
+		return json.Unmarshal(v, record)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get backup record by id: %v", err)
+	}
+
+	return record, nil
+}
+
+func (s *BoltStore) PruneBackupRecord(id int, removeFile func(record *models.BackupRecord) error) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(backupsBucket)
+		key := []byte(fmt.Sprintf("%d", id))
+		v := b.Get(key)
+		if v == nil {
+			return fmt.Errorf("backup record not found: %d", id)
+		}
+
+		var record models.BackupRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return fmt.Errorf("unmarshal backup record: %v", err)
+		}
+
+		if err := removeFile(&record); err != nil {
+			return err
+		}
+
+		record.Status = models.StatusPruned
+		record.PrunedAt = time.Now().Format("2006-01-02 15:04:05")
+		value, err := json.Marshal(&record)
+		if err != nil {
+			return fmt.Errorf("marshal backup record: %v", err)
+		}
+
+		return b.Put(key, value)
 	})
 }
 
@@ -165,6 +246,16 @@ func (s *BoltStore) Close() error {
 	return s.db.Close()
 }
 
+// Ping 开启一个只读事务，检查数据库是否可用
+func (s *BoltStore) Ping() error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(backupsBucket) == nil {
+			return fmt.Errorf("bucket %s not found", backupsBucket)
+		}
+		return nil
+	})
+}
+
 func (s *BoltStore) GetSettingByID(id int) (*models.DBSettings, error) {
 	var setting *models.DBSettings
 
@@ -175,8 +266,9 @@ func (s *BoltStore) GetSettingByID(id int) (*models.DBSettings, error) {
 			return fmt.Errorf("setting not found: %d", id)
 		}
 
-		setting = &models.DBSettings{}
-		return json.Unmarshal(v, setting)
+		var err error
+		setting, err = s.decodeSettings(v)
+		return err
 	})
 
 	if err != nil {
@@ -249,6 +341,13 @@ func (s *BoltStore) DeleteSchedule(id int) error {
 	})
 }
 
+func (s *BoltStore) DeleteSettings(id int) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(settingsBucket)
+		return b.Delete([]byte(fmt.Sprintf("%d", id)))
+	})
+}
+
 func (s *BoltStore) GetBackupRecordsWithPage(page, pageSize int) (int, []*models.BackupRecord, error) {
 	var records []*models.BackupRecord
 	var total int
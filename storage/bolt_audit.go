@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mysql-backup/models"
+
+	"go.etcd.io/bbolt"
+)
+
+// auditKey 使用大端序编码 ID，保证游标按写入顺序遍历
+func auditKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// AuditEntryHash 计算审计日志的哈希，覆盖除 Hash 以外的所有字段（包括 PrevHash）
+func AuditEntryHash(entry *models.AuditEntry) (string, error) {
+	copied := *entry
+	copied.Hash = ""
+	value, err := json.Marshal(&copied)
+	if err != nil {
+		return "", fmt.Errorf("marshal audit entry: %v", err)
+	}
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (s *BoltStore) AppendAuditEntry(entry *models.AuditEntry) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+
+		// 在同一事务中读取上一条日志，保证哈希链不分叉
+		entry.PrevHash = ""
+		if _, v := b.Cursor().Last(); v != nil {
+			var last models.AuditEntry
+			if err := json.Unmarshal(v, &last); err != nil {
+				return fmt.Errorf("unmarshal audit entry: %v", err)
+			}
+			entry.PrevHash = last.Hash
+		}
+
+		id, _ := b.NextSequence()
+		entry.ID = int(id)
+
+		hash, err := AuditEntryHash(entry)
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("marshal audit entry: %v", err)
+		}
+
+		return b.Put(auditKey(entry.ID), value)
+	})
+}
+
+func (s *BoltStore) GetAuditEntries() ([]*models.AuditEntry, error) {
+	var entries []*models.AuditEntry
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		return b.ForEach(func(k, v []byte) error {
+			var entry models.AuditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("unmarshal audit entry: %v", err)
+			}
+			entries = append(entries, &entry)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get audit entries: %v", err)
+	}
+
+	return entries, nil
+}
+
+// AuditSequence 返回审计日志 bucket 的序列号，即写入过的最后一条日志的 ID，用于发现末尾被删除的日志
+func (s *BoltStore) AuditSequence() (int, error) {
+	var seq uint64
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		seq = tx.Bucket(auditBucket).Sequence()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("get audit sequence: %v", err)
+	}
+	return int(seq), nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"go.etcd.io/bbolt"
+)
+
+// StoreStats BoltDB 文件大小、各 bucket 的记录数和事务统计，用于监控指标
+type StoreStats struct {
+	Size         int64          // 数据库文件大小（字节）
+	Keys         map[string]int // 各 bucket 的记录数
+	FreePages    int            // 空闲页数
+	PendingPages int            // 等待释放的页数
+	ReadTx       int            // 已开始的只读事务总数
+	OpenReadTx   int            // 当前打开的只读事务数
+	Writes       int64          // 写入页的总次数
+	WriteSeconds float64        // 写入页的总耗时（秒）
+}
+
+func (s *BoltStore) Stats() (*StoreStats, error) {
+	dbStats := s.db.Stats()
+	stats := &StoreStats{
+		Keys:         make(map[string]int),
+		FreePages:    dbStats.FreePageN,
+		PendingPages: dbStats.PendingPageN,
+		ReadTx:       dbStats.TxN,
+		OpenReadTx:   dbStats.OpenTxN,
+		Writes:       dbStats.TxStats.GetWrite(),
+		WriteSeconds: dbStats.TxStats.GetWriteTime().Seconds(),
+	}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		stats.Size = tx.Size()
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			stats.Keys[string(name)] = b.Stats().KeyN
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mysql-backup/models"
+
+	"go.etcd.io/bbolt"
+)
+
+func (s *BoltStore) SaveUser(user *models.User) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+
+		// 用户名必须唯一
+		err := b.ForEach(func(k, v []byte) error {
+			var existing models.User
+			if err := json.Unmarshal(v, &existing); err != nil {
+				return fmt.Errorf("unmarshal user: %v", err)
+			}
+			if existing.Username == user.Username && existing.ID != user.ID {
+				return fmt.Errorf("username already exists: %s", user.Username)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if user.ID == 0 {
+			id, _ := b.NextSequence()
+			user.ID = int(id)
+		}
+
+		value, err := json.Marshal(user)
+		if err != nil {
+			return fmt.Errorf("marshal user: %v", err)
+		}
+
+		return b.Put([]byte(fmt.Sprintf("%d", user.ID)), value)
+	})
+}
+
+// ErrUsersExist 创建首个用户时已经存在用户
+var ErrUsersExist = errors.New("users already exist")
+
+// CreateFirstUser 在同一事务中检查没有任何用户并保存用户，已存在用户时返回 ErrUsersExist
+func (s *BoltStore) CreateFirstUser(user *models.User) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		if k, _ := b.Cursor().First(); k != nil {
+			return ErrUsersExist
+		}
+
+		id, _ := b.NextSequence()
+		user.ID = int(id)
+		value, err := json.Marshal(user)
+		if err != nil {
+			return fmt.Errorf("marshal user: %v", err)
+		}
+		return b.Put([]byte(fmt.Sprintf("%d", user.ID)), value)
+	})
+}
+
+func (s *BoltStore) GetAllUsers() ([]*models.User, error) {
+	var users []*models.User
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		return b.ForEach(func(k, v []byte) error {
+			var user models.User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return fmt.Errorf("unmarshal user: %v", err)
+			}
+			users = append(users, &user)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get users: %v", err)
+	}
+
+	return users, nil
+}
+
+func (s *BoltStore) GetUserByID(id int) (*models.User, error) {
+	var user *models.User
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		v := b.Get([]byte(fmt.Sprintf("%d", id)))
+		if v == nil {
+			return fmt.Errorf("user not found: %d", id)
+		}
+
+		user = &models.User{}
+		return json.Unmarshal(v, user)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get user by id: %v", err)
+	}
+
+	return user, nil
+}
+
+func (s *BoltStore) GetUserByUsername(username string) (*models.User, error) {
+	users, err := s.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+
+	return nil, fmt.Errorf("user not found: %s", username)
+}
+
+func (s *BoltStore) DeleteUser(id int) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		return b.Delete([]byte(fmt.Sprintf("%d", id)))
+	})
+}
+
+func (s *BoltStore) CountUsers() (int, error) {
+	var count int
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		count = tx.Bucket(usersBucket).Stats().KeyN
+		return nil
+	})
+
+	return count, err
+}
+
+// TouchUserLogin 在同一事务中重新读取用户并只更新最后登录时间，不会覆盖并发修改的角色和授权
+func (s *BoltStore) TouchUserLogin(id int, loginAt string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		key := []byte(fmt.Sprintf("%d", id))
+		v := b.Get(key)
+		if v == nil {
+			return fmt.Errorf("user not found: %d", id)
+		}
+
+		var user models.User
+		if err := json.Unmarshal(v, &user); err != nil {
+			return fmt.Errorf("unmarshal user: %v", err)
+		}
+		user.LastLoginAt = loginAt
+
+		value, err := json.Marshal(&user)
+		if err != nil {
+			return fmt.Errorf("marshal user: %v", err)
+		}
+		return b.Put(key, value)
+	})
+}
+
+func (s *BoltStore) SaveSession(session *models.Session) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+
+		value, err := json.Marshal(session)
+		if err != nil {
+			return fmt.Errorf("marshal session: %v", err)
+		}
+
+		return b.Put([]byte(session.ID), value)
+	})
+}
+
+func (s *BoltStore) GetSession(id string) (*models.Session, error) {
+	var session *models.Session
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		v := b.Get([]byte(id))
+		if v == nil {
+			return fmt.Errorf("session not found")
+		}
+
+		session = &models.Session{}
+		return json.Unmarshal(v, session)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get session: %v", err)
+	}
+
+	return session, nil
+}
+
+func (s *BoltStore) DeleteSession(id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		return b.Delete([]byte(id))
+	})
+}
+
+// DeleteExpiredSessions 删除过期时间早于 now 的会话，返回删除的数量。时间格式固定，可以直接按字符串比较
+func (s *BoltStore) DeleteExpiredSessions(now string) (int, error) {
+	var count int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var session models.Session
+			if err := json.Unmarshal(v, &session); err != nil {
+				return fmt.Errorf("unmarshal session: %v", err)
+			}
+			if session.ExpiresAt < now {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("delete expired sessions: %v", err)
+	}
+	return count, nil
+}
+
+func (s *BoltStore) DeleteUserSessions(userID int) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var session models.Session
+			if err := json.Unmarshal(v, &session); err != nil {
+				return fmt.Errorf("unmarshal session: %v", err)
+			}
+			if session.UserID == userID {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) SaveAPIToken(token *models.APIToken) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+
+		if token.ID == 0 {
+			id, _ := b.NextSequence()
+			token.ID = int(id)
+		}
+
+		value, err := json.Marshal(token)
+		if err != nil {
+			return fmt.Errorf("marshal api token: %v", err)
+		}
+
+		return b.Put([]byte(fmt.Sprintf("%d", token.ID)), value)
+	})
+}
+
+// TouchAPIToken 在同一事务中重新读取令牌并只更新最后使用时间和 IP，不会覆盖并发的吊销
+func (s *BoltStore) TouchAPIToken(id int, usedAt, ip string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		key := []byte(fmt.Sprintf("%d", id))
+		v := b.Get(key)
+		if v == nil {
+			return fmt.Errorf("api token not found: %d", id)
+		}
+
+		var token models.APIToken
+		if err := json.Unmarshal(v, &token); err != nil {
+			return fmt.Errorf("unmarshal api token: %v", err)
+		}
+		token.LastUsedAt = usedAt
+		token.LastUsedIP = ip
+
+		value, err := json.Marshal(&token)
+		if err != nil {
+			return fmt.Errorf("marshal api token: %v", err)
+		}
+		return b.Put(key, value)
+	})
+}
+
+func (s *BoltStore) GetAllAPITokens() ([]*models.APIToken, error) {
+	var tokens []*models.APIToken
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		return b.ForEach(func(k, v []byte) error {
+			var token models.APIToken
+			if err := json.Unmarshal(v, &token); err != nil {
+				return fmt.Errorf("unmarshal api token: %v", err)
+			}
+			tokens = append(tokens, &token)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get api tokens: %v", err)
+	}
+
+	return tokens, nil
+}
+
+func (s *BoltStore) GetAPITokenByID(id int) (*models.APIToken, error) {
+	var token *models.APIToken
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		v := b.Get([]byte(fmt.Sprintf("%d", id)))
+		if v == nil {
+			return fmt.Errorf("api token not found: %d", id)
+		}
+
+		token = &models.APIToken{}
+		return json.Unmarshal(v, token)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get api token by id: %v", err)
+	}
+
+	return token, nil
+}
+
+func (s *BoltStore) GetAPITokenByHash(hash string) (*models.APIToken, error) {
+	tokens, err := s.GetAllAPITokens()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+
+	return nil, fmt.Errorf("api token not found")
+}
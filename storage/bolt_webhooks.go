@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"mysql-backup/models"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// MaxWebhookDeliveries 保留的投递记录数量，超出后删除最早的记录
+const MaxWebhookDeliveries = 1000
+
+// webhookSecretAAD 签名密钥密文绑定的记录
+func webhookSecretAAD(id int) string {
+	return fmt.Sprintf("webhook:%d:secret", id)
+}
+
+// webhookHeaderAAD 请求头密文绑定的记录和请求头名称
+func webhookHeaderAAD(id int, name string) string {
+	return fmt.Sprintf("webhook:%d:header:%s", id, name)
+}
+
+// encodeWebhook 使用指定密钥加密签名密钥和请求头的值后序列化，不修改传入的 Webhook
+func (s *BoltStore) encodeWebhook(hook *models.Webhook, c *SecretCipher) ([]byte, error) {
+	stored := *hook
+	secret, err := c.Encrypt(hook.Secret, webhookSecretAAD(hook.ID))
+	if err != nil {
+		return nil, fmt.Errorf("encrypt webhook %d: %v", hook.ID, err)
+	}
+	stored.Secret = secret
+	if hook.Headers != nil {
+		stored.Headers = make(map[string]string, len(hook.Headers))
+		for name, value := range hook.Headers {
+			if stored.Headers[name], err = c.Encrypt(value, webhookHeaderAAD(hook.ID, name)); err != nil {
+				return nil, fmt.Errorf("encrypt webhook %d header %s: %v", hook.ID, name, err)
+			}
+		}
+	}
+
+	value, err := json.Marshal(&stored)
+	if err != nil {
+		return nil, fmt.Errorf("marshal webhook: %v", err)
+	}
+	return value, nil
+}
+
+// decodeWebhook 反序列化 Webhook 并解密签名密钥和请求头的值
+func (s *BoltStore) decodeWebhook(value []byte) (*models.Webhook, error) {
+	var hook models.Webhook
+	if err := json.Unmarshal(value, &hook); err != nil {
+		return nil, fmt.Errorf("unmarshal webhook: %v", err)
+	}
+	secret, err := s.cipher.Decrypt(hook.Secret, webhookSecretAAD(hook.ID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt webhook %d: %v", hook.ID, err)
+	}
+	hook.Secret = secret
+	for name, value := range hook.Headers {
+		if hook.Headers[name], err = s.cipher.Decrypt(value, webhookHeaderAAD(hook.ID, name)); err != nil {
+			return nil, fmt.Errorf("decrypt webhook %d header %s: %v", hook.ID, name, err)
+		}
+	}
+	return &hook, nil
+}
+
+// webhookEncryptedWith 判断 Webhook 的签名密钥和请求头是否都已由指定密钥加密
+func webhookEncryptedWith(hook *models.Webhook, keyID string) bool {
+	prefix := encryptedPrefix + keyID + ":"
+	if hook.Secret != "" && !strings.HasPrefix(hook.Secret, prefix) {
+		return false
+	}
+	for _, value := range hook.Headers {
+		if value != "" && !strings.HasPrefix(value, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *BoltStore) SaveWebhook(hook *models.Webhook) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(webhooksBucket)
+
+		if hook.ID == 0 {
+			id, _ := b.NextSequence()
+			hook.ID = int(id)
+		}
+
+		value, err := s.encodeWebhook(hook, s.cipher)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(fmt.Sprintf("%d", hook.ID)), value)
+	})
+}
+
+func (s *BoltStore) GetAllWebhooks() ([]*models.Webhook, error) {
+	var hooks []*models.Webhook
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(webhooksBucket)
+		return b.ForEach(func(k, v []byte) error {
+			hook, err := s.decodeWebhook(v)
+			if err != nil {
+				return err
+			}
+			hooks = append(hooks, hook)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get webhooks: %v", err)
+	}
+
+	return hooks, nil
+}
+
+func (s *BoltStore) GetWebhookByID(id int) (*models.Webhook, error) {
+	var hook *models.Webhook
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(webhooksBucket)
+		v := b.Get([]byte(fmt.Sprintf("%d", id)))
+		if v == nil {
+			return fmt.Errorf("webhook not found: %d", id)
+		}
+
+		var err error
+		hook, err = s.decodeWebhook(v)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+func (s *BoltStore) DeleteWebhook(id int) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(webhooksBucket)
+		return b.Delete([]byte(fmt.Sprintf("%d", id)))
+	})
+}
+
+func (s *BoltStore) AppendWebhookDelivery(delivery *models.WebhookDelivery) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(deliveryBucket)
+
+		id, _ := b.NextSequence()
+		delivery.ID = int(id)
+
+		value, err := json.Marshal(delivery)
+		if err != nil {
+			return fmt.Errorf("marshal webhook delivery: %v", err)
+		}
+		// 与审计日志相同使用大端序的 ID 作为键，游标按写入顺序遍历
+		if err := b.Put(auditKey(delivery.ID), value); err != nil {
+			return err
+		}
+
+		// 删除超出保留数量的最早记录
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && int(binary.BigEndian.Uint64(k)) <= delivery.ID-MaxWebhookDeliveries; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetWebhookDeliveries 按时间倒序返回投递记录，webhookID 为 0 时返回所有 Webhook 的记录
+func (s *BoltStore) GetWebhookDeliveries(webhookID, limit int) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(deliveryBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(deliveries) >= limit {
+				break
+			}
+			var delivery models.WebhookDelivery
+			if err := json.Unmarshal(v, &delivery); err != nil {
+				return fmt.Errorf("unmarshal webhook delivery: %v", err)
+			}
+			if webhookID != 0 && delivery.WebhookID != webhookID {
+				continue
+			}
+			deliveries = append(deliveries, &delivery)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("get webhook deliveries: %v", err)
+	}
+
+	return deliveries, nil
+}
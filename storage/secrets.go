@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mysql-backup/models"
+	"os"
+	"strings"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedPrefix 加密后的密钥值前缀，格式为 enc:v1:<密钥指纹>:<base64(nonce|密文)>
+const encryptedPrefix = "enc:v1:"
+
+// SecretCipher 使用主密钥（AES-256-GCM）加密存储在数据库中的敏感字段
+type SecretCipher struct {
+	aead  cipher.AEAD
+	keyID string
+}
+
+func NewSecretCipher(key []byte) (*SecretCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %v", err)
+	}
+	sum := sha256.Sum256(key)
+	return &SecretCipher{aead: aead, keyID: hex.EncodeToString(sum[:4])}, nil
+}
+
+// KeyID 主密钥指纹，用于识别密文由哪个密钥加密
+func (c *SecretCipher) KeyID() string {
+	return c.keyID
+}
+
+// Encrypt 加密敏感字段，aad 将密文绑定到所属记录，防止密文被挪用到其他记录
+func (c *SecretCipher) Encrypt(plaintext, aad string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %v", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return encryptedPrefix + c.keyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密敏感字段，未加密的旧数据原样返回
+func (c *SecretCipher) Decrypt(value, aad string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	if keyID != c.keyID {
+		return "", fmt.Errorf("secret was encrypted with key %s, current key is %s", keyID, c.keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decode encrypted secret: %v", err)
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncryptedSecret 判断字段是否已加密
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// LoadMasterKey 按顺序从环境变量 DATASAFE_MASTER_KEY、DATASAFE_MASTER_KEY_FILE 指定的文件
+// 或 keyFile 读取主密钥；都没有时生成新密钥写入 keyFile。返回密钥和来源说明
+func LoadMasterKey(keyFile string) ([]byte, string, error) {
+	if value := os.Getenv("DATASAFE_MASTER_KEY"); value != "" {
+		key, err := ParseMasterKey(value)
+		if err != nil {
+			return nil, "", fmt.Errorf("DATASAFE_MASTER_KEY: %v", err)
+		}
+		return key, "env:DATASAFE_MASTER_KEY", nil
+	}
+
+	if path := os.Getenv("DATASAFE_MASTER_KEY_FILE"); path != "" {
+		key, err := ReadKeyFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return key, "file:" + path, nil
+	}
+
+	key, err := ReadKeyFile(keyFile)
+	if os.IsNotExist(err) {
+		key, err = GenerateKeyFile(keyFile)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return key, "keyfile:" + keyFile, nil
+}
+
+// ParseMasterKey 解析 base64 或十六进制编码的 32 字节主密钥
+func ParseMasterKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("master key must be 32 bytes encoded as base64 or hex")
+}
+
+// ReadKeyFile 读取密钥文件
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseMasterKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %v", path, err)
+	}
+	return key, nil
+}
+
+// GenerateKeyFile 生成随机主密钥并以 0600 权限写入文件，文件已存在时报错
+func GenerateKeyFile(path string) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate master key: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("create key file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		return nil, fmt.Errorf("write key file: %v", err)
+	}
+	return key, f.Sync()
+}
+
+// 口令派生密钥使用的 scrypt 参数
+const (
+	ScryptN = 1 << 15
+	ScryptR = 8
+	ScryptP = 1
+)
+
+// NewPassphraseCipher 使用 scrypt 从口令派生密钥，用于加密导出文件中的敏感字段
+func NewPassphraseCipher(passphrase string, salt []byte, n, r, p int) (*SecretCipher, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase is empty")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %v", err)
+	}
+	return NewSecretCipher(key)
+}
+
+// settingsSecret 配置中需要加密保存的字段
+type settingsSecret struct {
+	value *string
+	aad   string // 密文绑定的记录和字段
+}
+
+// settingsSecrets 返回配置中所有需要加密的字段
+func settingsSecrets(settings *models.DBSettings) []settingsSecret {
+	secrets := []settingsSecret{
+		{value: &settings.Password, aad: fmt.Sprintf("settings:%d:password", settings.ID)},
+	}
+	if settings.TLS != nil {
+		secrets = append(secrets, settingsSecret{value: &settings.TLS.ClientKey, aad: fmt.Sprintf("settings:%d:tls.clientKey", settings.ID)})
+	}
+	if settings.SSH != nil {
+		secrets = append(secrets,
+			settingsSecret{value: &settings.SSH.Password, aad: fmt.Sprintf("settings:%d:ssh.password", settings.ID)},
+			settingsSecret{value: &settings.SSH.PrivateKey, aad: fmt.Sprintf("settings:%d:ssh.privateKey", settings.ID)},
+			settingsSecret{value: &settings.SSH.Passphrase, aad: fmt.Sprintf("settings:%d:ssh.passphrase", settings.ID)},
+		)
+	}
+	return secrets
+}
+
+// CopySettings 复制配置，TLS 和 SSH 配置一并复制，修改副本中的敏感字段不影响原配置
+func CopySettings(settings *models.DBSettings) *models.DBSettings {
+	copied := *settings
+	if settings.TLS != nil {
+		tls := *settings.TLS
+		copied.TLS = &tls
+	}
+	if settings.SSH != nil {
+		tunnel := *settings.SSH
+		copied.SSH = &tunnel
+	}
+	return &copied
+}
+
+// EncryptSettingsSecrets 返回敏感字段已使用指定密钥加密的配置副本
+func EncryptSettingsSecrets(settings *models.DBSettings, c *SecretCipher) (*models.DBSettings, error) {
+	encrypted := CopySettings(settings)
+	for _, secret := range settingsSecrets(encrypted) {
+		value, err := c.Encrypt(*secret.value, secret.aad)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt settings %d: %v", settings.ID, err)
+		}
+		*secret.value = value
+	}
+	return encrypted, nil
+}
+
+// DecryptSettingsSecrets 使用指定密钥解密配置中的敏感字段
+func DecryptSettingsSecrets(settings *models.DBSettings, c *SecretCipher) error {
+	for _, secret := range settingsSecrets(settings) {
+		plaintext, err := c.Decrypt(*secret.value, secret.aad)
+		if err != nil {
+			return fmt.Errorf("decrypt settings %d: %v", settings.ID, err)
+		}
+		*secret.value = plaintext
+	}
+	return nil
+}
+
+// ClearSettingsSecrets 清空配置中的敏感字段
+func ClearSettingsSecrets(settings *models.DBSettings) {
+	for _, secret := range settingsSecrets(settings) {
+		*secret.value = ""
+	}
+}
+
+// encodeSettings 使用指定密钥加密敏感字段后序列化配置，不修改传入的配置
+func (s *BoltStore) encodeSettings(settings *models.DBSettings, c *SecretCipher) ([]byte, error) {
+	stored, err := EncryptSettingsSecrets(settings, c)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("marshal settings: %v", err)
+	}
+	return value, nil
+}
+
+// decodeSettings 反序列化配置并解密敏感字段
+func (s *BoltStore) decodeSettings(value []byte) (*models.DBSettings, error) {
+	var setting models.DBSettings
+	if err := json.Unmarshal(value, &setting); err != nil {
+		return nil, fmt.Errorf("unmarshal settings: %v", err)
+	}
+	if err := DecryptSettingsSecrets(&setting, s.cipher); err != nil {
+		return nil, err
+	}
+	return &setting, nil
+}
+
+// ReencryptSecrets 在一个事务中用新密钥重新加密所有配置中的敏感字段和 Webhook 签名密钥、请求头，成功后切换到新密钥。
+// 传入当前密钥时只加密尚未加密的旧数据，同时可以在启动时发现主密钥配置错误
+func (s *BoltStore) ReencryptSecrets(next *SecretCipher) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(settingsBucket)
+
+		// 遍历时不能修改 bucket，先收集再写入
+		updates := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			var raw models.DBSettings
+			if err := json.Unmarshal(v, &raw); err != nil {
+				return fmt.Errorf("unmarshal settings: %v", err)
+			}
+			// 已经由新密钥加密的无需处理；由其他密钥加密的会在解密时报错
+			current := true
+			for _, secret := range settingsSecrets(&raw) {
+				if *secret.value != "" && !strings.HasPrefix(*secret.value, encryptedPrefix+next.KeyID()+":") {
+					current = false
+				}
+			}
+			if current {
+				return nil
+			}
+
+			setting, err := s.decodeSettings(v)
+			if err != nil {
+				return err
+			}
+			value, err := s.encodeSettings(setting, next)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range updates {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		count = len(updates)
+
+		// Webhook 的签名密钥和请求头同样使用主密钥加密
+		hooks := tx.Bucket(webhooksBucket)
+		updates = make(map[string][]byte)
+		err = hooks.ForEach(func(k, v []byte) error {
+			var raw models.Webhook
+			if err := json.Unmarshal(v, &raw); err != nil {
+				return fmt.Errorf("unmarshal webhook: %v", err)
+			}
+			if webhookEncryptedWith(&raw, next.KeyID()) {
+				return nil
+			}
+
+			hook, err := s.decodeWebhook(v)
+			if err != nil {
+				return err
+			}
+			value, err := s.encodeWebhook(hook, next)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range updates {
+			if err := hooks.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		count += len(updates)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reencrypt secrets: %v", err)
+	}
+
+	s.cipher = next
+	return count, nil
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"mysql-backup/models"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func newTestCipher(t *testing.T, fill byte) *SecretCipher {
+	t.Helper()
+	c, err := NewSecretCipher(bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func openTestStore(t *testing.T, path string, c *SecretCipher) *BoltStore {
+	t.Helper()
+	store, err := NewBoltStore(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSecretCipherRoundTrip(t *testing.T) {
+	c := newTestCipher(t, 1)
+	for _, plaintext := range []string{"p@ss:word", "密码", strings.Repeat("x", 4096)} {
+		encrypted, err := c.Encrypt(plaintext, "settings:1:password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncryptedSecret(encrypted) || strings.Contains(encrypted, plaintext) {
+			t.Fatalf("encrypted = %q", encrypted)
+		}
+		if !strings.HasPrefix(encrypted, "enc:v1:"+c.KeyID()+":") {
+			t.Fatalf("encrypted = %q, want key id %s", encrypted, c.KeyID())
+		}
+		again, _ := c.Encrypt(plaintext, "settings:1:password")
+		if again == encrypted {
+			t.Fatal("nonce reused")
+		}
+		decrypted, err := c.Decrypt(encrypted, "settings:1:password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != plaintext {
+			t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+		}
+	}
+
+	if encrypted, err := c.Encrypt("", "settings:1:password"); err != nil || encrypted != "" {
+		t.Fatalf("empty secret encrypted to %q (%v)", encrypted, err)
+	}
+	if plaintext, err := c.Decrypt("legacy", "settings:1:password"); err != nil || plaintext != "legacy" {
+		t.Fatalf("legacy secret = %q (%v)", plaintext, err)
+	}
+}
+
+func TestSecretCipherDecryptErrors(t *testing.T) {
+	c := newTestCipher(t, 1)
+	encrypted, err := c.Encrypt("secret", "settings:1:password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := []byte(encrypted)
+	tampered[len(tampered)-3] ^= 1
+
+	tests := []struct {
+		name    string
+		cipher  *SecretCipher
+		value   string
+		aad     string
+		wantErr string
+	}{
+		{name: "other setting", cipher: c, value: encrypted, aad: "settings:2:password", wantErr: "decrypt secret"},
+		{name: "other field", cipher: c, value: encrypted, aad: "settings:1:ssh.password", wantErr: "decrypt secret"},
+		{name: "other key", cipher: newTestCipher(t, 2), value: encrypted, aad: "settings:1:password", wantErr: "encrypted with key"},
+		{name: "tampered", cipher: c, value: string(tampered), aad: "settings:1:password", wantErr: "secret"},
+		{name: "malformed", cipher: c, value: "enc:v1:nokey", aad: "settings:1:password", wantErr: "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.cipher.Decrypt(tt.value, tt.aad); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSettingsCiphertextBoundToRecord(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "data.db"), newTestCipher(t, 1))
+	defer store.Close()
+	for _, setting := range []*models.DBSettings{{Name: "prod", Password: "prod-pass"}, {Name: "dev", Password: "dev-pass"}} {
+		if err := store.SaveSettings(setting); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 把 prod 的密码密文复制到 dev，模拟直接修改数据文件
+	err := store.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(settingsBucket)
+		var prod, dev models.DBSettings
+		if err := json.Unmarshal(b.Get([]byte("1")), &prod); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b.Get([]byte("2")), &dev); err != nil {
+			return err
+		}
+		dev.Password = prod.Password
+		value, err := json.Marshal(&dev)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("2"), value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetAllSettings(); err == nil || !strings.Contains(err.Error(), "decrypt settings 2") {
+		t.Fatalf("err = %v, want decrypt failure for settings 2", err)
+	}
+}
+
+func TestReencryptSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	oldKey, newKey := newTestCipher(t, 1), newTestCipher(t, 2)
+
+	store := openTestStore(t, path, oldKey)
+	setting := &models.DBSettings{
+		Name:     "prod",
+		Password: "db-pass",
+		TLS:      &models.TLSSettings{Mode: "verify-ca", ClientKey: "tls-key"},
+		SSH:      &models.SSHTunnel{Host: "bastion", User: "tunnel", Password: "ssh-pass", PrivateKey: "ssh-key", Passphrase: "ssh-phrase"},
+	}
+	if err := store.SaveSettings(setting); err != nil {
+		t.Fatal(err)
+	}
+	hook := &models.Webhook{Name: "ops", URL: "https://hooks.example.com", Secret: "hook-secret", Headers: map[string]string{"Authorization": "Bearer t"}}
+	if err := store.SaveWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := store.ReencryptSecrets(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("reencrypted %d records, want 2", count)
+	}
+	// 再次执行时所有字段已由新密钥加密
+	if count, err := store.ReencryptSecrets(newKey); err != nil || count != 0 {
+		t.Fatalf("second rotation = %d (%v), want 0", count, err)
+	}
+	store.Close()
+
+	// 旧密钥无法再打开数据
+	stale := openTestStore(t, path, oldKey)
+	if _, err := stale.GetAllSettings(); err == nil || !strings.Contains(err.Error(), "encrypted with key") {
+		t.Fatalf("old key err = %v", err)
+	}
+	stale.Close()
+
+	store = openTestStore(t, path, newKey)
+	defer store.Close()
+	settings, err := store.GetAllSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := settings[0]
+	if got.Password != "db-pass" || got.TLS.ClientKey != "tls-key" || got.SSH.Password != "ssh-pass" || got.SSH.PrivateKey != "ssh-key" || got.SSH.Passphrase != "ssh-phrase" {
+		t.Fatalf("settings after rotation = %+v, tls = %+v, ssh = %+v", got, got.TLS, got.SSH)
+	}
+	gotHook, err := store.GetWebhookByID(hook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotHook.Secret != "hook-secret" || gotHook.Headers["Authorization"] != "Bearer t" {
+		t.Fatalf("webhook after rotation = %+v", gotHook)
+	}
+}
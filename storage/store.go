@@ -8,6 +8,7 @@ type Store interface {
 	SaveSettings(settings *models.DBSettings) error
 	GetAllSettings() ([]models.DBSettings, error)
 	GetSettingByID(id int) (*models.DBSettings, error)
+	DeleteSettings(id int) error
 
 	// 备份记录相关
 	SaveBackupRecord(record *models.BackupRecord) error
@@ -15,12 +16,65 @@ type Store interface {
 	GetBackupRecords() ([]*models.BackupRecord, error)
 	GetBackupRecordsBySettingID(settingID int) ([]*models.BackupRecord, error)
 	DeleteBackupRecord(id int) error
+	GetBackupRecordByID(id int) (*models.BackupRecord, error)
+	// PruneBackupRecord 在同一事务中执行 removeFile 并将记录标记为已清理，removeFile 失败时记录保持不变
+	PruneBackupRecord(id int, removeFile func(record *models.BackupRecord) error) error
+	// 备份任务的日志单独保存，删除备份记录时一并删除
+	SaveBackupLog(id int, content string) error
+	GetBackupLog(id int) (string, error)
 
 	// 定时任务相关
 	SaveSchedule(task *models.ScheduledTask) error
 	GetAllSchedules() ([]*models.ScheduledTask, error)
 	DeleteSchedule(id int) error
 
+	// 用户相关
+	SaveUser(user *models.User) error
+	// CreateFirstUser 仅在没有任何用户时保存用户，用于首次初始化
+	CreateFirstUser(user *models.User) error
+	GetAllUsers() ([]*models.User, error)
+	GetUserByID(id int) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
+	DeleteUser(id int) error
+	CountUsers() (int, error)
+	// TouchUserLogin 只更新最后登录时间
+	TouchUserLogin(id int, loginAt string) error
+
+	// 会话相关
+	SaveSession(session *models.Session) error
+	GetSession(id string) (*models.Session, error)
+	DeleteSession(id string) error
+	DeleteUserSessions(userID int) error
+	// DeleteExpiredSessions 删除已过期的会话
+	DeleteExpiredSessions(now string) (int, error)
+
+	// API 令牌相关
+	SaveAPIToken(token *models.APIToken) error
+	GetAllAPITokens() ([]*models.APIToken, error)
+	GetAPITokenByID(id int) (*models.APIToken, error)
+	GetAPITokenByHash(hash string) (*models.APIToken, error)
+	// TouchAPIToken 只更新最后使用时间和 IP
+	TouchAPIToken(id int, usedAt, ip string) error
+
+	// 审计日志相关，只允许追加
+	AppendAuditEntry(entry *models.AuditEntry) error
+	GetAuditEntries() ([]*models.AuditEntry, error)
+	AuditSequence() (int, error)
+
+	// Webhook 相关，投递记录只保留最近的 MaxWebhookDeliveries 条
+	SaveWebhook(hook *models.Webhook) error
+	GetAllWebhooks() ([]*models.Webhook, error)
+	GetWebhookByID(id int) (*models.Webhook, error)
+	DeleteWebhook(id int) error
+	AppendWebhookDelivery(delivery *models.WebhookDelivery) error
+	GetWebhookDeliveries(webhookID, limit int) ([]*models.WebhookDelivery, error)
+
+	// 存储状态，用于监控指标
+	Stats() (*StoreStats, error)
+
+	// 检查存储是否可用，用于就绪检查
+	Ping() error
+
 	// 关闭存储
 	Close() error
 